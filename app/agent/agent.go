@@ -1,18 +1,23 @@
 package agent
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
-	"io"
-	"net/http"
-	"os"
+	promptlib "rag/prompt"
 	"rag/types"
+	"strings"
 	"time"
-
-	"github.com/pkoukk/tiktoken-go"
 )
 
+// Шаблоны промптов; пока SetTemplates не вызван — только встроенные
+var templates *promptlib.Library
+
+// SetTemplates подключает библиотеку шаблонов с версиями из БД.
+// Вызывается один раз при запуске, до обработки запросов.
+func SetTemplates(lib *promptlib.Library) {
+	templates = lib
+}
+
 type CohereMessage struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
@@ -33,17 +38,8 @@ type CohereResponse struct {
 		} `json:"content"`
 	} `json:"message"`
 }
-type GenerateRequest struct {
-	Model  string `json:"model"`
-	System string `json:"system"`
-	Prompt string `json:"prompt"`
-	Stream bool   `json:"stream"`
-}
-
-type GenerateResponse struct {
-	Response string `json:"response"`
-}
 
+// ProcessFile исправляет текст моделью профиля cfg.
 func ProcessFile(data string, cfg types.LLMConfig) (string, error) {
 	start := time.Now()
 	defer func() {
@@ -52,184 +48,160 @@ func ProcessFile(data string, cfg types.LLMConfig) (string, error) {
 
 	fmt.Println("Startin process file...")
 
-	corrected, err := SendToOllama(MakePrompt(data), cfg)
-	if err != nil {
-		return "", err
-	}
-
-	return corrected, nil
-}
-
-func MakePrompt(text string) string {
-	return fmt.Sprintf(`
-Исправь орфографические, пунктуационные и грамматические ошибки в тексте.
-
-Текст:
-%s
-`, text)
-}
-
-func SendToOllama(prompt string, cfg types.LLMConfig) (string, error) {
-	payload := GenerateRequest{
-		Model:  cfg.Model,
-		System: cfg.PromptStr, //"Ты корректор русского языка. Сохрани стиль и смысл. Верни только исправленный текст без пояснений. Ничего не додумывай и не изменяй смысл.",
-		Prompt: prompt,
-		Stream: false,
-	}
-
-	body, err := json.Marshal(payload)
+	provider, err := NewProvider(cfg)
 	if err != nil {
 		return "", err
 	}
 
-	resp, err := http.Post(
-		cfg.Url,
-		"application/json",
-		bytes.NewBuffer(body),
-	)
+	prompt, err := MakePrompt(data)
 	if err != nil {
 		return "", err
 	}
-	defer resp.Body.Close()
 
-	respBody, err := io.ReadAll(resp.Body)
+	corrected, err := provider.Chat(context.Background(), []Message{
+		{Role: "system", Content: cfg.PromptStr}, //"Ты корректор русского языка. Сохрани стиль и смысл. Верни только исправленный текст без пояснений. Ничего не додумывай и не изменяй смысл.",
+		{Role: "user", Content: prompt},
+	})
 	if err != nil {
 		return "", err
 	}
 
-	var llmResp GenerateResponse
-	if err := json.Unmarshal(respBody, &llmResp); err != nil {
-		return "", err
-	}
+	return corrected, nil
+}
 
-	return llmResp.Response, nil
+// MakePrompt строит промпт корректуры по шаблону proofread.
+func MakePrompt(text string) (string, error) {
+	prompt, _, err := templates.Render(context.Background(), promptlib.Proofread, types.PromptVars{Text: text})
+	return prompt, err
 }
 
-func GenerateAnswerCohere(context string, question string, cfg types.LLMConfig) (string, error) {
-	url := "https://api.cohere.com/v2/chat"
-	apiKey := "cQ9H6hdb9sshZTtgMvEDgHB4DoopOLZ3YxFuW0AS"
+// GenerateAnswer отвечает на вопрос по контексту моделью профиля cfg.
+// vars — переменные шаблона ответа, history — предыдущие реплики диалога,
+// старые первыми; nil для одиночного вопроса. Возвращает ответ и версию
+// шаблона, по которой построен промпт.
+func GenerateAnswer(ctx context.Context, vars types.PromptVars, history []Message, cfg types.LLMConfig) (string, types.PromptRef, error) {
 	start := time.Now()
 	defer func() {
 		fmt.Printf("LLM answer tooks %v\n", time.Since(start))
 	}()
 
-	fmt.Println("Startin promt to LLM...")
-
-	prompt := fmt.Sprintf(`Контекст з декількох документів:
-Контекст:
-%s
-Запит:
-%s 
-Відповідь:`, context, question)
-
-	reqBody, _ := json.Marshal(CohereRequest{
-		Model:  "command-a-03-2025",
-		Stream: false,
-		Messages: []CohereMessage{
-			{
-				Role:    "system",
-				Content: cfg.PromptStr,
-			},
-			{
-				Role:    "user",
-				Content: prompt,
-			},
-		},
-	})
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(reqBody))
+	provider, messages, ref, err := answerRequest(ctx, vars, history, cfg)
 	if err != nil {
-		fmt.Println("Error to prompting Cohere")
-	}
-	req.Header.Set("Authorization", "Bearer "+apiKey)
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
-
-	fmt.Println("RAW RESPONSE:", string(body))
-	var coResp CohereResponse
-	_ = json.Unmarshal(body, &coResp)
-
-	var answer string
-	if len(coResp.Message.Content) > 0 {
-		answer = coResp.Message.Content[0].Text
+		return "", ref, err
 	}
-	return answer, nil
+	answer, err := provider.Chat(ctx, messages)
+	return answer, ref, err
 }
 
-func GenerateAnswer(context string, question string, cfg types.LLMConfig) (string, error) {
+// StreamAnswer отвечает на вопрос, передавая ответ в onToken по мере генерации.
+func StreamAnswer(ctx context.Context, vars types.PromptVars, history []Message, cfg types.LLMConfig, onToken TokenFunc) (string, types.PromptRef, error) {
 	start := time.Now()
 	defer func() {
 		fmt.Printf("LLM answer tooks %v\n", time.Since(start))
 	}()
 
-	fmt.Println("Startin promt to LLM...")
-
-	prompt := fmt.Sprintf(`Контекст из нескольких документов:
-Контекст:
-%s
-Вопрос:
-%s 
-Ответ:`, context, question)
+	provider, messages, ref, err := answerRequest(ctx, vars, history, cfg)
+	if err != nil {
+		return "", ref, err
+	}
+	answer, err := provider.ChatStream(ctx, messages, onToken)
+	return answer, ref, err
+}
 
-	reqBody, _ := json.Marshal(GenerateRequest{
-		Model:  os.Getenv("LLM_MODEL"),
-		System: cfg.PromptStr,
-		Prompt: prompt,
-		Stream: false,
-	})
+// answerRequest создаёт провайдера профиля и сообщения с историей диалога,
+// контекстом и вопросом. Контекст подставляется только в последний вопрос.
+func answerRequest(ctx context.Context, vars types.PromptVars, history []Message, cfg types.LLMConfig) (LLMProvider, []Message, types.PromptRef, error) {
+	fmt.Printf("Startin promt to LLM (profile %q, provider %q)...\n", cfg.Name, cfg.Provider)
 
-	count, _ := CountTokensLlama(reqBody)
-	fmt.Println("Size of Prompt with system in tokens:", count)
+	provider, err := NewProvider(cfg)
+	if err != nil {
+		return nil, nil, types.PromptRef{}, err
+	}
 
-	fmt.Println("Size of Prompt with system in symbols:", len(reqBody))
-	fmt.Println("-----------")
+	prompt, ref, err := templates.Render(ctx, answerTemplate(cfg), vars)
+	if err != nil {
+		return nil, nil, ref, err
+	}
 
-	// fmt.Println(prompt)
-	// return "ok", nil
+	messages := make([]Message, 0, len(history)+2)
+	messages = append(messages, Message{Role: "system", Content: cfg.PromptStr})
+	messages = append(messages, history...)
+	messages = append(messages, Message{Role: "user", Content: prompt})
 
-	resp, err := http.Post(cfg.Url,
-		"application/json",
-		bytes.NewBuffer(reqBody),
-	)
-	if err != nil {
-		return "", nil
+	count, window := countMessages(cfg, messages), ContextWindow(cfg)
+	fmt.Printf("Size of Prompt with system in tokens: %d of %d (%s)\n", count, window, Tokenizer(cfg))
+	if count+AnswerTokens(cfg) > window {
+		fmt.Printf("WARNING: prompt of profile %q leaves less than %d tokens for the answer\n", cfg.Name, AnswerTokens(cfg))
 	}
-	defer resp.Body.Close()
 
-	body, _ := io.ReadAll(resp.Body)
+	return provider, messages, ref, nil
+}
+
+// answerTemplate возвращает шаблон ответа профиля. Cohere отвечает
+// по украинскому шаблону, как до появления профилей.
+func answerTemplate(cfg types.LLMConfig) string {
+	if cfg.Provider == ProviderCohere {
+		return promptlib.AnswerCohere
+	}
+	return promptlib.Answer
+}
 
-	var genResp GenerateResponse
-	if err := json.Unmarshal(body, &genResp); err == nil && genResp.Response != "" {
-		return genResp.Response, err
+// CondenseQuestion переписывает уточняющий вопрос вроде «а для серии 11?»
+// в самостоятельный запрос для поиска контекста, используя историю диалога.
+// Старые реплики, не умещающиеся в окно модели, отбрасываются.
+// Без истории вопрос возвращается как есть.
+func CondenseQuestion(ctx context.Context, history []Message, question string, cfg types.LLMConfig) (string, error) {
+	if len(history) == 0 {
+		return question, nil
 	}
 
-	// Потоковый ответ: соберём всё в строку
-	type StreamChunk struct {
-		Response string `json:"response"`
+	provider, err := NewProvider(cfg)
+	if err != nil {
+		return "", err
 	}
-	var output string
-	decoder := json.NewDecoder(bytes.NewReader(body))
-	for decoder.More() {
-		var chunk StreamChunk
-		if err := decoder.Decode(&chunk); err == nil {
-			output += chunk.Response
+
+	var prompt string
+	for {
+		prompt, _, err = templates.Render(ctx, promptlib.Condense, types.PromptVars{
+			History:  FormatHistory(history),
+			Question: question,
+		})
+		if err != nil {
+			return "", err
+		}
+		if CountTokens(cfg, prompt) <= promptRoom(cfg) {
+			break
+		}
+		if history = history[1:]; len(history) == 0 {
+			fmt.Println("Condense prompt does not fit the model window, searching by the question as is")
+			return question, nil
 		}
 	}
-	return output, nil
 
+	start := time.Now()
+	condensed, err := provider.Chat(ctx, []Message{{Role: "user", Content: prompt}})
+	if err != nil {
+		return "", err
+	}
+	fmt.Printf("Condensed question %q -> %q in %v\n", question, condensed, time.Since(start))
+
+	condensed = strings.TrimSpace(condensed)
+	if condensed == "" {
+		return question, nil
+	}
+	return condensed, nil
 }
 
-func CountTokensLlama(data []byte) (int, error) {
-	enc, err := tiktoken.EncodingForModel("gpt-3.5-turbo") // Можно заменить на любую совместимую модель
-	if err != nil {
-		return 0, err
+// FormatHistory записывает реплики диалога по одной на строку
+// для переменной шаблона History.
+func FormatHistory(history []Message) string {
+	var dialog strings.Builder
+	for _, m := range history {
+		role := "Пользователь"
+		if m.Role == types.ChatRoleAssistant {
+			role = "Ассистент"
+		}
+		fmt.Fprintf(&dialog, "%s: %s\n", role, m.Content)
 	}
-	tokens := enc.Encode(string(data), nil, nil)
-	return len(tokens), nil
+	return dialog.String()
 }
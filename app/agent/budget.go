@@ -0,0 +1,251 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"rag/types"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/pkoukk/tiktoken-go"
+)
+
+const (
+	// Ollama по умолчанию запускает модели с окном OLLAMA_CONTEXT_LENGTH,
+	// в docker-compose — 4096, и молча обрезает более длинный промпт
+	defaultContextWindow = 4096
+	defaultAnswerTokens  = 1024
+	// Служебная разметка одного сообщения диалога
+	messageOverheadTokens = 4
+	// Запас для моделей, чей токенизатор считается чужой кодировкой
+	approxTokenMargin = 1.15
+	approxEncoding    = "cl100k_base"
+	// Оценка по длине текста, если кодировку не удалось загрузить
+	lengthEstimate = "~chars"
+	// История диалога занимает не больше этой доли окна: остальное нужно
+	// контексту, иначе длинные прошлые ответы вытесняют документы
+	historyShare = 0.25
+	// Под контекст всегда остаётся не меньше этой доли окна, даже за счёт
+	// запаса под ответ
+	minContextShare = 0.25
+)
+
+// Окна контекста моделей по префиксу имени; более длинные префиксы — раньше
+var modelWindows = []struct {
+	prefix string
+	window int
+}{
+	{"gpt-4o", 128000},
+	{"gpt-4.1", 1047576},
+	{"gpt-4-turbo", 128000},
+	{"gpt-4", 8192},
+	{"gpt-3.5-turbo", 16385},
+	{"o1", 200000},
+	{"o3", 200000},
+	{"o4", 200000},
+	{"command-a", 256000},
+	{"command-r", 128000},
+}
+
+// Загруженные кодировки; nil — загрузить не удалось, повторно не пробуем
+var (
+	encodingsMu sync.Mutex
+	encodings   = make(map[string]*tiktoken.Tiktoken)
+)
+
+// ContextWindow возвращает окно контекста профиля в токенах: заданное
+// в профиле, для Ollama — OLLAMA_CONTEXT_LENGTH, для известных моделей —
+// их окно, иначе 4096.
+func ContextWindow(cfg types.LLMConfig) int {
+	if cfg.ContextWindow > 0 {
+		return cfg.ContextWindow
+	}
+	if cfg.Provider == ProviderOllama || cfg.Provider == "" {
+		if n, err := strconv.Atoi(os.Getenv("OLLAMA_CONTEXT_LENGTH")); err == nil && n > 0 {
+			return n
+		}
+		return defaultContextWindow
+	}
+	model := strings.ToLower(cfg.Model)
+	for _, m := range modelWindows {
+		if strings.HasPrefix(model, m.prefix) {
+			return m.window
+		}
+	}
+	return defaultContextWindow
+}
+
+// AnswerTokens возвращает запас окна под ответ модели.
+func AnswerTokens(cfg types.LLMConfig) int {
+	if cfg.MaxAnswerTokens > 0 {
+		return cfg.MaxAnswerTokens
+	}
+	return defaultAnswerTokens
+}
+
+// Tokenizer возвращает имя кодировки, которой считаются токены профиля.
+// У моделей OpenAI кодировка своя; для остальных (Llama, Qwen, Command)
+// подсчёт приблизительный: cl100k_base с запасом, имя помечается «~».
+// Если кодировку не удалось загрузить, возвращает ~chars.
+func Tokenizer(cfg types.LLMConfig) string {
+	name, exact := approxEncoding, false
+	if cfg.Provider == ProviderOpenAI {
+		if enc, ok := tiktoken.MODEL_TO_ENCODING[cfg.Model]; ok {
+			name, exact = enc, true
+		}
+		for prefix, enc := range tiktoken.MODEL_PREFIX_TO_ENCODING {
+			if !exact && strings.HasPrefix(cfg.Model, prefix) {
+				name, exact = enc, true
+			}
+		}
+	}
+
+	if encoding(name) == nil {
+		return lengthEstimate
+	}
+	if !exact {
+		return "~" + name
+	}
+	return name
+}
+
+// CountTokens считает токены text в кодировке профиля.
+func CountTokens(cfg types.LLMConfig, text string) int {
+	name := Tokenizer(cfg)
+	if name == lengthEstimate {
+		// Кириллица занимает в среднем около двух символов на токен
+		return utf8.RuneCountInString(text)/2 + 1
+	}
+
+	n := len(encoding(strings.TrimPrefix(name, "~")).Encode(text, nil, nil))
+	if strings.HasPrefix(name, "~") {
+		n = int(float64(n)*approxTokenMargin) + 1
+	}
+	return n
+}
+
+// countMessages считает токены диалога вместе со служебной разметкой сообщений.
+func countMessages(cfg types.LLMConfig, messages []Message) int {
+	total := 0
+	for _, m := range messages {
+		total += CountTokens(cfg, m.Content) + messageOverheadTokens
+	}
+	return total
+}
+
+// encoding возвращает кодировку tiktoken или nil, если её не удалось
+// загрузить: файлы кодировок скачиваются при первом обращении
+// (каталог кэша — TIKTOKEN_CACHE_DIR).
+func encoding(name string) *tiktoken.Tiktoken {
+	encodingsMu.Lock()
+	defer encodingsMu.Unlock()
+
+	if enc, ok := encodings[name]; ok {
+		return enc
+	}
+	enc, err := tiktoken.GetEncoding(name)
+	if err != nil {
+		fmt.Printf("error loading tokenizer %s, estimating tokens by length: %s\n", name, err)
+		enc = nil
+	}
+	encodings[name] = enc
+	return enc
+}
+
+// TrimHistory оставляет последние реплики диалога, умещающиеся в долю окна
+// historyShare; если не умещается даже последняя, она обрезается с начала.
+// Возвращает оставшуюся историю и число отброшенных реплик.
+func TrimHistory(history []Message, cfg types.LLMConfig) ([]Message, int) {
+	limit := int(float64(ContextWindow(cfg)) * historyShare)
+
+	used, first := 0, len(history)
+	for i := len(history) - 1; i >= 0; i-- {
+		n := CountTokens(cfg, history[i].Content) + messageOverheadTokens
+		if used+n > limit {
+			break
+		}
+		used += n
+		first = i
+	}
+
+	if first == len(history) && len(history) > 0 {
+		last := history[len(history)-1]
+		last.Content = TruncateTokens(cfg, last.Content, limit-messageOverheadTokens, true)
+		return []Message{last}, len(history) - 1
+	}
+	return history[first:], first
+}
+
+// TruncateTokens обрезает text до limit токенов: с начала (fromStart)
+// или с конца.
+func TruncateTokens(cfg types.LLMConfig, text string, limit int, fromStart bool) string {
+	if limit <= 0 {
+		return ""
+	}
+	if CountTokens(cfg, text) <= limit {
+		return text
+	}
+
+	runes := []rune(text)
+	cut := func(n int) string {
+		if fromStart {
+			return string(runes[len(runes)-n:])
+		}
+		return string(runes[:n])
+	}
+	// Наибольшая длина в символах, которая ещё умещается
+	lo, hi := 0, len(runes)
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if CountTokens(cfg, cut(mid)) <= limit {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	return cut(lo)
+}
+
+// promptRoom возвращает, сколько токенов окна модели остаётся после
+// запаса под ответ.
+func promptRoom(cfg types.LLMConfig) int {
+	return max(ContextWindow(cfg)-AnswerTokens(cfg), 0)
+}
+
+// ContextBudget считает, сколько токенов окна модели остаётся под контекст
+// в промпте ответа: из окна вычитаются запас под ответ, системный промпт,
+// история диалога и шаблон ответа с вопросом без контекста. История должна
+// быть уже урезана TrimHistory. Под контекст остаётся не меньше доли окна
+// minContextShare — при нехватке места уменьшается запас под ответ.
+func ContextBudget(ctx context.Context, vars types.PromptVars, history []Message, cfg types.LLMConfig) types.TokenBudget {
+	vars.Context = ""
+	prompt, _, err := templates.Render(ctx, answerTemplate(cfg), vars)
+	if err != nil {
+		// Ошибку шаблона вернёт генерация ответа; здесь считаем хотя бы вопрос
+		prompt = vars.Question
+	}
+
+	messages := make([]Message, 0, len(history)+2)
+	messages = append(messages, Message{Role: "system", Content: cfg.PromptStr})
+	messages = append(messages, history...)
+	messages = append(messages, Message{Role: "user", Content: prompt})
+
+	budget := types.TokenBudget{
+		Window:        ContextWindow(cfg),
+		AnswerReserve: AnswerTokens(cfg),
+		Prompt:        countMessages(cfg, messages),
+		Tokenizer:     Tokenizer(cfg),
+	}
+	budget.ContextBudget = budget.Window - budget.AnswerReserve - budget.Prompt
+
+	if minContext := int(float64(budget.Window) * minContextShare); budget.ContextBudget < minContext {
+		budget.ContextBudget = minContext
+		budget.AnswerReserve = max(budget.Window-budget.Prompt-minContext, 0)
+		fmt.Printf("Prompt of profile %q takes %d of %d tokens: answer reserve cut to %d to keep %d for context\n",
+			cfg.Name, budget.Prompt, budget.Window, budget.AnswerReserve, minContext)
+	}
+	return budget
+}
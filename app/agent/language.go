@@ -0,0 +1,147 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	promptlib "rag/prompt"
+	"rag/types"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Языки называются так же, как в интерфейсе (public/index.html)
+const (
+	LangRussian    = "russian"
+	LangUkrainian  = "ukrainian"
+	LangEnglish    = "english"
+	LangSpanish    = "spanish"
+	LangFrench     = "french"
+	LangGerman     = "german"
+	LangItalian    = "italian"
+	LangPortuguese = "portuguese"
+	LangPolish     = "polish"
+	LangCzech      = "czech"
+)
+
+// Буквы и знаки, которые встречаются только в одном из языков
+var languageMarkers = []struct {
+	lang    string
+	letters string
+}{
+	{LangUkrainian, "іїєґ"},
+	{LangRussian, "ыэъё"},
+	{LangPolish, "łąęśźżńć"},
+	{LangCzech, "řěů"},
+	{LangGerman, "ßäöü"},
+	{LangSpanish, "ñ¿¡"},
+	{LangPortuguese, "ãõ"},
+	{LangFrench, "çœèêëîïûù"},
+	{LangItalian, "ìò"},
+}
+
+// Частые слова, которые есть только в одном из двух языков: короткий
+// украинский вопрос часто обходится без букв іїєґ
+var cyrillicWords = map[string]string{
+	"як": LangUkrainian, "що": LangUkrainian, "це": LangUkrainian, "чи": LangUkrainian,
+	"або": LangUkrainian, "який": LangUkrainian, "яка": LangUkrainian, "яке": LangUkrainian,
+	"щоб": LangUkrainian, "мені": LangUkrainian, "потрібно": LangUkrainian, "можна": LangUkrainian,
+	"как": LangRussian, "что": LangRussian, "это": LangRussian, "или": LangRussian,
+	"какой": LangRussian, "какая": LangRussian, "какое": LangRussian, "чтобы": LangRussian,
+	"мне": LangRussian, "нужно": LangRussian, "можно": LangRussian,
+}
+
+// Частые слова латиницей, которые есть только в одном языке: итальянский
+// текст часто обходится без букв с диакритикой, а «è» есть и во французском
+var latinWords = map[string]string{
+	"les": LangFrench, "des": LangFrench, "est": LangFrench, "une": LangFrench,
+	"pour": LangFrench, "avec": LangFrench, "dans": LangFrench, "comment": LangFrench,
+	"è": LangItalian, "dov'è": LangItalian, "cos'è": LangItalian, "che": LangItalian, "gli": LangItalian, "della": LangItalian, "delle": LangItalian,
+	"dello": LangItalian, "degli": LangItalian, "sono": LangItalian, "questo": LangItalian,
+	"questa": LangItalian, "quale": LangItalian, "quali": LangItalian, "perché": LangItalian,
+	"anche": LangItalian, "essere": LangItalian, "nella": LangItalian, "nel": LangItalian,
+}
+
+// DetectLanguage определяет язык текста по алфавиту, характерным буквам
+// и частым словам. Кириллица без признаков украинского считается русским,
+// латиница без характерных букв — английским. Латинские термины в русском
+// вопросе не меняют его языка: кириллице достаточно трети букв.
+// Для текста без букв возвращает пустую строку.
+func DetectLanguage(text string) string {
+	var cyrillic, latin int
+	markers := make(map[string]int)
+	for _, r := range strings.ToLower(text) {
+		for _, m := range languageMarkers {
+			if strings.ContainsRune(m.letters, r) {
+				markers[m.lang]++
+			}
+		}
+		switch {
+		case unicode.Is(unicode.Cyrillic, r):
+			cyrillic++
+		case unicode.Is(unicode.Latin, r):
+			latin++
+		}
+	}
+
+	if cyrillic == 0 && latin == 0 {
+		return ""
+	}
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	})
+	if cyrillic*2 >= latin {
+		for _, w := range words {
+			if lang, ok := cyrillicWords[w]; ok {
+				markers[lang]++
+			}
+		}
+		if markers[LangUkrainian] > markers[LangRussian] {
+			return LangUkrainian
+		}
+		return LangRussian
+	}
+
+	for _, w := range words {
+		if lang, ok := latinWords[w]; ok {
+			markers[lang]++
+		}
+	}
+	best, bestCount := LangEnglish, 0
+	for _, m := range languageMarkers {
+		if n := markers[m.lang]; n > bestCount && m.lang != LangUkrainian && m.lang != LangRussian {
+			best, bestCount = m.lang, n
+		}
+	}
+	return best
+}
+
+// TranslateQuery переводит вопрос на язык lang моделью профиля cfg
+// по шаблону translate.
+func TranslateQuery(ctx context.Context, question, lang string, cfg types.LLMConfig) (string, error) {
+	provider, err := NewProvider(cfg)
+	if err != nil {
+		return "", err
+	}
+
+	prompt, _, err := templates.Render(ctx, promptlib.Translate, types.PromptVars{
+		Question: question,
+		Language: lang,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	start := time.Now()
+	translated, err := provider.Chat(ctx, []Message{{Role: "user", Content: prompt}})
+	if err != nil {
+		return "", err
+	}
+	translated = strings.TrimSpace(translated)
+	fmt.Printf("Translated question %q -> %q in %v\n", question, translated, time.Since(start))
+
+	if translated == "" {
+		return question, nil
+	}
+	return translated, nil
+}
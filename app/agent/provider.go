@@ -0,0 +1,482 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"rag/types"
+	"regexp"
+	"strings"
+)
+
+// Провайдеры LLM в колонке config.provider
+const (
+	ProviderOllama = "ollama"
+	ProviderOpenAI = "openai"
+	ProviderCohere = "cohere"
+)
+
+const (
+	cohereChatURL = "https://api.cohere.com/v2/chat"
+	// Модель Cohere, если в профиле она не задана
+	cohereDefaultModel = "command-a-03-2025"
+)
+
+// Каталог docker/k8s secrets
+var secretsDir = "/run/secrets"
+
+// Message — сообщение диалога с моделью.
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// TokenFunc получает очередной фрагмент ответа; ошибка прерывает генерацию.
+type TokenFunc func(token string) error
+
+// LLMProvider отправляет диалог модели и возвращает ответ.
+type LLMProvider interface {
+	Chat(ctx context.Context, messages []Message) (string, error)
+	// ChatStream передаёт ответ в onToken по мере генерации и возвращает его целиком.
+	// Отмена ctx обрывает запрос к модели.
+	ChatStream(ctx context.Context, messages []Message, onToken TokenFunc) (string, error)
+}
+
+// NewProvider создаёт провайдера по профилю из таблицы config.
+// Ключ API в БД не хранится: профиль ссылается на него по имени (api_key_ref).
+func NewProvider(cfg types.LLMConfig) (LLMProvider, error) {
+	apiKey, err := LoadCredential(cfg.APIKeyRef)
+	if err != nil {
+		return nil, err
+	}
+
+	switch cfg.Provider {
+	case ProviderOllama, "":
+		model := cfg.Model
+		if model == "" {
+			model = os.Getenv("LLM_MODEL")
+		}
+		return &OllamaProvider{url: ollamaChatURL(cfg.Url), model: model, numCtx: cfg.ContextWindow}, nil
+	case ProviderOpenAI:
+		url := strings.TrimRight(cfg.Url, "/")
+		if !strings.HasSuffix(url, "/chat/completions") {
+			url += "/chat/completions"
+		}
+		return &OpenAIProvider{url: url, model: cfg.Model, apiKey: apiKey}, nil
+	case ProviderCohere:
+		url := cfg.Url
+		if url == "" {
+			url = cohereChatURL
+		}
+		if apiKey == "" {
+			return nil, fmt.Errorf("profile %q: cohere requires api_key_ref", cfg.Name)
+		}
+		model := cfg.Model
+		if model == "" {
+			model = cohereDefaultModel
+		}
+		return &CohereProvider{url: url, model: model, apiKey: apiKey}, nil
+	}
+	return nil, fmt.Errorf("profile %q: unknown provider %q", cfg.Name, cfg.Provider)
+}
+
+// CredentialPrefix — обязательный префикс имени ключа API. Профиль задаётся
+// через API, поэтому по ссылке из профиля можно прочитать только переменные
+// и секреты, заведённые специально под ключи, а не PG_PASS и т.п.
+const CredentialPrefix = "RAG_KEY_"
+
+var credentialRefRe = regexp.MustCompile(`^` + CredentialPrefix + `[A-Z0-9_]+$`)
+
+// ValidCredentialRef проверяет имя ключа API: RAG_KEY_ и дальше только
+// заглавные латинские буквы, цифры и подчёркивание. Пустое имя допустимо.
+func ValidCredentialRef(ref string) bool {
+	return ref == "" || credentialRefRe.MatchString(ref)
+}
+
+// LoadCredential возвращает секрет по имени ref: из переменной окружения ref
+// или из файла /run/secrets/<ref в нижнем регистре>. Пустой ref означает,
+// что ключ не нужен.
+func LoadCredential(ref string) (string, error) {
+	if ref == "" {
+		return "", nil
+	}
+	if !ValidCredentialRef(ref) {
+		return "", fmt.Errorf("invalid credential name %q: want %s[A-Z0-9_]+", ref, CredentialPrefix)
+	}
+	if v := os.Getenv(ref); v != "" {
+		return v, nil
+	}
+
+	var data []byte
+	path, err := secretPath(strings.ToLower(ref))
+	if err == nil {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("credential %s is not set", ref)
+		}
+		return "", fmt.Errorf("reading credential %s: %w", ref, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// secretPath возвращает путь к файлу секрета name, если это обычный файл
+// непосредственно в каталоге секретов. Ссылки допускаются только внутри
+// каталога: так k8s подкладывает секреты через ..data.
+func secretPath(name string) (string, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.Contains(name, "..") {
+		return "", fmt.Errorf("invalid secret name %q", name)
+	}
+
+	dir, err := filepath.EvalSymlinks(secretsDir)
+	if err != nil {
+		return "", os.ErrNotExist
+	}
+	path, err := filepath.EvalSymlinks(filepath.Join(dir, name))
+	if err != nil {
+		return "", os.ErrNotExist
+	}
+	if rel, err := filepath.Rel(dir, path); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("secret %s points outside %s", name, secretsDir)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("secret %s is not a regular file", name)
+	}
+	return path, nil
+}
+
+// ollamaChatURL приводит адрес из профиля к /api/chat: в старых профилях
+// записан адрес /api/generate или только хост.
+func ollamaChatURL(url string) string {
+	url = strings.TrimRight(url, "/")
+	switch {
+	case strings.HasSuffix(url, "/api/chat"):
+		return url
+	case strings.HasSuffix(url, "/api/generate"):
+		return strings.TrimSuffix(url, "/api/generate") + "/api/chat"
+	}
+	return url + "/api/chat"
+}
+
+// OllamaProvider работает через Ollama /api/chat.
+type OllamaProvider struct {
+	url    string
+	model  string
+	numCtx int // Окно контекста из профиля; 0 — окно сервера Ollama
+}
+
+type ollamaChatRequest struct {
+	Model    string         `json:"model"`
+	Messages []Message      `json:"messages"`
+	Stream   bool           `json:"stream"`
+	Options  *ollamaOptions `json:"options,omitempty"`
+}
+
+type ollamaOptions struct {
+	NumCtx int `json:"num_ctx,omitempty"`
+}
+
+// options возвращает параметры модели для запроса: окно контекста,
+// если оно задано в профиле.
+func (p *OllamaProvider) options() *ollamaOptions {
+	if p.numCtx <= 0 {
+		return nil
+	}
+	return &ollamaOptions{NumCtx: p.numCtx}
+}
+
+type ollamaChatResponse struct {
+	Message Message `json:"message"`
+	Error   string  `json:"error"`
+	Done    bool    `json:"done"`
+}
+
+func (p *OllamaProvider) Chat(ctx context.Context, messages []Message) (string, error) {
+	var resp ollamaChatResponse
+	err := postJSON(ctx, p.url, "", ollamaChatRequest{
+		Model:    p.model,
+		Messages: messages,
+		Stream:   false,
+		Options:  p.options(),
+	}, &resp)
+	if err != nil {
+		return "", err
+	}
+	if resp.Error != "" {
+		return "", fmt.Errorf("ollama: %s", resp.Error)
+	}
+	return resp.Message.Content, nil
+}
+
+// ChatStream читает потоковый ответ Ollama: по объекту JSON на строку.
+func (p *OllamaProvider) ChatStream(ctx context.Context, messages []Message, onToken TokenFunc) (string, error) {
+	body, err := postStream(ctx, p.url, "", ollamaChatRequest{
+		Model:    p.model,
+		Messages: messages,
+		Stream:   true,
+		Options:  p.options(),
+	})
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	var answer strings.Builder
+	decoder := json.NewDecoder(body)
+	for {
+		var chunk ollamaChatResponse
+		if err := decoder.Decode(&chunk); err != nil {
+			if errors.Is(err, io.EOF) {
+				return answer.String(), nil
+			}
+			return answer.String(), err
+		}
+		if chunk.Error != "" {
+			return answer.String(), fmt.Errorf("ollama: %s", chunk.Error)
+		}
+		if chunk.Message.Content != "" {
+			answer.WriteString(chunk.Message.Content)
+			if err := onToken(chunk.Message.Content); err != nil {
+				return answer.String(), err
+			}
+		}
+		if chunk.Done {
+			return answer.String(), nil
+		}
+	}
+}
+
+// OpenAIProvider работает с любым OpenAI-совместимым /v1/chat/completions
+// (OpenAI, vLLM, LocalAI, llama.cpp server).
+type OpenAIProvider struct {
+	url    string
+	model  string
+	apiKey string
+}
+
+type openAIChatRequest struct {
+	Model    string    `json:"model"`
+	Messages []Message `json:"messages"`
+	Stream   bool      `json:"stream"`
+}
+
+type openAIChatResponse struct {
+	Choices []struct {
+		Message Message `json:"message"`
+		Delta   Message `json:"delta"`
+	} `json:"choices"`
+}
+
+func (p *OpenAIProvider) Chat(ctx context.Context, messages []Message) (string, error) {
+	var resp openAIChatResponse
+	err := postJSON(ctx, p.url, p.apiKey, openAIChatRequest{
+		Model:    p.model,
+		Messages: messages,
+		Stream:   false,
+	}, &resp)
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Choices) == 0 {
+		return "", errors.New("openai: empty response")
+	}
+	return resp.Choices[0].Message.Content, nil
+}
+
+// ChatStream читает SSE-поток chat.completion.chunk до "data: [DONE]".
+func (p *OpenAIProvider) ChatStream(ctx context.Context, messages []Message, onToken TokenFunc) (string, error) {
+	body, err := postStream(ctx, p.url, p.apiKey, openAIChatRequest{
+		Model:    p.model,
+		Messages: messages,
+		Stream:   true,
+	})
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	var answer strings.Builder
+	err = readSSE(body, func(data string) (bool, error) {
+		if data == "[DONE]" {
+			return true, nil
+		}
+		var chunk openAIChatResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return false, err
+		}
+		if len(chunk.Choices) == 0 || chunk.Choices[0].Delta.Content == "" {
+			return false, nil
+		}
+		token := chunk.Choices[0].Delta.Content
+		answer.WriteString(token)
+		return false, onToken(token)
+	})
+	return answer.String(), err
+}
+
+// CohereProvider работает через Cohere /v2/chat.
+type CohereProvider struct {
+	url    string
+	model  string
+	apiKey string
+}
+
+func (p *CohereProvider) Chat(ctx context.Context, messages []Message) (string, error) {
+	msgs := make([]CohereMessage, len(messages))
+	for i, m := range messages {
+		msgs[i] = CohereMessage{Role: m.Role, Content: m.Content}
+	}
+
+	var resp CohereResponse
+	err := postJSON(ctx, p.url, p.apiKey, CohereRequest{
+		Model:    p.model,
+		Messages: msgs,
+		Stream:   false,
+	}, &resp)
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Message.Content) == 0 {
+		return "", errors.New("cohere: empty response")
+	}
+	return resp.Message.Content[0].Text, nil
+}
+
+type cohereStreamEvent struct {
+	Type  string `json:"type"`
+	Delta struct {
+		Message struct {
+			Content struct {
+				Text string `json:"text"`
+			} `json:"content"`
+		} `json:"message"`
+	} `json:"delta"`
+}
+
+// ChatStream читает SSE-поток Cohere v2: текст приходит в событиях content-delta.
+func (p *CohereProvider) ChatStream(ctx context.Context, messages []Message, onToken TokenFunc) (string, error) {
+	msgs := make([]CohereMessage, len(messages))
+	for i, m := range messages {
+		msgs[i] = CohereMessage{Role: m.Role, Content: m.Content}
+	}
+
+	body, err := postStream(ctx, p.url, p.apiKey, CohereRequest{
+		Model:    p.model,
+		Messages: msgs,
+		Stream:   true,
+	})
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	var answer strings.Builder
+	err = readSSE(body, func(data string) (bool, error) {
+		var ev cohereStreamEvent
+		if err := json.Unmarshal([]byte(data), &ev); err != nil {
+			return false, err
+		}
+		switch ev.Type {
+		case "message-end":
+			return true, nil
+		case "content-delta":
+			token := ev.Delta.Message.Content.Text
+			answer.WriteString(token)
+			return false, onToken(token)
+		}
+		return false, nil
+	})
+	return answer.String(), err
+}
+
+// postJSON отправляет запрос и разбирает ответ; ответ не 2xx считается ошибкой.
+func postJSON(ctx context.Context, url, apiKey string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("LLM API error: status %d, body: %s", resp.StatusCode, string(respBody))
+	}
+	return json.Unmarshal(respBody, out)
+}
+
+// postStream отправляет запрос и возвращает тело потокового ответа.
+func postStream(ctx context.Context, url, apiKey string, in any) (io.ReadCloser, error) {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream, application/x-ndjson")
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("LLM API error: status %d, body: %s", resp.StatusCode, string(respBody))
+	}
+	return resp.Body, nil
+}
+
+// readSSE вызывает handle для поля data каждого события, пока handle
+// не вернёт true или ошибку либо поток не закончится.
+func readSSE(r io.Reader, handle func(data string) (bool, error)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		done, err := handle(strings.TrimSpace(data))
+		if err != nil || done {
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,191 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	promptlib "rag/prompt"
+	"rag/types"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	// Граница предложения: . ! ? перед заглавной буквой или цифрой,
+	// чтобы не резать по сокращениям вроде «руб. в месяц»
+	sentenceEndRe = regexp.MustCompile(`([.!?…])\s+(\p{Lu}|\d)`)
+	// Маркеры списков, цитат и заголовков markdown в начале строки
+	listMarkerRe = regexp.MustCompile(`^\s*(?:[-*+>#]+|\d+[.)])?\s*`)
+	// Ссылки на источники [1], [2, 3] вместе с пробелом перед ними
+	claimCitationRe = regexp.MustCompile(`\s*\[\d+(?:\s*,\s*\d+)*\]`)
+	// Числа (1 250,50; 3.5) и коды вида ТП-11, A320, 2.3.1
+	claimValueRe = regexp.MustCompile(`\p{L}*-?\d+(?:[.,]\d+|\s\d{3}\b)*`)
+	// Пробелы внутри чисел: 1 250 → 1250
+	numberSpaceRe = regexp.MustCompile(`(\d)\s+(\d)`)
+)
+
+// Утверждения короче этого числа слов (заголовки, «Да.») не проверяются
+const minClaimWords = 3
+
+// VerifyAnswer разбивает ответ на утверждения и проверяет каждое по контексту:
+// числа и коды из утверждения должны встречаться в контексте как отдельные
+// значения, а судья (модель профиля cfg) оценивает, следует ли утверждение
+// из контекста. Если судья недоступен, остаётся только сверка значений;
+// утверждения без значений остаются непроверенными и снижают оценку.
+// Если не проверено ни одно утверждение, Score — nil.
+func VerifyAnswer(ctx context.Context, promptContext string, answer string, cfg types.LLMConfig) *types.Grounding {
+	start := time.Now()
+	claims := SplitClaims(answer)
+	grounding := &types.Grounding{Claims: make([]types.ClaimCheck, len(claims))}
+	if len(claims) == 0 {
+		return grounding
+	}
+
+	verdicts, err := judgeClaims(ctx, promptContext, claims, cfg)
+	if err != nil {
+		fmt.Println("error judging answer claims:", err)
+	}
+
+	contextValues := make(map[string]bool)
+	for _, v := range claimValueRe.FindAllString(promptContext, -1) {
+		contextValues[normalizeValues(v)] = true
+	}
+
+	var score float64
+	checked := 0
+	for i, claim := range claims {
+		check := types.ClaimCheck{Claim: claim, Status: types.ClaimUnverified}
+		if v, ok := verdicts[i+1]; ok {
+			check.Status = v
+		}
+
+		values := claimValueRe.FindAllString(claim, -1)
+		for _, v := range values {
+			// Сравниваются значения целиком: «20» не подтверждается «2020» или «20.5»
+			if !contextValues[normalizeValues(v)] {
+				check.MissingValues = append(check.MissingValues, v)
+			}
+		}
+		switch {
+		case len(check.MissingValues) > 0 && len(check.MissingValues) == len(values):
+			// Ни одно значение не найдено — выдумано, что бы ни сказал судья
+			check.Status = types.ClaimUnsupported
+		case len(check.MissingValues) > 0:
+			if check.Status != types.ClaimUnsupported {
+				check.Status = types.ClaimPartial
+			}
+		case check.Status == types.ClaimUnverified && len(values) > 0:
+			// Судья не ответил, но все значения есть в контексте
+			check.Status = types.ClaimSupported
+		}
+
+		switch check.Status {
+		case types.ClaimSupported:
+			score++
+		case types.ClaimPartial:
+			score += 0.5
+		}
+		if check.Status != types.ClaimUnverified {
+			checked++
+		}
+		grounding.Claims[i] = check
+	}
+
+	if checked == 0 {
+		fmt.Printf("Could not verify any of %d claims in %v\n", len(claims), time.Since(start))
+		return grounding
+	}
+	score /= float64(len(claims))
+	grounding.Score = &score
+	fmt.Printf("Verified %d of %d claims in %v, grounded score %.2f\n", checked, len(claims), time.Since(start), score)
+	return grounding
+}
+
+// SplitClaims делит ответ на предложения-утверждения без ссылок на источники
+// и разметки списков.
+func SplitClaims(answer string) []string {
+	var claims []string
+	for _, line := range strings.Split(answer, "\n") {
+		line = claimCitationRe.ReplaceAllString(listMarkerRe.ReplaceAllString(line, ""), "")
+		if line == "" {
+			continue
+		}
+		for _, sentence := range strings.Split(sentenceEndRe.ReplaceAllString(line, "$1\n$2"), "\n") {
+			sentence = strings.Join(strings.Fields(sentence), " ")
+			sentence = strings.Trim(sentence, "*_ ")
+			if len(strings.Fields(sentence)) < minClaimWords {
+				continue
+			}
+			claims = append(claims, sentence)
+		}
+	}
+	return claims
+}
+
+// normalizeValues приводит числа к одному виду для сравнения:
+// нижний регистр, десятичная точка, без пробелов (в т.ч. неразрывных)
+// между разрядами.
+func normalizeValues(s string) string {
+	s = strings.ToLower(strings.ReplaceAll(s, "\u00a0", " "))
+	s = numberSpaceRe.ReplaceAllString(s, "$1$2")
+	return strings.ReplaceAll(s, ",", ".")
+}
+
+type claimVerdict struct {
+	N      int    `json:"n"`
+	Status string `json:"status"`
+}
+
+// judgeClaims просит модель оценить все утверждения одним запросом
+// и возвращает статусы по номерам утверждений (с 1). Контекст урезается
+// до окна модели судьи, которое может быть меньше окна модели ответа.
+func judgeClaims(ctx context.Context, promptContext string, claims []string, cfg types.LLMConfig) (map[int]types.ClaimStatus, error) {
+	provider, err := NewProvider(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	vars := types.PromptVars{Claims: claims}
+	frame, _, err := templates.Render(ctx, promptlib.Verify, vars)
+	if err != nil {
+		return nil, err
+	}
+	room := promptRoom(cfg) - CountTokens(cfg, frame)
+	if room <= 0 {
+		return nil, fmt.Errorf("verify prompt of %d claims does not fit the window of profile %q", len(claims), cfg.Name)
+	}
+	vars.Context = TruncateTokens(cfg, promptContext, room, false)
+	if len(vars.Context) < len(promptContext) {
+		fmt.Printf("Verify context cut to %d tokens to fit the window of profile %q\n", room, cfg.Name)
+	}
+
+	prompt, _, err := templates.Render(ctx, promptlib.Verify, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := provider.Chat(ctx, []Message{{Role: "user", Content: prompt}})
+	if err != nil {
+		return nil, err
+	}
+
+	// Модели часто оборачивают JSON в текст или ```json
+	start, end := strings.Index(out, "["), strings.LastIndex(out, "]")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("judge returned no JSON array: %q", out)
+	}
+	var verdicts []claimVerdict
+	if err := json.Unmarshal([]byte(out[start:end+1]), &verdicts); err != nil {
+		return nil, fmt.Errorf("parsing judge response: %w", err)
+	}
+
+	result := make(map[int]types.ClaimStatus, len(verdicts))
+	for _, v := range verdicts {
+		switch status := types.ClaimStatus(v.Status); status {
+		case types.ClaimSupported, types.ClaimPartial, types.ClaimUnsupported:
+			result[v.N] = status
+		}
+	}
+	return result, nil
+}
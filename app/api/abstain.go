@@ -0,0 +1,187 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"rag/app/agent"
+	"rag/types"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+const defaultUnansweredLimit = 100
+
+// Ответ без контекста, заголовок списка ближайших документов и отказ
+// после проверки ответа (VERIFY_ANSWERS=refuse) по языкам
+var noAnswerMessages = map[string]struct {
+	answer  string
+	header  string
+	refusal string
+}{
+	agent.LangRussian: {
+		"В документации не найдено информации по этому вопросу. Попробуйте переформулировать вопрос или посмотрите документы, близкие к нему по теме.",
+		"Возможно, полезны документы:",
+		"В найденных документах нет подтверждённых данных для ответа на этот вопрос.",
+	},
+	agent.LangUkrainian: {
+		"У документації не знайдено інформації з цього питання. Спробуйте переформулювати питання або перегляньте документи, близькі до нього за темою.",
+		"Можливо, корисні документи:",
+		"У знайдених документах немає підтверджених даних для відповіді на це питання.",
+	},
+	agent.LangEnglish: {
+		"The documentation has no information on this question. Try rephrasing it or look through the documents on related topics.",
+		"These documents may help:",
+		"The documents found contain no confirmed information to answer this question.",
+	},
+	agent.LangSpanish: {
+		"La documentación no contiene información sobre esta pregunta. Intente reformularla o consulte los documentos sobre temas relacionados.",
+		"Estos documentos pueden ser útiles:",
+		"Los documentos encontrados no contienen datos confirmados para responder a esta pregunta.",
+	},
+	agent.LangFrench: {
+		"La documentation ne contient aucune information sur cette question. Essayez de la reformuler ou consultez les documents sur des sujets proches.",
+		"Ces documents peuvent être utiles :",
+		"Les documents trouvés ne contiennent aucune donnée confirmée pour répondre à cette question.",
+	},
+	agent.LangGerman: {
+		"Die Dokumentation enthält keine Informationen zu dieser Frage. Formulieren Sie die Frage um oder sehen Sie sich Dokumente zu verwandten Themen an.",
+		"Diese Dokumente könnten helfen:",
+		"Die gefundenen Dokumente enthalten keine bestätigten Angaben zur Beantwortung dieser Frage.",
+	},
+	agent.LangItalian: {
+		"La documentazione non contiene informazioni su questa domanda. Prova a riformularla o consulta i documenti su argomenti vicini.",
+		"Questi documenti potrebbero essere utili:",
+		"I documenti trovati non contengono dati confermati per rispondere a questa domanda.",
+	},
+	agent.LangPortuguese: {
+		"A documentação não contém informações sobre esta pergunta. Tente reformulá-la ou consulte os documentos sobre temas relacionados.",
+		"Estes documentos podem ajudar:",
+		"Os documentos encontrados não contêm dados confirmados para responder a esta pergunta.",
+	},
+	agent.LangPolish: {
+		"Dokumentacja nie zawiera informacji na ten temat. Spróbuj przeformułować pytanie lub przejrzyj dokumenty o podobnej tematyce.",
+		"Te dokumenty mogą być pomocne:",
+		"Znalezione dokumenty nie zawierają potwierdzonych danych, aby odpowiedzieć na to pytanie.",
+	},
+	agent.LangCzech: {
+		"Dokumentace neobsahuje informace k této otázce. Zkuste otázku přeformulovat nebo si prohlédněte dokumenty na příbuzná témata.",
+		"Tyto dokumenty mohou pomoci:",
+		"Nalezené dokumenty neobsahují ověřené údaje pro odpověď na tuto otázku.",
+	},
+}
+
+// noAnswerMessage возвращает ответ на языке lang на вопрос, для которого
+// не нашлось контекста. NO_ANSWER_MESSAGE заменяет сообщение на языке
+// документов; для неизвестного языка берётся язык документов.
+func noAnswerMessage(lang string) string {
+	if _, ok := noAnswerMessages[lang]; !ok {
+		lang = corpusLanguage()
+	}
+	if msg := os.Getenv("NO_ANSWER_MESSAGE"); msg != "" && lang == corpusLanguage() {
+		return msg
+	}
+	if m, ok := noAnswerMessages[lang]; ok {
+		return m.answer
+	}
+	return noAnswerMessages[agent.LangRussian].answer
+}
+
+// suggestionsHeader возвращает заголовок списка ближайших документов
+// на языке lang.
+func suggestionsHeader(lang string) string {
+	if m, ok := noAnswerMessages[lang]; ok {
+		return m.header
+	}
+	if m, ok := noAnswerMessages[corpusLanguage()]; ok {
+		return m.header
+	}
+	return noAnswerMessages[agent.LangRussian].header
+}
+
+// refusalMessage возвращает на языке lang отказ отвечать, если ответ
+// не подтвердился проверкой по контексту.
+func refusalMessage(lang string) string {
+	if m, ok := noAnswerMessages[lang]; ok {
+		return m.refusal
+	}
+	if m, ok := noAnswerMessages[corpusLanguage()]; ok {
+		return m.refusal
+	}
+	return noAnswerMessages[agent.LangRussian].refusal
+}
+
+// noContext возвращает пустой результат поиска с ближайшими документами
+// и разделами из чанков, не прошедших фильтр по близости.
+func (h *RequestHandler) noContext(ctx context.Context, chunks []types.Chunk) *retrieval {
+	found := &retrieval{suggestions: []types.Suggestion{}}
+
+	type place struct {
+		docID   uuid.UUID
+		section string
+	}
+	seen := make(map[place]bool)
+	titles := make(map[uuid.UUID]string)
+	for _, ch := range chunks {
+		found.bestDistance = max(found.bestDistance, ch.Distance)
+
+		key := place{ch.DocID, ch.Section}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		title, ok := titles[ch.DocID]
+		if !ok {
+			doc, err := h.contextStore.GetDocumentByID(ctx, ch.DocID)
+			if err != nil {
+				fmt.Printf("error loading document %s for suggestions: %s\n", ch.DocID, err)
+				continue
+			}
+			title = doc.Title
+			titles[ch.DocID] = title
+		}
+
+		found.suggestions = append(found.suggestions, types.Suggestion{
+			DocID:    ch.DocID.String(),
+			Title:    title,
+			Section:  ch.Section,
+			Distance: ch.Distance,
+		})
+	}
+	return found
+}
+
+// logUnanswered сохраняет вопрос без ответа для анализа пробелов
+// в документации. Ошибка сохранения не мешает ответить пользователю.
+func (h *RequestHandler) logUnanswered(ctx context.Context, q types.UnansweredQuestion) {
+	q.ID = uuid.New()
+	fmt.Printf("No relevant context for question %q (best distance %.4f)\n", q.Question, q.BestDistance)
+	if err := h.contextStore.LogUnansweredQuestion(ctx, q); err != nil {
+		fmt.Println("error logging unanswered question:", err)
+	}
+}
+
+// HandleGetUnanswered возвращает последние вопросы, на которые не нашлось
+// ответа в документации.
+func (h *RequestHandler) HandleGetUnanswered(c *fiber.Ctx) error {
+	var params types.UnansweredListParams
+	if c.QueryParser(&params) != nil {
+		return ErrBadRequest()
+	}
+	if errors := types.Validate(&params); len(errors) > 0 {
+		return NewValidationError(errors)
+	}
+
+	if params.Limit == 0 {
+		params.Limit = defaultUnansweredLimit
+	}
+
+	questions, err := h.contextStore.ListUnansweredQuestions(context.Background(), params.Limit)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(questions)
+}
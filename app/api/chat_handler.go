@@ -0,0 +1,273 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"rag/app/agent"
+	"rag/store"
+	"rag/types"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultChatSessionsLimit = 100
+	defaultChatHistory       = 6
+)
+
+type ChatHandler struct {
+	chatStore store.DBStorer
+	answers   *RequestHandler
+}
+
+func NewChatHandler(s store.DBStorer, answers *RequestHandler) *ChatHandler {
+	return &ChatHandler{
+		chatStore: s,
+		answers:   answers,
+	}
+}
+
+// chatHistorySize возвращает, сколько последних сообщений диалога попадает
+// в промпт: CHAT_HISTORY_MESSAGES, по умолчанию 6 (три пары вопрос-ответ).
+func chatHistorySize() int {
+	n, err := strconv.Atoi(os.Getenv("CHAT_HISTORY_MESSAGES"))
+	if err != nil || n < 0 {
+		return defaultChatHistory
+	}
+	return n
+}
+
+func (h *ChatHandler) HandleCreateSession(c *fiber.Ctx) error {
+	var params types.ChatSessionParams
+	if c.BodyParser(&params) != nil {
+		return ErrBadRequest()
+	}
+	if errors := types.Validate(&params); len(errors) > 0 {
+		return NewValidationError(errors)
+	}
+
+	// Несуществующий профиль лучше отклонить сразу, а не на первом вопросе
+	if params.Profile != "" {
+		if _, err := h.answers.answerProfile(context.Background(), params.Profile); err != nil {
+			return err
+		}
+	}
+
+	session, err := h.chatStore.CreateChatSession(context.Background(), types.ChatSession{
+		ID:      uuid.New(),
+		Title:   params.Title,
+		Profile: params.Profile,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(session)
+}
+
+func (h *ChatHandler) HandleGetSessions(c *fiber.Ctx) error {
+	var params types.ChatSessionListParams
+	if c.QueryParser(&params) != nil {
+		return ErrBadRequest()
+	}
+	if errors := types.Validate(&params); len(errors) > 0 {
+		return NewValidationError(errors)
+	}
+
+	if params.Limit == 0 {
+		params.Limit = defaultChatSessionsLimit
+	}
+
+	sessions, err := h.chatStore.ListChatSessions(context.Background(), params.Limit)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(sessions)
+}
+
+// HandleGetSession возвращает диалог со всей историей.
+func (h *ChatHandler) HandleGetSession(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return ErrInvalidID()
+	}
+
+	session, err := h.chatStore.GetChatSession(context.Background(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound(id, "chat session")
+	}
+	if err != nil {
+		return err
+	}
+
+	msgs, err := h.chatStore.ListChatMessages(context.Background(), id, 0)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(types.ChatHistory{ChatSession: *session, Messages: msgs})
+}
+
+func (h *ChatHandler) HandleDeleteSession(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return ErrInvalidID()
+	}
+
+	err = h.chatStore.DeleteChatSession(context.Background(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound(id, "chat session")
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// HandleSendMessage продолжает диалог: вопрос вместе с историей переписывается
+// в самостоятельный запрос для поиска контекста, а ответ генерируется с учётом
+// последних сообщений диалога. Вопрос и ответ сохраняются в историю.
+func (h *ChatHandler) HandleSendMessage(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return ErrInvalidID()
+	}
+
+	var params types.QueryParams
+	if c.BodyParser(&params) != nil {
+		return ErrBadRequest()
+	}
+	if errors := types.Validate(&params); len(errors) > 0 {
+		return NewValidationError(errors)
+	}
+
+	ctx := context.Background()
+	session, err := h.chatStore.GetChatSession(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound(id, "chat session")
+	}
+	if err != nil {
+		return err
+	}
+
+	profile := params.Profile
+	if profile == "" {
+		profile = session.Profile
+	}
+	cfg, err := h.answers.answerProfile(ctx, profile)
+	if err != nil {
+		return err
+	}
+
+	var history []agent.Message
+	if size := chatHistorySize(); size > 0 {
+		msgs, err := h.chatStore.ListChatMessages(ctx, id, size)
+		if err != nil {
+			return err
+		}
+		history = make([]agent.Message, len(msgs))
+		for i, m := range msgs {
+			history[i] = agent.Message{Role: m.Role, Content: m.Content}
+		}
+	}
+
+	// Без переписанного запроса поиск всё равно возможен, поэтому ошибка не фатальна
+	query, err := agent.CondenseQuestion(ctx, history, params.Prompt, cfg)
+	if err != nil {
+		fmt.Println("error condensing question:", err)
+		query = params.Prompt
+	}
+
+	query, lang := resolveLanguage(ctx, params.Prompt, query, params.AnswerLanguage, cfg)
+	// История урезается до доли окна, чтобы длинные прошлые ответы
+	// не вытесняли контекст
+	history, droppedHistory := agent.TrimHistory(history, cfg)
+	budget := agent.ContextBudget(ctx, types.PromptVars{Question: params.Prompt, Language: lang.AnswerLanguage}, history, cfg)
+	budget.DroppedHistory = droppedHistory
+	found, err := h.answers.retrieve(ctx, query, cfg, budget)
+	if err != nil {
+		return err
+	}
+	found.language = lang.AnswerLanguage
+
+	if !found.answerable() {
+		h.answers.logUnanswered(ctx, types.UnansweredQuestion{
+			Question:     params.Prompt,
+			Query:        query,
+			Profile:      cfg.Name,
+			SessionID:    &id,
+			BestDistance: found.bestDistance,
+		})
+		return h.saveTurn(c, params.Prompt, query, types.ChatMessage{
+			Content:           noAnswerMessage(lang.AnswerLanguage),
+			Suggestions:       found.suggestions,
+			SuggestionsHeader: suggestionsHeader(lang.AnswerLanguage),
+			Budget:            found.budget,
+			LanguageInfo:      &lang,
+		}, false, id)
+	}
+
+	output, ref, err := agent.GenerateAnswer(ctx, found.vars(params.Prompt), history, cfg)
+	if err != nil {
+		return err
+	}
+
+	output, grounding, ref, answered := h.answers.verifyAnswer(ctx, found, params.Prompt, output, ref, history, cfg)
+	if !answered {
+		h.answers.logUnanswered(ctx, types.UnansweredQuestion{
+			Question:     params.Prompt,
+			Query:        query,
+			Profile:      cfg.Name,
+			SessionID:    &id,
+			BestDistance: found.confidence,
+		})
+	}
+	output, invalid := applyCitations(output, found.sources)
+	if len(invalid) > 0 {
+		fmt.Printf("Chat %s: removed citations of nonexistent sources %v\n", id, invalid)
+	}
+
+	return h.saveTurn(c, params.Prompt, query, types.ChatMessage{
+		Content:          output,
+		Sources:          found.sources,
+		Confidence:       found.confidence,
+		Grounding:        grounding,
+		InvalidCitations: invalid,
+		Prompt:           &ref,
+		Budget:           found.budget,
+		LanguageInfo:     &lang,
+	}, answered, id)
+}
+
+// saveTurn сохраняет вопрос и ответ в историю диалога и возвращает ответ клиенту.
+// answered — false, если ответа в документации не нашлось или он заменён отказом.
+func (h *ChatHandler) saveTurn(c *fiber.Ctx, prompt, query string, answer types.ChatMessage, answered bool, sessionID uuid.UUID) error {
+	now := time.Now()
+	question := types.ChatMessage{
+		ID:        uuid.New(),
+		SessionID: sessionID,
+		Role:      types.ChatRoleUser,
+		Content:   prompt,
+		Query:     query,
+		CreatedAt: now,
+	}
+
+	answer.ID = uuid.New()
+	answer.SessionID = sessionID
+	answer.Role = types.ChatRoleAssistant
+	answer.Answered = &answered
+	answer.CreatedAt = now
+	if err := h.chatStore.AddChatMessages(context.Background(), question, answer); err != nil {
+		return err
+	}
+
+	return c.JSON(answer)
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"rag/types"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Ссылка на источники в ответе: [1], [2, 3] или [2][3]
+var citationRe = regexp.MustCompile(`\s?\[(\d+(?:\s*,\s*\d+)*)\]`)
+
+// Номера больше этого — не ссылки, а значения в тексте ответа ([2020]):
+// в контексте столько источников не бывает
+const maxCitationNumber = 100
+
+// applyCitations отмечает источники, на которые ссылается ответ, и убирает
+// из ссылок номера, которых не было в контексте; ссылка без верных номеров
+// убирается целиком. Скобки с числом больше maxCitationNumber или нулём
+// ссылкой не считаются и остаются как есть. Возвращает очищенный ответ
+// и неверные номера по возрастанию.
+func applyCitations(answer string, sources []types.Source) (string, []int) {
+	byNumber := make(map[int]int, len(sources))
+	for i, src := range sources {
+		byNumber[src.Number] = i
+	}
+
+	invalid := make(map[int]struct{})
+	cleaned := citationRe.ReplaceAllStringFunc(answer, func(m string) string {
+		sub := citationRe.FindStringSubmatch(m)
+
+		var parsed []int
+		for _, part := range strings.Split(sub[1], ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil || n < 1 || n > maxCitationNumber {
+				return m
+			}
+			parsed = append(parsed, n)
+		}
+
+		var numbers []string
+		for _, n := range parsed {
+			i, ok := byNumber[n]
+			if !ok {
+				invalid[n] = struct{}{}
+				continue
+			}
+			sources[i].Cited = true
+			numbers = append(numbers, strconv.Itoa(n))
+		}
+		if len(numbers) == 0 {
+			return ""
+		}
+
+		prefix := strings.TrimSuffix(m, strings.TrimLeft(m, " \t\n"))
+		return prefix + "[" + strings.Join(numbers, ", ") + "]"
+	})
+
+	if len(invalid) == 0 {
+		return answer, nil
+	}
+	numbers := make([]int, 0, len(invalid))
+	for n := range invalid {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+	return cleaned, numbers
+}
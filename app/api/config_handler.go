@@ -2,6 +2,7 @@ package api
 
 import (
 	"context"
+	"rag/app/agent"
 	"rag/store"
 	"rag/types"
 	"reflect"
@@ -36,6 +37,9 @@ func (h *ConfigHandler) HandleSetConfig(c *fiber.Ctx) error {
 	if errors := types.Validate(&params); len(errors) > 0 {
 		return NewValidationError(errors)
 	}
+	if !agent.ValidCredentialRef(params.APIKeyRef) {
+		return NewValidationError(map[string]string{"APIKeyRef": "must match " + agent.CredentialPrefix + "[A-Z0-9_]+"})
+	}
 
 	v := reflect.ValueOf(params)
 	t := reflect.TypeOf(params)
@@ -62,3 +66,44 @@ func (h *ConfigHandler) HandleSetConfig(c *fiber.Ctx) error {
 
 	return c.JSON(resp)
 }
+
+func (h *ConfigHandler) HandleGetProfiles(c *fiber.Ctx) error {
+	profiles, err := h.configStore.ListProfiles(context.Background())
+	if err != nil {
+		return err
+	}
+	return c.JSON(profiles)
+}
+
+// HandleSetProfile создаёт или обновляет профиль LLM по имени.
+// Ключ API передаётся ссылкой api_key_ref на переменную окружения или секрет
+// с префиксом RAG_KEY_. Маршрут требует токен администратора.
+func (h *ConfigHandler) HandleSetProfile(c *fiber.Ctx) error {
+	var params types.ProfileParams
+	if c.BodyParser(&params) != nil {
+		return ErrBadRequest()
+	}
+
+	if errors := types.Validate(&params); len(errors) > 0 {
+		return NewValidationError(errors)
+	}
+	if !agent.ValidCredentialRef(params.APIKeyRef) {
+		return NewValidationError(map[string]string{"APIKeyRef": "must match " + agent.CredentialPrefix + "[A-Z0-9_]+"})
+	}
+
+	profile, err := h.configStore.SetProfile(context.Background(), types.LLMConfig{
+		Name:            params.Name,
+		Provider:        params.Provider,
+		Url:             params.Url,
+		Model:           params.Model,
+		PromptStr:       params.PromptStr,
+		APIKeyRef:       params.APIKeyRef,
+		ContextWindow:   params.ContextWindow,
+		MaxAnswerTokens: params.MaxAnswerTokens,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(profile)
+}
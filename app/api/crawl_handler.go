@@ -0,0 +1,76 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"rag/store"
+	"rag/types"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultCrawlDepth = 2
+	defaultCrawlPages = 50
+)
+
+type CrawlHandler struct {
+	crawlStore store.DBStorer
+}
+
+func NewCrawlHandler(s store.DBStorer) *CrawlHandler {
+	return &CrawlHandler{
+		crawlStore: s,
+	}
+}
+
+// HandleCrawl создаёт заявку на обход сайта. Обход выполняет loader.
+func (h *CrawlHandler) HandleCrawl(c *fiber.Ctx) error {
+	var params types.CrawlParams
+	if c.BodyParser(&params) != nil {
+		return ErrBadRequest()
+	}
+
+	if errors := types.Validate(&params); len(errors) > 0 {
+		return NewValidationError(errors)
+	}
+
+	maxDepth := defaultCrawlDepth
+	if params.MaxDepth != nil {
+		maxDepth = *params.MaxDepth
+	}
+	if params.MaxPages == 0 {
+		params.MaxPages = defaultCrawlPages
+	}
+
+	crawl, err := h.crawlStore.CreateCrawl(context.Background(), types.Crawl{
+		ID:       uuid.New(),
+		SeedURL:  params.URL,
+		MaxDepth: maxDepth,
+		MaxPages: params.MaxPages,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(crawl)
+}
+
+func (h *CrawlHandler) HandleGetCrawl(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return ErrInvalidID()
+	}
+
+	crawl, err := h.crawlStore.GetCrawl(context.Background(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound(id, "crawl")
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(crawl)
+}
@@ -1,10 +1,14 @@
 package api
 
 import (
+	"bufio"
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"rag/app/agent"
 	"rag/model"
 	"rag/types"
@@ -22,22 +26,28 @@ import (
 type RequestHandler struct {
 	contextStore store.DBStorer
 	embedder     model.EmbedderInterface
+	embedCache   *model.CachedEmbedder
 }
 
 func NewRequestHandler(contextStore store.DBStorer) *RequestHandler {
-	embedder := model.NewOllamaEmbedder()
+	embedder := model.NewCachedEmbedder(model.NewEmbedder(), contextStore)
 	return &RequestHandler{
 		contextStore: contextStore,
 		embedder:     embedder,
+		embedCache:   embedder,
 	}
 }
 
+// HandleEmbeddingCacheStats возвращает статистику кэша эмбеддингов запросов.
+func (h *RequestHandler) HandleEmbeddingCacheStats(c *fiber.Ctx) error {
+	return c.JSON(h.embedCache.Stats())
+}
+
 func (h *RequestHandler) HandleRequest(c *fiber.Ctx) error {
 	var params types.QueryParams
 	if c.BodyParser(&params) != nil {
 		return ErrBadRequest()
 	}
-	fmt.Println(params.UseLocal)
 	if errors := types.Validate(&params); len(errors) > 0 {
 		fmt.Println(errors)
 		return NewValidationError(errors)
@@ -45,77 +55,305 @@ func (h *RequestHandler) HandleRequest(c *fiber.Ctx) error {
 
 	prompt := params.Prompt
 
-	embededPrompt, err := h.embedder.Embed(prompt) //TODO set cfg from DB id =1
+	cfg, err := h.answerProfile(context.Background(), params.Profile)
 	if err != nil {
 		return err
 	}
 
-	similarChunks, err := h.contextStore.Search(context.Background(), embededPrompt, 3)
+	query, lang := resolveLanguage(context.Background(), prompt, prompt, params.AnswerLanguage, cfg)
+	budget := agent.ContextBudget(context.Background(), types.PromptVars{Question: prompt, Language: lang.AnswerLanguage}, nil, cfg)
+	found, err := h.retrieve(context.Background(), query, cfg, budget)
+	if err != nil {
+		return err
+	}
+	found.language = lang.AnswerLanguage
+
+	if !found.answerable() {
+		h.logUnanswered(context.Background(), types.UnansweredQuestion{
+			Question:     prompt,
+			Query:        query,
+			Profile:      cfg.Name,
+			BestDistance: found.bestDistance,
+		})
+		return c.JSON(&types.SearchResponse{
+			LanguageInfo:      lang,
+			Answer:            noAnswerMessage(lang.AnswerLanguage),
+			Answered:          false,
+			Sources:           []types.Source{},
+			Suggestions:       found.suggestions,
+			SuggestionsHeader: suggestionsHeader(lang.AnswerLanguage),
+			Budget:            found.budget,
+			Timestamp:         time.Now(),
+		})
+	}
+
+	output, ref, err := agent.GenerateAnswer(context.Background(), found.vars(prompt), nil, cfg)
 	if err != nil {
-		fmt.Println("error to get context from DB", err)
 		return err
 	}
 
+	output, grounding, ref, answered := h.verifyAnswer(context.Background(), found, prompt, output, ref, nil, cfg)
+	if !answered {
+		h.logUnanswered(context.Background(), types.UnansweredQuestion{
+			Question:     prompt,
+			Query:        query,
+			Profile:      cfg.Name,
+			BestDistance: found.confidence,
+		})
+	}
+	answer, invalid := applyCitations(output, found.sources)
+
+	//return c.JSON(output)
+	resp := &types.SearchResponse{
+		LanguageInfo:     lang,
+		Answer:           answer,
+		Answered:         answered,
+		Sources:          found.sources,
+		InvalidCitations: invalid,
+		Grounding:        grounding,
+		Prompt:           &ref,
+		Budget:           found.budget,
+		Confidence:       found.confidence,
+		Timestamp:        time.Now(),
+	}
+	return c.JSON(resp)
+}
+
+// HandleRequestStream отвечает на вопрос потоком Server-Sent Events:
+// sources с найденными источниками, token с фрагментами ответа по мере
+// генерации и done с отметками о цитировании, уверенностью и временем
+// этапов (или error).
+// Закрытие соединения клиентом отменяет запрос к модели.
+func (h *RequestHandler) HandleRequestStream(c *fiber.Ctx) error {
+	var params types.QueryParams
+	if c.BodyParser(&params) != nil {
+		return ErrBadRequest()
+	}
+	if errors := types.Validate(&params); len(errors) > 0 {
+		fmt.Println(errors)
+		return NewValidationError(errors)
+	}
+
+	// Поиск и профиль — до начала потока, чтобы их ошибки пришли обычным JSON
+	start := time.Now()
+	cfg, err := h.answerProfile(context.Background(), params.Profile)
+	if err != nil {
+		return err
+	}
+	query, lang := resolveLanguage(context.Background(), params.Prompt, params.Prompt, params.AnswerLanguage, cfg)
+	budget := agent.ContextBudget(context.Background(), types.PromptVars{Question: params.Prompt, Language: lang.AnswerLanguage}, nil, cfg)
+	found, err := h.retrieve(context.Background(), query, cfg, budget)
+	if err != nil {
+		return err
+	}
+	found.language = lang.AnswerLanguage
+	retrievalTime := time.Since(start)
+
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Connection", "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		if err := writeSSE(w, "sources", found.sources); err != nil {
+			return
+		}
+
+		if !found.answerable() {
+			h.logUnanswered(ctx, types.UnansweredQuestion{
+				Question:     params.Prompt,
+				Query:        query,
+				Profile:      cfg.Name,
+				BestDistance: found.bestDistance,
+			})
+			if err := writeSSE(w, "token", map[string]string{"text": noAnswerMessage(lang.AnswerLanguage)}); err != nil {
+				return
+			}
+			writeSSE(w, "done", types.StreamDone{
+				LanguageInfo:      lang,
+				Answered:          false,
+				Sources:           []types.Source{},
+				Suggestions:       found.suggestions,
+				SuggestionsHeader: suggestionsHeader(lang.AnswerLanguage),
+				Budget:            found.budget,
+				Timings: types.StreamTimings{
+					RetrievalMs: retrievalTime.Milliseconds(),
+					TotalMs:     time.Since(start).Milliseconds(),
+				},
+				Timestamp: time.Now(),
+			})
+			return
+		}
+
+		genStart := time.Now()
+		// Ошибка записи означает, что клиент ушёл: прерываем генерацию
+		output, ref, err := agent.StreamAnswer(ctx, found.vars(params.Prompt), nil, cfg, func(token string) error {
+			if err := writeSSE(w, "token", map[string]string{"text": token}); err != nil {
+				cancel()
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			fmt.Println("error streaming answer:", err)
+			if ctx.Err() == nil {
+				writeSSE(w, "error", map[string]string{"error": err.Error()})
+			}
+			return
+		}
+
+		// Токены уже отправлены, поэтому неверные ссылки и слабая опора
+		// на контекст только сообщаются: перегенерировать или отказаться поздно
+		_, invalid := applyCitations(output, found.sources)
+		var grounding *types.Grounding
+		if verifyMode() != verifyOff {
+			grounding = h.groundAnswer(ctx, found, output, cfg)
+		}
+		writeSSE(w, "done", types.StreamDone{
+			LanguageInfo:     lang,
+			Answered:         true,
+			Sources:          found.sources,
+			InvalidCitations: invalid,
+			Grounding:        grounding,
+			Prompt:           &ref,
+			Budget:           found.budget,
+			Confidence:       found.confidence,
+			Timings: types.StreamTimings{
+				RetrievalMs:  retrievalTime.Milliseconds(),
+				GenerationMs: time.Since(genStart).Milliseconds(),
+				TotalMs:      time.Since(start).Milliseconds(),
+			},
+			Timestamp: time.Now(),
+		})
+	})
+	return nil
+}
+
+// retrieval — контекст для ответа на вопрос и его источники.
+// Если релевантных чанков нет, context и sources пусты, а suggestions
+// содержит ближайшие документы.
+type retrieval struct {
+	context      string
+	sources      []types.Source
+	confidence   float64
+	suggestions  []types.Suggestion
+	bestDistance float64
+	language     string // Язык ответа
+	budget       *types.TokenBudget
+}
+
+// vars возвращает переменные шаблона ответа на question.
+func (r *retrieval) vars(question string) types.PromptVars {
+	return types.PromptVars{
+		Context:  r.context,
+		Question: question,
+		Language: r.language,
+	}
+}
+
+// answerable сообщает, нашёлся ли контекст для ответа.
+func (r *retrieval) answerable() bool {
+	return r.context != ""
+}
+
+// retrieve находит чанки, похожие на вопрос, и собирает из них контекст,
+// умещающийся в budget токенов модели профиля cfg.
+func (h *RequestHandler) retrieve(ctx context.Context, prompt string, cfg types.LLMConfig, budget types.TokenBudget) (*retrieval, error) {
+	embededPrompt, err := h.embedder.Embed(prompt) //TODO set cfg from DB id =1
+	if err != nil {
+		return nil, err
+	}
+
+	similarChunks, err := h.contextStore.Search(ctx, embededPrompt, 3)
+	if err != nil {
+		fmt.Println("error to get context from DB", err)
+		return nil, err
+	}
+
 	// 4. Фильтруем чанки по качеству (distance)
 	qualityChunks, err := h.filterChunks(similarChunks)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	confidence := 1.0
-	if len(qualityChunks) > 0 {
-		confidence = qualityChunks[0].Distance
+	// Ничего релевантного: модель не спрашиваем, чтобы она не выдумала ответ
+	if len(qualityChunks) == 0 {
+		found := h.noContext(ctx, similarChunks)
+		found.budget = &budget
+		return found, nil
 	}
+	confidence := qualityChunks[0].Distance
 
 	fmt.Println("Count chunks before extend", len(qualityChunks))
 	// 4.1 Обогащаем выборку когерентными чанками
 	cohChunks, err := h.extendChunks(qualityChunks)
 	if err != nil {
 		fmt.Println(err)
-		return err
+		return nil, err
 	}
 	fmt.Println("Count chunks after extend", len(cohChunks))
 
 	// 5. Формируем контекст из найденных чанков
-	promptContext, contextChunks := h.buildContext(cohChunks)
+	promptContext, contextChunks := h.buildContext(cohChunks, cfg, &budget)
 
 	sources, err := h.formatSources(contextChunks)
 	if err != nil {
 		fmt.Println("Handle the error:", err)
-		return err
+		return nil, err
 	}
 
-	// fmt.Println("after builder: \n", promptContext)
-	// return c.JSON("ok")
-
 	if promptContext == "" {
-		promptContext = "empty"
+		found := h.noContext(ctx, similarChunks)
+		found.budget = &budget
+		return found, nil
 	}
 
-	cfg, err := h.contextStore.GetConfig(context.Background(), 2)
-	if err != nil {
-		return err
+	return &retrieval{
+		context:    promptContext,
+		sources:    sources,
+		confidence: confidence,
+		budget:     &budget,
+	}, nil
+}
+
+func (h *RequestHandler) answerProfile(ctx context.Context, name string) (types.LLMConfig, error) {
+	if name == "" {
+		name = os.Getenv("LLM_DEFAULT_PROFILE")
+	}
+	if name == "" {
+		return h.defaultProfile(ctx)
 	}
 
-	var output string
-	if params.UseLocal {
-		output, err = agent.GenerateAnswer(promptContext, prompt, cfg)
-	} else {
-		output, err = agent.GenerateAnswerCohere(promptContext, prompt, cfg)
+	cfg, err := h.contextStore.GetProfile(ctx, name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return cfg, ErrNotFound(name, "profile")
 	}
+	return cfg, err
+}
+
+// defaultCohereKeyRef — ключ API Cohere для профиля по умолчанию
+const defaultCohereKeyRef = "RAG_KEY_COHERE"
 
+// defaultProfile — профиль запросов без имени профиля, если не задан
+// LLM_DEFAULT_PROFILE. Как и до появления профилей, это Cohere с системным
+// промптом из config id 2; модель, адрес и окно Cohere берутся по умолчанию,
+// ключ API — из RAG_KEY_COHERE, если в config id 2 не задан api_key_ref.
+func (h *RequestHandler) defaultProfile(ctx context.Context) (types.LLMConfig, error) {
+	cfg, err := h.contextStore.GetConfig(ctx, 2)
 	if err != nil {
-		return err
+		return cfg, err
 	}
-
-	//return c.JSON(output)
-	resp := &types.SearchResponse{
-		Answer:     output,
-		Sources:    sources,
-		Confidence: confidence,
-		Timestamp:  time.Now(),
+	cfg.Provider = agent.ProviderCohere
+	cfg.Url = ""
+	cfg.Model = ""
+	cfg.ContextWindow = 0
+	if cfg.APIKeyRef == "" {
+		cfg.APIKeyRef = defaultCohereKeyRef
 	}
-	return c.JSON(resp)
+	return cfg, nil
 }
 
 func (h *RequestHandler) formatSources(chunks []types.Chunk) ([]types.Source, error) {
@@ -131,24 +369,55 @@ func (h *RequestHandler) formatSources(chunks []types.Chunk) ([]types.Source, er
 			Title:     doc.Title,
 			ChunkText: chunk.Content,
 			Index:     chunk.Index,
+			Number:    i + 1,
 		}
 	}
 	return sources, nil
 }
 
+// HandlePDF сохраняет загруженный файл в каталог-источник и ставит его
+// в очередь. Возвращает задание, за которым можно следить через
+// /jobs/:id и /jobs/:id/events, и id будущего документа.
 func (h *RequestHandler) HandlePDF(c *fiber.Ctx) error {
 	file, err := c.FormFile("file")
 	if err != nil {
 		return ErrBadRequest()
 	}
-	path := os.Getenv("LOADER_SOURCE_DIR") + "/" + file.Filename
-	if err := c.SaveFile(file, path); err != nil {
+
+	name := sanitizeFilename(file.Filename)
+	if name == "" {
+		return NewValidationError(map[string]string{"file": "invalid file name"})
+	}
+	if err := validateUpload(file, name); err != nil {
+		return err
+	}
+
+	path, hash, err := saveUpload(file, os.Getenv("LOADER_SOURCE_DIR"), name)
+	if err != nil {
 		fmt.Println(err)
 		return err
 	}
 	fmt.Printf("[UPLOAD] Fife successfuly saved to: %s\n", path)
 
-	return c.JSON("ok")
+	// Загрузчик тоже увидит файл, но для активного задания по тому же пути
+	// новое не создаётся
+	job, err := h.contextStore.EnqueueJob(context.Background(), path, uploadSource)
+	if err != nil {
+		return err
+	}
+
+	res := types.UploadResult{
+		Path:  path,
+		JobID: job.ID,
+		Job:   job,
+	}
+	// Id документа выводится из содержимого; письмо даёт документ на каждое сообщение
+	if ext := strings.ToLower(filepath.Ext(path)); ext != ".eml" && ext != ".mbox" {
+		id := types.DocumentIDFromHash(hash)
+		res.DocumentID = &id
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(res)
 }
 
 func (h *RequestHandler) extendChunks(chunks []types.Chunk) ([]types.Chunk, error) {
@@ -194,109 +463,156 @@ func (h *RequestHandler) filterChunks(chunks []types.Chunk) ([]types.Chunk, erro
 	return result, nil
 }
 
-func (h *RequestHandler) buildContext(chunks []types.Chunk) (string, []types.Chunk) {
-	// var context string
-	maxContextLength := 40000 // Максимальный размер контекста в символах
-	// currentLength := len(context)
+// contextBlock — блок контекста: текстовый чанк или таблица целиком.
+type contextBlock struct {
+	chunk  types.Chunk
+	table  string // Текст таблицы; пусто для текстового чанка
+	tokens int
+}
+
+// text возвращает текст блока без номера [n].
+func (b contextBlock) text() string {
+	if b.chunk.TableID.Valid {
+		return b.table
+	}
+	if b.chunk.Section != "" {
+		return fmt.Sprintf("## %s\n%s", b.chunk.Section, b.chunk.Content)
+	}
+	return b.chunk.Content
+}
+
+// buildContext собирает контекст из чанков в пределах budget.ContextBudget
+// токенов модели профиля cfg. Блоки набираются по убыванию близости к вопросу,
+// поэтому соседние чанки, добавленные для связности, идут последними; блок,
+// который не помещается, пропускается, но более короткие после него ещё
+// могут войти. В промпте блоки сгруппированы по документам в порядке
+// следования и помечены номером [n], по которому модель ссылается на них
+// в ответе; n-й блок соответствует n-му из возвращаемых чанков. Перекрытия
+// соседних чанков убираются уже после отбора: если предыдущий чанк не вошёл,
+// текст следующего остаётся целым. Фактический расход записывается в budget.
+func (h *RequestHandler) buildContext(chunks []types.Chunk, cfg types.LLMConfig, budget *types.TokenBudget) (string, []types.Chunk) {
 	overlap, _ := strconv.Atoi(os.Getenv("CHUNK_OVERLAP"))
 
-	// 1️⃣ Сначала сортируем все чанки по Weight (по убыванию)
-	sort.SliceStable(chunks, func(i, j int) bool {
-		wi := chunks[i].Distance
-		wj := chunks[j].Distance
-		return wi > wj
+	// 1️⃣ Собираем блоки; строки одной таблицы дают один блок
+	// с близостью лучшей из строк
+	var blocks []contextBlock
+	tableBlocks := make(map[uuid.UUID]int)
+	for _, ch := range chunks {
+		if !ch.TableID.Valid {
+			blocks = append(blocks, contextBlock{chunk: ch})
+			continue
+		}
+
+		tableID := ch.TableID.UUID
+		if i, ok := tableBlocks[tableID]; ok {
+			fmt.Println("filter tables")
+			blocks[i].chunk.Distance = max(blocks[i].chunk.Distance, ch.Distance)
+			continue
+		}
+		table, err := h.contextStore.GetTableByID(context.Background(), tableID)
+		if err != nil {
+			log.Printf("failed to load table %s: %v", tableID, err)
+			continue
+		}
+		tableBlocks[tableID] = len(blocks)
+		blocks = append(blocks, contextBlock{chunk: ch, table: table.Content})
+	}
+
+	// 2️⃣ Набираем блоки по убыванию близости, пока хватает бюджета
+	sort.SliceStable(blocks, func(i, j int) bool {
+		return blocks[i].chunk.Distance > blocks[j].chunk.Distance
 	})
 
-	// 2️⃣ Группируем чанки по doc_id
-	grouped := make(map[uuid.UUID][]types.Chunk)
-	for _, ch := range chunks {
-		grouped[ch.DocID] = append(grouped[ch.DocID], ch)
+	var (
+		selected []contextBlock
+		used     int
+		headers  = make(map[uuid.UUID]bool)
+	)
+	for _, b := range blocks {
+		// Номер [n] и разметка блока; перекрытие ещё не убрано, поэтому
+		// оценка с запасом
+		b.tokens = agent.CountTokens(cfg, "[00] Таблица:\n"+b.text()+"\n\n")
+		cost := b.tokens
+		if !headers[b.chunk.DocID] {
+			cost += agent.CountTokens(cfg, fmt.Sprintf("Документ %s:\n\n", b.chunk.DocID))
+		}
+
+		if used+cost > budget.ContextBudget {
+			log.Printf("[CONTEXT] chunk %d of %s (%d tokens) does not fit: %d of %d tokens used",
+				b.chunk.Index, b.chunk.DocID, b.tokens, used, budget.ContextBudget)
+			budget.DroppedChunks++
+			continue
+		}
+		used += cost
+		headers[b.chunk.DocID] = true
+		selected = append(selected, b)
 	}
 
-	// 3️⃣ Сортируем внутри каждой группы по позиции (Index)
-	for id := range grouped {
-		sort.SliceStable(grouped[id], func(i, j int) bool {
-			return grouped[id][i].Index < grouped[id][j].Index
-		})
+	// Ни один блок не поместился целиком: лучший берётся обрезанным, чтобы
+	// найденный ответ не превратился в «нет в документации»
+	if len(selected) == 0 && len(blocks) > 0 {
+		b := blocks[0]
+		limit := budget.ContextBudget - agent.CountTokens(cfg, fmt.Sprintf("Документ %s:\n\n[00] Таблица:\n\n\n## %s\n", b.chunk.DocID, b.chunk.Section))
+		if b.chunk.TableID.Valid {
+			b.table = agent.TruncateTokens(cfg, b.table, limit, false)
+		} else {
+			b.chunk.Content = agent.TruncateTokens(cfg, b.chunk.Content, limit, false)
+		}
+		log.Printf("[CONTEXT] no block fits %d tokens, using chunk %d of %s truncated", budget.ContextBudget, b.chunk.Index, b.chunk.DocID)
+		selected = append(selected, b)
+		budget.DroppedChunks--
+	}
+
+	// 3️⃣ Документы идут в порядке лучшего блока, блоки внутри — по позиции
+	var selectedDocs []uuid.UUID
+	byDoc := make(map[uuid.UUID][]types.Chunk)
+	tables := make(map[uuid.UUID]string)
+	for _, b := range selected {
+		if _, ok := byDoc[b.chunk.DocID]; !ok {
+			selectedDocs = append(selectedDocs, b.chunk.DocID)
+		}
+		byDoc[b.chunk.DocID] = append(byDoc[b.chunk.DocID], b.chunk)
+		if b.chunk.TableID.Valid {
+			tables[b.chunk.TableID.UUID] = b.table
+		}
 	}
 
-	//originalCount := len(chunks)
 	var (
 		sb            strings.Builder
 		contextChunks []types.Chunk
-		seenTables    = make(map[uuid.UUID]struct{})
 	)
-
-	for docID, docChunks := range grouped {
+	for _, docID := range selectedDocs {
+		docChunks := byDoc[docID]
+		sort.SliceStable(docChunks, func(i, j int) bool {
+			return docChunks[i].Index < docChunks[j].Index
+		})
 
 		sb.WriteString(fmt.Sprintf("Документ %s:\n", docID))
-
-		docChunks = h.removeChunkOverlaps(docChunks, overlap)
-
-		for i, ch := range docChunks {
-
-			// =========================
-			// 📊 TABLE ROW
-			// =========================
+		// 4️⃣ Перекрытие убирается только у соседей, вошедших в контекст вместе
+		for _, ch := range h.removeChunkOverlaps(docChunks, overlap) {
+			b := contextBlock{chunk: ch, table: tables[ch.TableID.UUID]}
+			contextChunks = append(contextChunks, ch)
 			if ch.TableID.Valid {
-
-				tableID := ch.TableID.UUID
-
-				// таблицу уже добавляли → пропускаем
-				if _, ok := seenTables[tableID]; ok {
-					fmt.Println("filter tables")
-					continue
-				}
-
-				// грузим таблицу целиком
-				table, err := h.contextStore.GetTableByID(context.Background(), tableID)
-				if err != nil {
-					log.Printf("failed to load table %s: %v", tableID, err)
-					continue
-				}
-
-				sb.WriteString("\n")
-				sb.WriteString("Таблица:\n")
-				sb.WriteString(table.Content)
-				sb.WriteString("\n\n")
-
-				seenTables[tableID] = struct{}{}
-				contextChunks = append(contextChunks, ch)
-
-				if sb.Len() > maxContextLength {
-					log.Printf("[CONTEXT] limit reached (%d symbols)", maxContextLength)
-					break
-				}
-
-				continue
-			}
-
-			// =========================
-			// 📝 TEXT / IMAGE
-			// =========================
-			if ch.Section != "" {
-				sb.WriteString(fmt.Sprintf("## %s\n", ch.Section))
+				sb.WriteString(fmt.Sprintf("\n[%d] Таблица:\n", len(contextChunks)))
+			} else {
+				sb.WriteString(fmt.Sprintf("[%d] ", len(contextChunks)))
 			}
-
-			sb.WriteString(ch.Content)
+			sb.WriteString(b.text())
 			sb.WriteString("\n\n")
-
-			contextChunks = append(contextChunks, ch)
-
-			if sb.Len() > maxContextLength {
-				log.Printf("[CONTEXT] limit reached (%d symbols) at chunk %d", maxContextLength, i)
-				break
-			}
 		}
-
 		sb.WriteString("\n")
 	}
 
+	budget.Chunks = len(contextChunks)
+	budget.Context = agent.CountTokens(cfg, sb.String())
 	log.Printf(
-		"[CONTEXT] built: %d symbols from %d chunks (tables: %d)",
-		sb.Len(),
+		"[CONTEXT] built: %d of %d tokens (%s) from %d chunks (tables: %d, dropped: %d)",
+		budget.Context,
+		budget.ContextBudget,
+		budget.Tokenizer,
 		len(contextChunks),
-		len(seenTables),
+		len(tableBlocks),
+		budget.DroppedChunks,
 	)
 	return sb.String(), contextChunks
 }
@@ -0,0 +1,177 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"rag/store"
+	"rag/types"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultJobsLimit = 100
+	// Как часто поток событий проверяет задание и шлёт keep-alive
+	jobEventsInterval  = time.Second
+	jobEventsKeepAlive = 15 * time.Second
+)
+
+type JobHandler struct {
+	jobStore store.DBStorer
+}
+
+func NewJobHandler(s store.DBStorer) *JobHandler {
+	return &JobHandler{
+		jobStore: s,
+	}
+}
+
+// HandleGetJobs возвращает задания загрузки, по умолчанию — все последние.
+// ?state=dead показывает задания, исчерпавшие попытки.
+func (h *JobHandler) HandleGetJobs(c *fiber.Ctx) error {
+	var params types.JobListParams
+	if c.QueryParser(&params) != nil {
+		return ErrBadRequest()
+	}
+
+	if errors := types.Validate(&params); len(errors) > 0 {
+		return NewValidationError(errors)
+	}
+
+	if params.Limit == 0 {
+		params.Limit = defaultJobsLimit
+	}
+
+	jobs, err := h.jobStore.ListJobs(context.Background(), types.JobState(params.State), params.Limit)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(jobs)
+}
+
+// HandleRequeueJob возвращает задание из dead в очередь. Если файл уже
+// обрабатывается другим заданием, отвечает 409.
+func (h *JobHandler) HandleRequeueJob(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return ErrInvalidID()
+	}
+
+	job, err := h.jobStore.RequeueJob(context.Background(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound(id, "dead job")
+	}
+	if errors.Is(err, store.ErrActiveJob) {
+		return NewError(fiber.StatusConflict, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(job)
+}
+
+func (h *JobHandler) HandleGetJob(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return ErrInvalidID()
+	}
+
+	job, err := h.jobStore.GetJob(context.Background(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound(id, "job")
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(job)
+}
+
+// HandleJobEvents отдаёт Server-Sent Events со сменой этапов задания:
+// queued, started, converting, describing_images, embedding, saving и
+// итоговым saved или failed (с причиной). Поток закрывается, когда
+// задание завершено.
+func (h *JobHandler) HandleJobEvents(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return ErrInvalidID()
+	}
+
+	job, err := h.jobStore.GetJob(context.Background(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound(id, "job")
+	}
+	if err != nil {
+		return err
+	}
+
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Connection", "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		var last types.JobEvent
+		lastWrite := time.Now()
+		for {
+			ev := jobEvent(job)
+			if ev != last {
+				if err := writeSSE(w, string(ev.Stage), ev); err != nil {
+					return
+				}
+				last, lastWrite = ev, time.Now()
+			} else if time.Since(lastWrite) > jobEventsKeepAlive {
+				// Комментарий не виден клиенту, но выявляет закрытое соединение
+				if _, err := w.WriteString(": keep-alive\n\n"); err != nil || w.Flush() != nil {
+					return
+				}
+				lastWrite = time.Now()
+			}
+
+			if job.State == types.JobDone || job.State == types.JobDead {
+				return
+			}
+
+			time.Sleep(jobEventsInterval)
+			job, err = h.jobStore.GetJob(context.Background(), id)
+			if err != nil {
+				writeSSE(w, "error", map[string]string{"error": err.Error()})
+				return
+			}
+		}
+	})
+	return nil
+}
+
+func jobEvent(job *types.Job) types.JobEvent {
+	ev := types.JobEvent{
+		JobID:    job.ID,
+		State:    job.State,
+		Stage:    job.Stage,
+		Attempts: job.Attempts,
+	}
+	// Причина важна и для повтора, и для окончательной ошибки
+	if job.State != types.JobDone {
+		ev.Error = job.LastError
+	}
+	return ev
+}
+
+func writeSSE(w *bufio.Writer, event string, data any) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, b); err != nil {
+		return err
+	}
+	return w.Flush()
+}
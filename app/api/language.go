@@ -0,0 +1,57 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"rag/app/agent"
+	"rag/types"
+	"strconv"
+	"strings"
+)
+
+// corpusLanguage возвращает язык документов из CORPUS_LANGUAGE, по умолчанию русский.
+func corpusLanguage() string {
+	if lang := strings.ToLower(os.Getenv("CORPUS_LANGUAGE")); lang != "" {
+		return lang
+	}
+	return agent.LangRussian
+}
+
+// translateQueries сообщает, переводить ли вопрос на язык документов
+// перед поиском (TRANSLATE_QUERIES).
+func translateQueries() bool {
+	v, _ := strconv.ParseBool(os.Getenv("TRANSLATE_QUERIES"))
+	return v
+}
+
+// resolveLanguage определяет язык вопроса и язык ответа: answerLanguage,
+// если он задан, иначе язык вопроса. Если включён перевод и вопрос задан
+// не на языке документов, query переводится для поиска. Возвращает запрос
+// для поиска; при ошибке перевода поиск идёт по исходному запросу.
+func resolveLanguage(ctx context.Context, question, query, answerLanguage string, cfg types.LLMConfig) (string, types.LanguageInfo) {
+	info := types.LanguageInfo{
+		QueryLanguage:  agent.DetectLanguage(question),
+		AnswerLanguage: answerLanguage,
+	}
+	if info.QueryLanguage == "" {
+		info.QueryLanguage = corpusLanguage()
+	}
+	if info.AnswerLanguage == "" {
+		info.AnswerLanguage = info.QueryLanguage
+	}
+
+	if !translateQueries() || info.QueryLanguage == corpusLanguage() {
+		return query, info
+	}
+
+	translated, err := agent.TranslateQuery(ctx, query, corpusLanguage(), cfg)
+	if err != nil {
+		fmt.Println("error translating question:", err)
+		return query, info
+	}
+	info.TranslationUsed = true
+	info.OriginalQuery = query
+	info.TranslatedQuery = translated
+	return translated, info
+}
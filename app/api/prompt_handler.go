@@ -0,0 +1,207 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"rag/prompt"
+	"rag/store"
+	"rag/types"
+	"slices"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type PromptHandler struct {
+	promptStore store.DBStorer
+	library     *prompt.Library
+}
+
+func NewPromptHandler(s store.DBStorer, library *prompt.Library) *PromptHandler {
+	return &PromptHandler{
+		promptStore: s,
+		library:     library,
+	}
+}
+
+// knownPrompt проверяет, что шаблон с таким именем используется кодом.
+func knownPrompt(name string) error {
+	if !slices.Contains(prompt.Names(), name) {
+		return ErrNotFound(name, "prompt template")
+	}
+	return nil
+}
+
+// HandleGetPrompts возвращает действующую версию каждого шаблона:
+// последнюю сохранённую или встроенную.
+func (h *PromptHandler) HandleGetPrompts(c *fiber.Ctx) error {
+	saved, err := h.promptStore.ListPromptTemplates(context.Background())
+	if err != nil {
+		return err
+	}
+
+	latest := make(map[string]types.PromptTemplate, len(saved))
+	for _, t := range saved {
+		latest[t.Name] = t
+	}
+
+	templates := make([]types.PromptTemplate, 0, len(prompt.Names()))
+	for _, name := range prompt.Names() {
+		if t, ok := latest[name]; ok {
+			templates = append(templates, t)
+			continue
+		}
+		builtin, _ := prompt.Builtin(name)
+		templates = append(templates, *builtin)
+	}
+
+	return c.JSON(templates)
+}
+
+// HandleGetPromptVersions возвращает все версии шаблона, новые первыми;
+// последней в списке идёт встроенная версия 0.
+func (h *PromptHandler) HandleGetPromptVersions(c *fiber.Ctx) error {
+	name := c.Params("name")
+	if err := knownPrompt(name); err != nil {
+		return err
+	}
+
+	versions, err := h.promptStore.ListPromptTemplateVersions(context.Background(), name)
+	if err != nil {
+		return err
+	}
+	builtin, _ := prompt.Builtin(name)
+
+	return c.JSON(append(versions, *builtin))
+}
+
+func (h *PromptHandler) HandleGetPrompt(c *fiber.Ctx) error {
+	name := c.Params("name")
+	if err := knownPrompt(name); err != nil {
+		return err
+	}
+	version, err := strconv.Atoi(c.Params("version"))
+	if err != nil || version < 0 {
+		return ErrInvalidID()
+	}
+
+	var tmpl *types.PromptTemplate
+	if version == 0 {
+		tmpl, _ = prompt.Builtin(name)
+	} else {
+		tmpl, err = h.promptStore.GetPromptTemplate(context.Background(), name, version)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound(fmt.Sprintf("%s v%d", name, version), "prompt template")
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(tmpl)
+}
+
+// HandleSetPrompt сохраняет новую версию шаблона. Она сразу становится
+// действующей.
+func (h *PromptHandler) HandleSetPrompt(c *fiber.Ctx) error {
+	name := c.Params("name")
+	if err := knownPrompt(name); err != nil {
+		return err
+	}
+
+	var params types.PromptTemplateParams
+	if c.BodyParser(&params) != nil {
+		return ErrBadRequest()
+	}
+	if errors := types.Validate(&params); len(errors) > 0 {
+		return NewValidationError(errors)
+	}
+	if err := prompt.Check(params.Body); err != nil {
+		return NewValidationError(map[string]string{"Body": err.Error()})
+	}
+
+	tmpl, err := h.promptStore.SavePromptTemplate(context.Background(), types.PromptTemplate{
+		Name:        name,
+		Body:        params.Body,
+		Description: params.Description,
+	})
+	if err != nil {
+		return err
+	}
+	h.library.Invalidate(name)
+
+	return c.Status(fiber.StatusCreated).JSON(tmpl)
+}
+
+// HandleDeletePrompt удаляет версию шаблона, а без версии — все сохранённые
+// версии, после чего снова действует встроенный. Удалённые версии остаются
+// доступны по номеру, номера не переиспользуются.
+func (h *PromptHandler) HandleDeletePrompt(c *fiber.Ctx) error {
+	name := c.Params("name")
+	if err := knownPrompt(name); err != nil {
+		return err
+	}
+	version := 0
+	if v := c.Params("version"); v != "" {
+		var err error
+		if version, err = strconv.Atoi(v); err != nil || version <= 0 {
+			return ErrInvalidID()
+		}
+	}
+
+	err := h.promptStore.DeletePromptTemplate(context.Background(), name, version)
+	if errors.Is(err, sql.ErrNoRows) {
+		if version > 0 {
+			return ErrNotFound(fmt.Sprintf("%s v%d", name, version), "saved prompt template")
+		}
+		return ErrNotFound(name, "saved prompt template")
+	}
+	if err != nil {
+		return err
+	}
+	h.library.Invalidate(name)
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// HandleRenderPrompt подставляет переданные переменные в шаблон без вызова
+// модели: в черновик body, если он передан, иначе в версию version
+// (0 — действующую).
+func (h *PromptHandler) HandleRenderPrompt(c *fiber.Ctx) error {
+	name := c.Params("name")
+	if err := knownPrompt(name); err != nil {
+		return err
+	}
+
+	var params types.PromptRenderParams
+	if c.BodyParser(&params) != nil {
+		return ErrBadRequest()
+	}
+	if errors := types.Validate(&params); len(errors) > 0 {
+		return NewValidationError(errors)
+	}
+
+	tmpl := &types.PromptTemplate{Name: name, Body: params.Body}
+	if params.Body == "" {
+		var err error
+		tmpl, err = h.library.Template(context.Background(), name, params.Version)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound(fmt.Sprintf("%s v%d", name, params.Version), "prompt template")
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	rendered, err := prompt.Execute(tmpl.Body, params.Vars)
+	if err != nil {
+		return NewValidationError(map[string]string{"Body": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{
+		"template": types.PromptRef{Name: tmpl.Name, Version: tmpl.Version},
+		"prompt":   rendered,
+	})
+}
@@ -0,0 +1,221 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"rag/cleanup"
+	"rag/loader/convert"
+	"rag/store"
+	"rag/types"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type SourceHandler struct {
+	sourceStore store.DBStorer
+}
+
+func NewSourceHandler(s store.DBStorer) *SourceHandler {
+	return &SourceHandler{
+		sourceStore: s,
+	}
+}
+
+func (h *SourceHandler) HandleGetSources(c *fiber.Ctx) error {
+	settings, err := h.sourceStore.ListSourceSettings(context.Background())
+	if err != nil {
+		return err
+	}
+	if settings == nil {
+		settings = []types.SourceSettings{}
+	}
+	return c.JSON(settings)
+}
+
+// HandleSetSource создаёт или обновляет настройки каталога-источника.
+func (h *SourceHandler) HandleSetSource(c *fiber.Ctx) error {
+	var params types.SourceSettings
+	if c.BodyParser(&params) != nil {
+		return ErrBadRequest()
+	}
+
+	if errors := types.Validate(&params); len(errors) > 0 {
+		return NewValidationError(errors)
+	}
+
+	if err := h.sourceStore.SetSourceSettings(context.Background(), params); err != nil {
+		return err
+	}
+	return c.JSON(params)
+}
+
+func (h *SourceHandler) HandleGetCleanupRules(c *fiber.Ctx) error {
+	sets, err := h.sourceStore.ListCleanupRuleSets(context.Background())
+	if err != nil {
+		return err
+	}
+	if sets == nil {
+		sets = []types.CleanupRuleSet{}
+	}
+	return c.JSON(sets)
+}
+
+// HandleSetCleanupRules создаёт или обновляет именованный набор правил очистки.
+func (h *SourceHandler) HandleSetCleanupRules(c *fiber.Ctx) error {
+	var params types.CleanupRuleSet
+	if c.BodyParser(&params) != nil {
+		return ErrBadRequest()
+	}
+
+	if errors := types.Validate(&params); len(errors) > 0 {
+		return NewValidationError(errors)
+	}
+
+	if _, err := cleanup.Compile(params); err != nil {
+		return NewValidationError(map[string]string{"Pattern": err.Error()})
+	}
+
+	if err := h.sourceStore.SetCleanupRuleSet(context.Background(), params); err != nil {
+		return err
+	}
+	return c.JSON(params)
+}
+
+// HandlePreviewCleanup применяет набор правил к образцу текста и возвращает
+// текст до и после очистки. Образец передаётся полем text, файлом или полем
+// path — путём файла относительно каталога-источника. Markdown и txt
+// берутся как есть, остальные форматы (PDF, DOCX и т.д.) конвертируются
+// так же, как при загрузке; для path — с настройками его каталога.
+func (h *SourceHandler) HandlePreviewCleanup(c *fiber.Ctx) error {
+	name := c.Params("name")
+	set, err := h.sourceStore.GetCleanupRuleSet(context.Background(), name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound(name, "cleanup rules")
+	}
+	if err != nil {
+		return err
+	}
+
+	sample, converter := c.FormValue("text"), ""
+	if fileHeader, err := c.FormFile("file"); err == nil {
+		sample, converter, err = h.uploadedSample(fileHeader)
+		if err != nil {
+			return err
+		}
+	} else if rel := c.FormValue("path"); rel != "" {
+		path, err := sourceFile(rel)
+		if err != nil {
+			return err
+		}
+		sample, converter, err = h.convertSample(path)
+		if err != nil {
+			return err
+		}
+	}
+	if sample == "" {
+		return ErrBadRequest()
+	}
+
+	cleaner, err := cleanup.Compile(*set)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{
+		"rules":     set.Name,
+		"converter": converter,
+		"before":    sample,
+		"after":     cleaner.Apply(sample),
+	})
+}
+
+// uploadedSample возвращает текст загруженного образца: markdown и txt —
+// как есть, остальные форматы — после конвертации во временном каталоге.
+func (h *SourceHandler) uploadedSample(fileHeader *multipart.FileHeader) (string, string, error) {
+	name := sanitizeFilename(fileHeader.Filename)
+	if err := validateUpload(fileHeader, name); err != nil {
+		return "", "", err
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return "", "", err
+	}
+	defer file.Close()
+
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".md", ".markdown", ".txt":
+		data, err := io.ReadAll(file)
+		return string(data), "", err
+	}
+
+	tmpDir, err := os.MkdirTemp("", "rag-preview-*")
+	if err != nil {
+		return "", "", err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, name)
+	out, err := os.Create(path)
+	if err != nil {
+		return "", "", err
+	}
+	_, err = io.Copy(out, file)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", "", err
+	}
+	return h.convertSample(path)
+}
+
+// convertSample конвертирует файл в markdown тем же путём, что и загрузчик.
+func (h *SourceHandler) convertSample(path string) (string, string, error) {
+	settings, err := h.sourceStore.ListSourceSettings(context.Background())
+	if err != nil {
+		return "", "", err
+	}
+	md, converter, err := convert.Preview(context.Background(), path, settings)
+	if err != nil {
+		return "", "", NewError(fiber.StatusUnprocessableEntity, fmt.Sprintf("error converting %s: %s", filepath.Base(path), err))
+	}
+	return md, converter, nil
+}
+
+// sourceFile возвращает путь к обычному файлу каталога-источника
+// (LOADER_SOURCE_DIR) по относительному пути rel. Пути за пределы
+// каталога, в том числе через символьные ссылки, отклоняются.
+func sourceFile(rel string) (string, error) {
+	dir := os.Getenv("LOADER_SOURCE_DIR")
+	path := filepath.Join(dir, filepath.FromSlash(rel))
+
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", err
+	}
+	realPath, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", ErrNotFound(rel, "source file")
+	}
+	inside, err := filepath.Rel(realDir, realPath)
+	if err != nil || inside == ".." || strings.HasPrefix(inside, ".."+string(filepath.Separator)) {
+		return "", NewError(fiber.StatusBadRequest, "path must be inside the source directory")
+	}
+
+	info, err := os.Stat(realPath)
+	if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() {
+		return "", NewError(fiber.StatusBadRequest, "path must be a regular file")
+	}
+	return path, nil
+}
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// uploadSource — источник заданий загрузчика для файлов каталога-источника
+// (internal.SourcePDF в загрузчике).
+const uploadSource = "pdf"
+
+const defaultUploadMaxSize = 100 << 20
+
+// Форматы, которые умеет конвертировать загрузчик (Docling, pdfcpu, почта)
+var defaultUploadExts = []string{
+	".pdf", ".docx", ".pptx", ".xlsx", ".html", ".htm", ".md", ".txt", ".csv",
+	".png", ".jpg", ".jpeg", ".tiff", ".eml", ".mbox",
+}
+
+// UploadMaxSize возвращает максимальный размер загружаемого файла из
+// UPLOAD_MAX_SIZE (байты), по умолчанию 100 МБ.
+func UploadMaxSize() int64 {
+	size, err := strconv.ParseInt(os.Getenv("UPLOAD_MAX_SIZE"), 10, 64)
+	if err != nil || size <= 0 {
+		return defaultUploadMaxSize
+	}
+	return size
+}
+
+// uploadExts возвращает допустимые расширения из UPLOAD_ALLOWED_EXT
+// (через запятую), по умолчанию — все поддерживаемые загрузчиком.
+func uploadExts() []string {
+	v := os.Getenv("UPLOAD_ALLOWED_EXT")
+	if v == "" {
+		return defaultUploadExts
+	}
+	var exts []string
+	for _, ext := range strings.Split(v, ",") {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		exts = append(exts, ext)
+	}
+	return exts
+}
+
+// sanitizeFilename оставляет от имени, переданного клиентом, только базовое
+// имя без каталогов и управляющих символов.
+func sanitizeFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || r == '/' || r == ':' {
+			return -1
+		}
+		return r
+	}, name)
+	// Скрытые файлы и "..": имя не должно начинаться с точки
+	return strings.TrimLeft(strings.TrimSpace(name), ".")
+}
+
+// validateUpload проверяет размер, расширение и сигнатуру файла.
+func validateUpload(file *multipart.FileHeader, name string) error {
+	if file.Size > UploadMaxSize() {
+		return NewError(fiber.StatusRequestEntityTooLarge, fmt.Sprintf("file is larger than %d bytes", UploadMaxSize()))
+	}
+	if file.Size == 0 {
+		return NewError(fiber.StatusBadRequest, "file is empty")
+	}
+
+	ext := strings.ToLower(filepath.Ext(name))
+	allowed := false
+	for _, e := range uploadExts() {
+		if e == ext {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return NewError(fiber.StatusUnsupportedMediaType, fmt.Sprintf("file type %q is not supported", ext))
+	}
+
+	f, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	head := make([]byte, 8)
+	n, _ := io.ReadFull(f, head)
+	if !matchesSignature(ext, head[:n]) {
+		return NewError(fiber.StatusUnsupportedMediaType, fmt.Sprintf("file content does not match %q", ext))
+	}
+	return nil
+}
+
+// matchesSignature сверяет первые байты файла с форматом для двоичных форматов,
+// которые иначе упадут только при конвертации.
+func matchesSignature(ext string, head []byte) bool {
+	switch ext {
+	case ".pdf":
+		return bytes.HasPrefix(head, []byte("%PDF-"))
+	case ".docx", ".pptx", ".xlsx":
+		return bytes.HasPrefix(head, []byte("PK\x03\x04"))
+	case ".png":
+		return bytes.HasPrefix(head, []byte("\x89PNG"))
+	case ".jpg", ".jpeg":
+		return bytes.HasPrefix(head, []byte("\xff\xd8\xff"))
+	case ".tiff":
+		return bytes.HasPrefix(head, []byte("II*\x00")) || bytes.HasPrefix(head, []byte("MM\x00*"))
+	}
+	return true
+}
+
+// saveUpload записывает файл в каталог-источник и возвращает путь и sha256
+// содержимого. Файл пишется под временным именем и появляется под настоящим целиком,
+// чтобы загрузчик не увидел его недописанным; существующий файл с тем же
+// именем не перезаписывается.
+func saveUpload(file *multipart.FileHeader, dir, name string) (string, string, error) {
+	in, err := file.Open()
+	if err != nil {
+		return "", "", err
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", "", err
+	}
+	tmp, err := os.CreateTemp(dir, ".upload-*")
+	if err != nil {
+		return "", "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	if _, err := io.Copy(tmp, io.TeeReader(in, h)); err != nil {
+		tmp.Close()
+		return "", "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", "", err
+	}
+
+	// Link не перезаписывает существующий файл, в отличие от Rename,
+	// поэтому одновременные загрузки с одним именем не затрут друг друга
+	path := filepath.Join(dir, name)
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 1; ; i++ {
+		err := os.Link(tmp.Name(), path)
+		if err == nil {
+			break
+		}
+		if !os.IsExist(err) {
+			return "", "", err
+		}
+		path = filepath.Join(dir, fmt.Sprintf("%s_%d%s", base, i, ext))
+	}
+	return path, hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"rag/app/agent"
+	"rag/types"
+	"strconv"
+	"strings"
+)
+
+// Режимы проверки ответа (VERIFY_ANSWERS)
+const (
+	verifyOff        = ""
+	verifyReport     = "report"     // только вернуть оценку
+	verifyRegenerate = "regenerate" // при низкой оценке один раз перегенерировать ответ
+	verifyRefuse     = "refuse"     // при низкой оценке отказаться отвечать
+)
+
+const defaultVerifyMinScore = 0.6
+
+// verifyMode возвращает режим проверки ответа из VERIFY_ANSWERS.
+// Неизвестное значение считается report, чтобы опечатка не отключала проверку.
+func verifyMode() string {
+	switch mode := strings.ToLower(os.Getenv("VERIFY_ANSWERS")); mode {
+	case verifyOff, "off", "false", "0":
+		return verifyOff
+	case verifyRegenerate, verifyRefuse:
+		return mode
+	}
+	return verifyReport
+}
+
+// verifyMinScore возвращает порог оценки из VERIFY_MIN_SCORE, по умолчанию 0.6.
+func verifyMinScore() float64 {
+	v, err := strconv.ParseFloat(os.Getenv("VERIFY_MIN_SCORE"), 64)
+	if err != nil || v < 0 || v > 1 {
+		return defaultVerifyMinScore
+	}
+	return v
+}
+
+// verifyAnswer проверяет ответ по найденному контексту и в зависимости от
+// режима перегенерирует его или заменяет отказом на языке ответа. ref —
+// шаблон, по которому построен ответ. Возвращает итоговый ответ, оценку
+// (nil при выключенной проверке), шаблон итогового ответа и false, если
+// ответ заменён отказом.
+func (h *RequestHandler) verifyAnswer(ctx context.Context, found *retrieval, question, answer string, ref types.PromptRef, history []agent.Message, cfg types.LLMConfig) (string, *types.Grounding, types.PromptRef, bool) {
+	mode := verifyMode()
+	if mode == verifyOff {
+		return answer, nil, ref, true
+	}
+
+	grounding := h.groundAnswer(ctx, found, answer, cfg)
+	switch {
+	case len(grounding.Claims) == 0:
+		// Проверять нечего
+		return answer, grounding, ref, true
+	case grounding.Score == nil:
+		// Судья не ответил, а сверить значения не удалось: ответ не проверен.
+		// Перегенерация не поможет — её тоже нечем проверить
+		if mode == verifyRefuse {
+			grounding.Action = "refused"
+			return refusalMessage(found.language), grounding, ref, false
+		}
+		return answer, grounding, ref, true
+	case *grounding.Score >= verifyMinScore():
+		return answer, grounding, ref, true
+	}
+
+	switch mode {
+	case verifyRegenerate:
+		regenerated, regenRef, err := agent.GenerateAnswer(ctx, found.vars(groundedQuestion(question, grounding)), history, cfg)
+		if err != nil {
+			fmt.Println("error regenerating answer:", err)
+			return answer, grounding, ref, true
+		}
+		regrounding := h.groundAnswer(ctx, found, regenerated, cfg)
+		// Новый ответ берётся, только если он опирается на контекст лучше
+		if regrounding.Score == nil || *regrounding.Score <= *grounding.Score {
+			return answer, grounding, ref, true
+		}
+		regrounding.Action = "regenerated"
+		return regenerated, regrounding, regenRef, true
+	case verifyRefuse:
+		grounding.Action = "refused"
+		return refusalMessage(found.language), grounding, ref, false
+	}
+	return answer, grounding, ref, true
+}
+
+// groundAnswer оценивает опору ответа на контекст. Судьёй служит профиль
+// VERIFY_PROFILE, по умолчанию — профиль ответа. Если ни одно утверждение
+// проверить не удалось, Action — unverified.
+func (h *RequestHandler) groundAnswer(ctx context.Context, found *retrieval, answer string, cfg types.LLMConfig) *types.Grounding {
+	judge := cfg
+	if name := os.Getenv("VERIFY_PROFILE"); name != "" {
+		var err error
+		if judge, err = h.answerProfile(ctx, name); err != nil {
+			fmt.Println("error loading verify profile:", err)
+			judge = cfg
+		}
+	}
+	grounding := agent.VerifyAnswer(ctx, found.context, answer, judge)
+	if grounding.Score == nil && len(grounding.Claims) > 0 {
+		grounding.Action = "unverified"
+	}
+	return grounding
+}
+
+// groundedQuestion дополняет вопрос списком неподтверждённых утверждений
+// предыдущего ответа, которые нельзя повторять.
+func groundedQuestion(question string, grounding *types.Grounding) string {
+	var sb strings.Builder
+	sb.WriteString(question)
+	sb.WriteString("\n\nОтвечай только фактами из контекста. Следующие утверждения в контексте не подтверждаются, не повторяй их:\n")
+	for _, c := range grounding.Claims {
+		if c.Status == types.ClaimUnsupported || c.Status == types.ClaimPartial {
+			fmt.Fprintf(&sb, "- %s\n", c.Claim)
+		}
+	}
+	sb.WriteString("Если в контексте нет ответа, так и скажи.")
+	return sb.String()
+}
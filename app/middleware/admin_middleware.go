@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"os"
+	"rag/app/api"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RequireAdminToken пропускает запрос, только если в заголовке
+// Authorization: Bearer передан токен из ADMIN_TOKEN. Без ADMIN_TOKEN
+// защищённые маршруты закрыты для всех.
+func RequireAdminToken() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		token := os.Getenv("ADMIN_TOKEN")
+		if token == "" {
+			return api.ErrUnAuthorized("admin API is disabled: ADMIN_TOKEN is not set")
+		}
+
+		given, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			return api.ErrUnAuthorized("invalid admin token")
+		}
+		return c.Next()
+	}
+}
@@ -6,8 +6,10 @@ import (
 	"log"
 	"log/slog"
 	"os"
+	"rag/app/agent"
 	"rag/app/api"
 	"rag/app/middleware"
+	"rag/prompt"
 	"rag/store"
 	"strconv"
 
@@ -44,6 +46,14 @@ func (s *Server) Run() {
 		return
 	}
 
+	// Переменные окружения загружаются в main, поэтому лимит задаётся здесь;
+	// запас — на заголовки multipart сверх максимального размера файла
+	config.BodyLimit = int(api.UploadMaxSize()) + 1<<20
+
+	// Шаблоны промптов из БД; агент берёт из библиотеки действующие версии
+	prompts := prompt.NewLibrary(pool)
+	agent.SetTemplates(prompts)
+
 	var (
 		app                = fiber.New(config)
 		checkHandler       = api.NewCheckHandler
@@ -51,6 +61,11 @@ func (s *Server) Run() {
 		fileHandler        = api.NewRequestHandler(pool)
 		configHandler      = api.NewConfigHandler(pool)
 		fileProcessHandler = api.NewFileHandler(pool)
+		crawlHandler       = api.NewCrawlHandler(pool)
+		sourceHandler      = api.NewSourceHandler(pool)
+		jobHandler         = api.NewJobHandler(pool)
+		chatHandler        = api.NewChatHandler(pool, requestHandler)
+		promptHandler      = api.NewPromptHandler(pool, prompts)
 		// userHandler  = api.NewUserHandler(db)
 		// authHandler  = api.NewAuthHandler(db)
 		check = app.Group("/check")
@@ -59,9 +74,37 @@ func (s *Server) Run() {
 
 	check.Get("/healthy", checkHandler().HandleHealthy)
 	apiv1.Post("/request", requestHandler.HandleRequest)
+	apiv1.Post("/request/stream", requestHandler.HandleRequestStream)
+	apiv1.Get("/unanswered", requestHandler.HandleGetUnanswered)
+	apiv1.Post("/chats", chatHandler.HandleCreateSession)
+	apiv1.Get("/chats", chatHandler.HandleGetSessions)
+	apiv1.Get("/chats/:id", chatHandler.HandleGetSession)
+	apiv1.Delete("/chats/:id", chatHandler.HandleDeleteSession)
+	apiv1.Post("/chats/:id/messages", chatHandler.HandleSendMessage)
+	apiv1.Get("/embeddings/cache", requestHandler.HandleEmbeddingCacheStats)
 	apiv1.Post("/upload", fileHandler.HandlePDF)
 	apiv1.Post("/process", fileProcessHandler.ProcessFile)
-	apiv1.Post("/config/:id", configHandler.HandleSetConfig)
+	apiv1.Post("/config/:id", middleware.RequireAdminToken(), configHandler.HandleSetConfig)
+	apiv1.Get("/prompts", promptHandler.HandleGetPrompts)
+	apiv1.Get("/prompts/:name", promptHandler.HandleGetPromptVersions)
+	apiv1.Get("/prompts/:name/:version", promptHandler.HandleGetPrompt)
+	apiv1.Put("/prompts/:name", middleware.RequireAdminToken(), promptHandler.HandleSetPrompt)
+	apiv1.Delete("/prompts/:name", middleware.RequireAdminToken(), promptHandler.HandleDeletePrompt)
+	apiv1.Delete("/prompts/:name/:version", middleware.RequireAdminToken(), promptHandler.HandleDeletePrompt)
+	apiv1.Post("/prompts/:name/render", middleware.RequireAdminToken(), promptHandler.HandleRenderPrompt)
+	apiv1.Get("/profiles", configHandler.HandleGetProfiles)
+	apiv1.Put("/profiles", middleware.RequireAdminToken(), configHandler.HandleSetProfile)
+	apiv1.Post("/crawl", middleware.RequireAdminToken(), crawlHandler.HandleCrawl)
+	apiv1.Get("/crawl/:id", crawlHandler.HandleGetCrawl)
+	apiv1.Get("/sources", sourceHandler.HandleGetSources)
+	apiv1.Put("/sources", middleware.RequireAdminToken(), sourceHandler.HandleSetSource)
+	apiv1.Get("/cleanup", sourceHandler.HandleGetCleanupRules)
+	apiv1.Put("/cleanup", middleware.RequireAdminToken(), sourceHandler.HandleSetCleanupRules)
+	apiv1.Post("/cleanup/:name/preview", middleware.RequireAdminToken(), sourceHandler.HandlePreviewCleanup)
+	apiv1.Get("/jobs", jobHandler.HandleGetJobs)
+	apiv1.Get("/jobs/:id", jobHandler.HandleGetJob)
+	apiv1.Get("/jobs/:id/events", jobHandler.HandleJobEvents)
+	apiv1.Post("/jobs/:id/requeue", middleware.RequireAdminToken(), jobHandler.HandleRequeueJob)
 
 	app.Use(middleware.PlugStatic("/"))
 	app.Static("/", "./public")
@@ -0,0 +1,84 @@
+package cleanup
+
+import (
+	"fmt"
+	"rag/types"
+	"regexp"
+	"strings"
+)
+
+var (
+	blankLinesRe = regexp.MustCompile(`\n{3,}`)
+	spacesRe     = regexp.MustCompile(`[ \t\x{00A0}]+`)
+)
+
+// Cleaner — скомпилированный набор правил очистки текста.
+type Cleaner struct {
+	replace   []*regexp.Regexp
+	with      []string
+	dropLines []*regexp.Regexp
+	normalize bool
+}
+
+// Compile проверяет и компилирует регулярные выражения набора правил.
+func Compile(set types.CleanupRuleSet) (*Cleaner, error) {
+	c := &Cleaner{normalize: set.NormalizeWhitespace}
+
+	for i, r := range set.Replace {
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("replace rule #%d: %w", i+1, err)
+		}
+		c.replace = append(c.replace, re)
+		c.with = append(c.with, r.Replacement)
+	}
+
+	for i, p := range set.DropLines {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("drop line rule #%d: %w", i+1, err)
+		}
+		c.dropLines = append(c.dropLines, re)
+	}
+
+	return c, nil
+}
+
+// Apply применяет правила по порядку: замены, удаление строк,
+// нормализация пробелов.
+func (c *Cleaner) Apply(text string) string {
+	for i, re := range c.replace {
+		text = re.ReplaceAllString(text, c.with[i])
+	}
+
+	if len(c.dropLines) > 0 {
+		lines := strings.Split(text, "\n")
+		kept := lines[:0]
+		for _, line := range lines {
+			if !c.drop(line) {
+				kept = append(kept, line)
+			}
+		}
+		text = strings.Join(kept, "\n")
+	}
+
+	if c.normalize {
+		lines := strings.Split(text, "\n")
+		for i, line := range lines {
+			lines[i] = strings.TrimRight(spacesRe.ReplaceAllString(line, " "), " ")
+		}
+		text = blankLinesRe.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+		text = strings.TrimSpace(text)
+	}
+
+	return text
+}
+
+func (c *Cleaner) drop(line string) bool {
+	for _, re := range c.dropLines {
+		if re.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
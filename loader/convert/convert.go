@@ -0,0 +1,148 @@
+package convert
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"rag/types"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MatchSourceSettings выбирает из all настройки самого вложенного
+// каталога, содержащего файл.
+func MatchSourceSettings(all []types.SourceSettings, filePath string) types.SourceSettings {
+	var best types.SourceSettings
+	dir := filepath.Clean(filepath.Dir(filePath))
+	bestLen := -1
+	for _, s := range all {
+		sdir := filepath.Clean(s.Dir)
+		if dir != sdir && !strings.HasPrefix(dir, sdir+string(filepath.Separator)) {
+			continue
+		}
+		if len(sdir) > bestLen {
+			best, bestLen = s, len(sdir)
+		}
+	}
+	return best
+}
+
+// Crop обрезает поля страниц, если они заданы для каталога источника,
+// и возвращает путь к файлу для конвертации и функцию удаления временной копии.
+func Crop(filePath string, settings types.SourceSettings) (string, func(), error) {
+	if settings.CropTop <= 0 && settings.CropBottom <= 0 {
+		return filePath, func() {}, nil
+	}
+
+	tmpDir, err := os.MkdirTemp("", "rag-crop-*")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.RemoveAll(tmpDir) }
+
+	cropPath := filepath.Join(tmpDir, filepath.Base(filePath))
+	if err := RemoveHeaderFooterCrop(filePath, cropPath, settings.CropTop, settings.CropBottom); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return cropPath, cleanup, nil
+}
+
+// ToMarkdown конвертирует файл в markdown конвертером из settings,
+// при недоступности Docling PDF конвертируется средствами pdfcpu.
+func ToMarkdown(ctx context.Context, docling *DoclingClient, filePath string, settings types.SourceSettings) (string, string, error) {
+	isPDF := strings.EqualFold(filepath.Ext(filePath), ".pdf")
+
+	if settings.Converter == types.ConverterPDFCPU && isPDF {
+		md, err := convertPDFToMDNative(filePath)
+		return md, types.ConverterPDFCPU, err
+	}
+
+	md, err := docling.Convert(ctx, filePath)
+	if errors.Is(err, ErrDoclingUnavailable) && isPDF {
+		fmt.Printf("Docling is unavailable (%v), falling back to pdfcpu for %s\n", err, filePath)
+		md, err = convertPDFToMDNative(filePath)
+		return md, types.ConverterPDFCPU, err
+	}
+	return md, types.ConverterDocling, err
+}
+
+// Preview конвертирует файл в markdown так же, как при загрузке: с обрезкой
+// полей и конвертером самого вложенного каталога-источника из settings,
+// но без правил очистки. Возвращает markdown и имя использованного конвертера.
+func Preview(ctx context.Context, filePath string, settings []types.SourceSettings) (string, string, error) {
+	source := MatchSourceSettings(settings, filePath)
+	convertPath, cleanupCrop, err := Crop(filePath, source)
+	if err != nil {
+		return "", "", err
+	}
+	defer cleanupCrop()
+
+	return ToMarkdown(ctx, NewDoclingClient(NewDoclingConfig()), convertPath, source)
+}
+
+// NewDoclingConfig читает настройки Docling из переменных окружения DOCLING_*.
+func NewDoclingConfig() types.DoclingConfig {
+	url := os.Getenv("DOCLING_URL")
+	if url == "" {
+		url = "http://localhost:5001"
+	}
+	timeout, err := time.ParseDuration(os.Getenv("DOCLING_TIMEOUT"))
+	if err != nil {
+		timeout = 10 * time.Minute
+	}
+	retries, err := strconv.Atoi(os.Getenv("DOCLING_RETRIES"))
+	if err != nil {
+		retries = 2
+	}
+	retryDelay, err := time.ParseDuration(os.Getenv("DOCLING_RETRY_DELAY"))
+	if err != nil {
+		retryDelay = 5 * time.Second
+	}
+	// По умолчанию файлы от 10 МБ конвертируются асинхронно
+	asyncMinSize, err := strconv.ParseInt(os.Getenv("DOCLING_ASYNC_MIN_SIZE"), 10, 64)
+	if err != nil {
+		asyncMinSize = 10 << 20
+	}
+	pollInterval, err := time.ParseDuration(os.Getenv("DOCLING_POLL_INTERVAL"))
+	if err != nil {
+		pollInterval = 2 * time.Second
+	}
+	taskTimeout, err := time.ParseDuration(os.Getenv("DOCLING_TASK_TIMEOUT"))
+	if err != nil {
+		taskTimeout = time.Hour
+	}
+
+	opts := types.DoclingOptions{
+		TableMode:       os.Getenv("DOCLING_TABLE_MODE"),
+		ImageExportMode: os.Getenv("DOCLING_IMAGE_EXPORT_MODE"),
+	}
+	if opts.ImageExportMode == "" {
+		// Картинки нужны в markdown для описания vision-моделью
+		opts.ImageExportMode = "embedded"
+	}
+	if v, err := strconv.ParseBool(os.Getenv("DOCLING_DO_OCR")); err == nil {
+		opts.DoOCR = &v
+	}
+	if v := os.Getenv("DOCLING_OCR_LANG"); v != "" {
+		for _, lang := range strings.Split(v, ",") {
+			if lang = strings.TrimSpace(lang); lang != "" {
+				opts.OCRLang = append(opts.OCRLang, lang)
+			}
+		}
+	}
+
+	return types.DoclingConfig{
+		URL:          strings.TrimRight(url, "/"),
+		Timeout:      timeout,
+		Retries:      retries,
+		RetryDelay:   retryDelay,
+		AsyncMinSize: asyncMinSize,
+		PollInterval: pollInterval,
+		TaskTimeout:  taskTimeout,
+		Options:      opts,
+	}
+}
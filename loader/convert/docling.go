@@ -0,0 +1,259 @@
+package convert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"rag/types"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrDoclingUnavailable возвращается, когда сервис Docling не отвечает.
+var ErrDoclingUnavailable = errors.New("docling is unavailable")
+
+// DoclingClient — клиент docling-serve с синхронной и асинхронной конвертацией.
+type DoclingClient struct {
+	cfg    types.DoclingConfig
+	client *http.Client
+}
+
+func NewDoclingClient(cfg types.DoclingConfig) *DoclingClient {
+	return &DoclingClient{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+	}
+}
+
+type doclingTask struct {
+	TaskID     string `json:"task_id"`
+	TaskStatus string `json:"task_status"`
+}
+
+// Convert конвертирует файл в markdown. Файлы больше AsyncMinSize
+// отправляются через асинхронный API, чтобы не упираться в таймаут запроса.
+// Временные ошибки повторяются до Retries раз.
+func (d *DoclingClient) Convert(ctx context.Context, filePath string) (string, error) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return "", err
+	}
+	async := d.cfg.AsyncMinSize > 0 && info.Size() >= d.cfg.AsyncMinSize
+
+	var lastErr error
+	for attempt := 0; attempt <= d.cfg.Retries; attempt++ {
+		if attempt > 0 {
+			fmt.Printf("Docling attempt #%d for %s after error: %v\n", attempt+1, filePath, lastErr)
+			select {
+			case <-ctx.Done():
+				return "", ctx.Err()
+			case <-time.After(time.Duration(attempt) * d.cfg.RetryDelay):
+			}
+		}
+
+		var md string
+		if async {
+			md, lastErr = d.convertAsync(ctx, filePath)
+		} else {
+			md, lastErr = d.convertSync(ctx, filePath)
+		}
+		if lastErr == nil {
+			return stripRepeatedLines(md), nil
+		}
+		if !errors.Is(lastErr, ErrDoclingUnavailable) || ctx.Err() != nil {
+			return "", lastErr
+		}
+	}
+	return "", lastErr
+}
+
+func (d *DoclingClient) convertSync(ctx context.Context, filePath string) (string, error) {
+	resp, err := d.postFile(ctx, "/v1/convert/file", filePath)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	return decodeDoclingResult(resp)
+}
+
+func (d *DoclingClient) convertAsync(ctx context.Context, filePath string) (string, error) {
+	resp, err := d.postFile(ctx, "/v1/convert/file/async", filePath)
+	if err != nil {
+		return "", err
+	}
+	var task doclingTask
+	err = decodeDoclingJSON(resp, &task)
+	resp.Body.Close()
+	if err != nil {
+		return "", err
+	}
+	fmt.Printf("Docling task %s submitted for %s\n", task.TaskID, filePath)
+
+	if err := d.waitTask(ctx, task.TaskID); err != nil {
+		return "", err
+	}
+
+	resp, err = d.get(ctx, "/v1/result/"+task.TaskID)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	return decodeDoclingResult(resp)
+}
+
+// waitTask опрашивает статус задачи до её завершения или TaskTimeout.
+func (d *DoclingClient) waitTask(ctx context.Context, taskID string) error {
+	ctx, cancel := context.WithTimeout(ctx, d.cfg.TaskTimeout)
+	defer cancel()
+
+	for {
+		resp, err := d.get(ctx, "/v1/status/poll/"+taskID)
+		if err != nil {
+			return err
+		}
+		var task doclingTask
+		err = decodeDoclingJSON(resp, &task)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		switch task.TaskStatus {
+		case "success":
+			return nil
+		case "failure":
+			return fmt.Errorf("docling task %s failed", taskID)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("docling task %s: %w", taskID, ctx.Err())
+		case <-time.After(d.cfg.PollInterval):
+		}
+	}
+}
+
+func (d *DoclingClient) postFile(ctx context.Context, path, filePath string) (*http.Response, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+
+	part, err := writer.CreateFormFile("files", filepath.Base(filePath))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(part, file); err != nil {
+		return nil, err
+	}
+
+	for key, values := range d.formOptions() {
+		for _, v := range values {
+			if err := writer.WriteField(key, v); err != nil {
+				return nil, err
+			}
+		}
+	}
+	writer.Close()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", d.cfg.URL+path, &buf)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	return d.do(req)
+}
+
+func (d *DoclingClient) get(ctx context.Context, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", d.cfg.URL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return d.do(req)
+}
+
+// do выполняет запрос; сетевые ошибки и 5xx считаются недоступностью сервиса.
+func (d *DoclingClient) do(req *http.Request) (*http.Response, error) {
+	resp, err := d.client.Do(req)
+	if err != nil {
+		if req.Context().Err() != nil {
+			return nil, req.Context().Err()
+		}
+		return nil, fmt.Errorf("%w: %v", ErrDoclingUnavailable, err)
+	}
+	if resp.StatusCode >= 500 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: status %d: %s", ErrDoclingUnavailable, resp.StatusCode, body)
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("docling API error: status %d, body: %s", resp.StatusCode, body)
+	}
+	return resp, nil
+}
+
+// formOptions формирует параметры конвертации docling-serve.
+func (d *DoclingClient) formOptions() map[string][]string {
+	opts := d.cfg.Options
+	form := map[string][]string{
+		"to_formats": {"md"},
+		// Разделитель страниц нужен для поиска повторяющихся колонтитулов
+		"md_page_break_placeholder": {pageBreak},
+	}
+	if opts.DoOCR != nil {
+		form["do_ocr"] = []string{strconv.FormatBool(*opts.DoOCR)}
+	}
+	if len(opts.OCRLang) > 0 {
+		form["ocr_lang"] = opts.OCRLang
+	}
+	if opts.TableMode != "" {
+		form["table_mode"] = []string{opts.TableMode}
+	}
+	if opts.ImageExportMode != "" {
+		form["image_export_mode"] = []string{opts.ImageExportMode}
+	}
+	return form
+}
+
+func decodeDoclingJSON(resp *http.Response, v any) error {
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("failed to unmarshal docling response: %w", err)
+	}
+	return nil
+}
+
+func decodeDoclingResult(resp *http.Response) (string, error) {
+	var d types.DoclingResponse
+	if err := decodeDoclingJSON(resp, &d); err != nil {
+		return "", err
+	}
+	if d.Status != "" && d.Status != "success" && d.Status != "partial_success" {
+		msgs := make([]string, 0, len(d.Errors))
+		for _, e := range d.Errors {
+			msgs = append(msgs, e.ErrorMessage)
+		}
+		return "", fmt.Errorf("docling conversion %s: %s", d.Status, strings.Join(msgs, "; "))
+	}
+	return d.Document.MdContent, nil
+}
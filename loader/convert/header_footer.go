@@ -0,0 +1,112 @@
+package convert
+
+import (
+	"regexp"
+	"strings"
+)
+
+// pageBreak — разделитель страниц, который Docling вставляет в markdown.
+const pageBreak = "<!-- page-break -->"
+
+const (
+	// Сколько непустых строк в начале и конце страницы считаются колонтитулом
+	headerFooterLines = 3
+	// Доля страниц, на которых строка должна повториться
+	repeatedLineRatio = 0.6
+	// На документах короче этого повторения не ищем
+	minPagesForDetection = 3
+)
+
+var digitsRe = regexp.MustCompile(`\d+`)
+
+// stripRepeatedLines находит строки, повторяющиеся в начале или конце
+// большинства страниц (номера страниц, даты редакции, названия продукта),
+// и удаляет их. Цифры при сравнении не учитываются, поэтому "стр. 3 из 10"
+// и "стр. 4 из 10" считаются одной строкой.
+func stripRepeatedLines(md string) string {
+	pages := strings.Split(md, pageBreak)
+	if len(pages) < minPagesForDetection {
+		return joinPages(pages)
+	}
+
+	lines := make([][]string, len(pages))
+	counts := make(map[string]int)
+	for i, page := range pages {
+		lines[i] = strings.Split(page, "\n")
+
+		seen := make(map[string]bool)
+		for _, idx := range edgeLines(lines[i]) {
+			key := normalizeEdgeLine(lines[i][idx])
+			if key != "" && !seen[key] {
+				seen[key] = true
+				counts[key]++
+			}
+		}
+	}
+
+	threshold := int(float64(len(pages))*repeatedLineRatio + 0.5)
+	repeated := make(map[string]bool)
+	for key, n := range counts {
+		if n >= threshold {
+			repeated[key] = true
+		}
+	}
+	if len(repeated) == 0 {
+		return joinPages(pages)
+	}
+
+	for i := range pages {
+		drop := make(map[int]bool)
+		for _, idx := range edgeLines(lines[i]) {
+			if repeated[normalizeEdgeLine(lines[i][idx])] {
+				drop[idx] = true
+			}
+		}
+
+		kept := lines[i][:0]
+		for idx, line := range lines[i] {
+			if !drop[idx] {
+				kept = append(kept, line)
+			}
+		}
+		pages[i] = strings.Join(kept, "\n")
+	}
+
+	return joinPages(pages)
+}
+
+// edgeLines возвращает индексы первых и последних непустых строк страницы.
+func edgeLines(lines []string) []int {
+	var idx []int
+	for i, n := 0, 0; i < len(lines) && n < headerFooterLines; i++ {
+		if strings.TrimSpace(lines[i]) != "" {
+			idx = append(idx, i)
+			n++
+		}
+	}
+	for i, n := len(lines)-1, 0; i >= 0 && n < headerFooterLines; i-- {
+		if strings.TrimSpace(lines[i]) != "" {
+			idx = append(idx, i)
+			n++
+		}
+	}
+	return idx
+}
+
+func normalizeEdgeLine(line string) string {
+	line = strings.ToLower(strings.TrimSpace(line))
+	// Картинки и строки таблиц колонтитулами не считаем
+	if strings.HasPrefix(line, "|") || strings.HasPrefix(line, "![") {
+		return ""
+	}
+	line = strings.TrimLeft(line, "#* ")
+	line = digitsRe.ReplaceAllString(line, "#")
+	return strings.Join(strings.Fields(line), " ")
+}
+
+func joinPages(pages []string) string {
+	for i := range pages {
+		pages[i] = strings.TrimSpace(pages[i])
+	}
+	return strings.Join(pages, "\n\n")
+}
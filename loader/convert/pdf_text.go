@@ -0,0 +1,528 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	pdftypes "github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// convertPDFToMDNative извлекает текст и встроенные изображения страниц
+// средствами pdfcpu. Результат — markdown в том же формате, что и у Docling:
+// текст страниц, изображения как data URI и разделители страниц.
+func convertPDFToMDNative(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	conf := api.LoadConfiguration()
+	conf.Cmd = model.EXTRACTIMAGES
+
+	ctx, err := api.ReadValidateAndOptimize(f, conf)
+	if err != nil {
+		return "", fmt.Errorf("failed to read PDF: %w", err)
+	}
+
+	pages := make([]string, 0, ctx.PageCount)
+	for p := 1; p <= ctx.PageCount; p++ {
+		page, err := nativePage(ctx, p)
+		if err != nil {
+			return "", fmt.Errorf("page %d: %w", p, err)
+		}
+		pages = append(pages, page)
+	}
+
+	return stripRepeatedLines(strings.Join(pages, "\n"+pageBreak+"\n")), nil
+}
+
+func nativePage(ctx *model.Context, pageNr int) (string, error) {
+	pageDict, _, inherited, err := ctx.PageDict(pageNr, false)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+
+	content, err := ctx.PageContent(pageDict, pageNr)
+	if err != nil && err != model.ErrNoContent {
+		return "", err
+	}
+	if len(content) > 0 {
+		resources := inherited.Resources
+		if d, err := ctx.DereferenceDict(pageDict["Resources"]); err == nil && d != nil {
+			resources = d
+		}
+		b.WriteString(extractText(content, pageFonts(ctx, resources)))
+	}
+
+	images, err := pdfcpu.ExtractPageImages(ctx, pageNr, false)
+	if err != nil {
+		fmt.Printf("error while extracting images of page %d: %v\n", pageNr, err)
+	}
+	for _, img := range images {
+		mimeType := ""
+		switch img.FileType {
+		case "png":
+			mimeType = "png"
+		case "jpg", "jpeg":
+			mimeType = "jpeg"
+		default:
+			continue
+		}
+		data, err := io.ReadAll(img)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&b, "\n\n![image](data:image/%s;base64,%s)\n", mimeType, base64.StdEncoding.EncodeToString(data))
+	}
+
+	return b.String(), nil
+}
+
+// -------- fonts --------
+
+// pdfFont хранит отображение кодов символов шрифта в Unicode.
+type pdfFont struct {
+	codeLen int               // Длина кода символа в байтах (2 для составных шрифтов)
+	toUni   map[string]string // Код символа → текст, из ToUnicode CMap
+}
+
+func pageFonts(ctx *model.Context, resources pdftypes.Dict) map[string]*pdfFont {
+	fonts := make(map[string]*pdfFont)
+	if resources == nil {
+		return fonts
+	}
+
+	fontDict, err := ctx.DereferenceDict(resources["Font"])
+	if err != nil || fontDict == nil {
+		return fonts
+	}
+
+	for name, obj := range fontDict {
+		d, err := ctx.DereferenceDict(obj)
+		if err != nil || d == nil {
+			continue
+		}
+
+		font := &pdfFont{codeLen: 1}
+		if subtype := d.Subtype(); subtype != nil && *subtype == "Type0" {
+			font.codeLen = 2
+		}
+
+		if sd, _, err := ctx.DereferenceStreamDict(d["ToUnicode"]); err == nil && sd != nil {
+			if err := sd.Decode(); err == nil {
+				font.toUni = parseToUnicode(sd.Content)
+			}
+		}
+		fonts[name] = font
+	}
+	return fonts
+}
+
+var (
+	bfcharRe  = regexp.MustCompile(`(?s)beginbfchar(.*?)endbfchar`)
+	bfrangeRe = regexp.MustCompile(`(?s)beginbfrange(.*?)endbfrange`)
+	hexRe     = regexp.MustCompile(`<([0-9A-Fa-f\s]*)>|\[|\]`)
+)
+
+// parseToUnicode разбирает секции bfchar и bfrange CMap.
+func parseToUnicode(cmap []byte) map[string]string {
+	m := make(map[string]string)
+
+	for _, block := range bfcharRe.FindAllSubmatch(cmap, -1) {
+		items := hexItems(block[1])
+		for i := 0; i+1 < len(items); i += 2 {
+			m[string(items[i])] = utf16BE(items[i+1])
+		}
+	}
+
+	for _, block := range bfrangeRe.FindAllSubmatch(cmap, -1) {
+		tokens := hexRe.FindAllSubmatch(block[1], -1)
+		for i := 0; i+2 < len(tokens); {
+			lo, hi := hexBytes(tokens[i][1]), hexBytes(tokens[i+1][1])
+			start, end := codeInt(lo), codeInt(hi)
+			if end-start > 0xFFFF {
+				break
+			}
+
+			if string(tokens[i+2][0]) == "[" {
+				// <lo> <hi> [<dst1> <dst2> ...]
+				j := i + 3
+				for code := start; j < len(tokens) && string(tokens[j][0]) != "]"; j++ {
+					m[string(codeBytes(code, len(lo)))] = utf16BE(hexBytes(tokens[j][1]))
+					code++
+				}
+				i = j + 1
+				continue
+			}
+
+			// <lo> <hi> <dst>: последний символ dst увеличивается на 1
+			dst := []rune(utf16BE(hexBytes(tokens[i+2][1])))
+			for code := start; code <= end && len(dst) > 0; code++ {
+				m[string(codeBytes(code, len(lo)))] = string(dst)
+				dst[len(dst)-1]++
+			}
+			i += 3
+		}
+	}
+
+	return m
+}
+
+func hexItems(b []byte) [][]byte {
+	var items [][]byte
+	for _, t := range hexRe.FindAllSubmatch(b, -1) {
+		if len(t[1]) > 0 || string(t[0]) == "<>" {
+			items = append(items, hexBytes(t[1]))
+		}
+	}
+	return items
+}
+
+func hexBytes(b []byte) []byte {
+	s := strings.Join(strings.Fields(string(b)), "")
+	if len(s)%2 == 1 {
+		s += "0"
+	}
+	out, _ := hex.DecodeString(s)
+	return out
+}
+
+func codeInt(b []byte) int {
+	n := 0
+	for _, c := range b {
+		n = n<<8 | int(c)
+	}
+	return n
+}
+
+func codeBytes(code, n int) []byte {
+	b := make([]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		b[i] = byte(code)
+		code >>= 8
+	}
+	return b
+}
+
+func utf16BE(b []byte) string {
+	u := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		u = append(u, uint16(b[i])<<8|uint16(b[i+1]))
+	}
+	return string(utf16.Decode(u))
+}
+
+func (f *pdfFont) decode(s []byte) string {
+	if f == nil {
+		return latin1(s)
+	}
+	if f.toUni == nil {
+		// Без ToUnicode составной шрифт расшифровать нельзя
+		if f.codeLen == 2 {
+			return ""
+		}
+		return latin1(s)
+	}
+
+	var b strings.Builder
+	for i := 0; i+f.codeLen <= len(s); i += f.codeLen {
+		if r, ok := f.toUni[string(s[i:i+f.codeLen])]; ok {
+			b.WriteString(r)
+		} else if f.codeLen == 1 {
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+func latin1(s []byte) string {
+	r := make([]rune, len(s))
+	for i, c := range s {
+		r[i] = rune(c)
+	}
+	return string(r)
+}
+
+// -------- content stream --------
+
+type psKind int
+
+const (
+	psOperator psKind = iota
+	psNumber
+	psString
+	psName
+	psArray
+	psOther
+)
+
+type psToken struct {
+	kind  psKind
+	str   []byte
+	num   float64
+	items []psToken
+}
+
+// extractText интерпретирует текстовые операторы потока содержимого страницы.
+func extractText(content []byte, fonts map[string]*pdfFont) string {
+	lx := &psLexer{data: content}
+
+	var (
+		out      strings.Builder
+		line     strings.Builder
+		operands []psToken
+		font     *pdfFont
+		lastY    float64
+		haveY    bool
+	)
+
+	newline := func() {
+		if s := strings.TrimSpace(line.String()); s != "" {
+			out.WriteString(s)
+			out.WriteString("\n")
+		}
+		line.Reset()
+	}
+
+	show := func(t psToken) {
+		switch t.kind {
+		case psString:
+			line.WriteString(font.decode(t.str))
+		case psArray:
+			for _, it := range t.items {
+				if it.kind == psString {
+					line.WriteString(font.decode(it.str))
+				} else if it.kind == psNumber && it.num < -250 {
+					line.WriteString(" ")
+				}
+			}
+		}
+	}
+
+	for {
+		tok, ok := lx.next()
+		if !ok {
+			break
+		}
+		if tok.kind != psOperator {
+			operands = append(operands, tok)
+			continue
+		}
+
+		op := string(tok.str)
+		switch op {
+		case "Tf":
+			if len(operands) >= 2 && operands[0].kind == psName {
+				font = fonts[string(operands[0].str)]
+			}
+		case "Tj":
+			if len(operands) > 0 {
+				show(operands[len(operands)-1])
+			}
+		case "TJ":
+			if len(operands) > 0 {
+				show(operands[len(operands)-1])
+			}
+		case "'", "\"":
+			newline()
+			if len(operands) > 0 {
+				show(operands[len(operands)-1])
+			}
+		case "Td", "TD":
+			if len(operands) >= 2 && operands[1].num != 0 {
+				newline()
+			} else if line.Len() > 0 {
+				line.WriteString(" ")
+			}
+		case "T*":
+			newline()
+		case "Tm":
+			if len(operands) >= 6 {
+				y := operands[5].num
+				if haveY && y != lastY {
+					newline()
+				}
+				lastY, haveY = y, true
+			}
+		case "ET":
+			line.WriteString(" ")
+		case "ID":
+			lx.skipInlineImage()
+		}
+		operands = operands[:0]
+	}
+	newline()
+
+	return out.String()
+}
+
+type psLexer struct {
+	data []byte
+	pos  int
+}
+
+func isPSDelimiter(c byte) bool {
+	return strings.IndexByte("()<>[]{}/%", c) >= 0
+}
+
+func isPSSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\f' || c == 0
+}
+
+func (lx *psLexer) next() (psToken, bool) {
+	for lx.pos < len(lx.data) {
+		c := lx.data[lx.pos]
+		switch {
+		case isPSSpace(c):
+			lx.pos++
+		case c == '%':
+			for lx.pos < len(lx.data) && lx.data[lx.pos] != '\n' && lx.data[lx.pos] != '\r' {
+				lx.pos++
+			}
+		case c == '(':
+			return psToken{kind: psString, str: lx.literal()}, true
+		case c == '<':
+			if lx.pos+1 < len(lx.data) && lx.data[lx.pos+1] == '<' {
+				lx.pos += 2
+				return psToken{kind: psOther}, true
+			}
+			end := bytes.IndexByte(lx.data[lx.pos:], '>')
+			if end < 0 {
+				lx.pos = len(lx.data)
+				return psToken{}, false
+			}
+			s := hexBytes(lx.data[lx.pos+1 : lx.pos+end])
+			lx.pos += end + 1
+			return psToken{kind: psString, str: s}, true
+		case c == '>':
+			lx.pos++
+			if lx.pos < len(lx.data) && lx.data[lx.pos] == '>' {
+				lx.pos++
+			}
+			return psToken{kind: psOther}, true
+		case c == '[':
+			lx.pos++
+			arr := psToken{kind: psArray}
+			for {
+				t, ok := lx.next()
+				if !ok || (t.kind == psOther && string(t.str) == "]") {
+					break
+				}
+				arr.items = append(arr.items, t)
+			}
+			return arr, true
+		case c == ']':
+			lx.pos++
+			return psToken{kind: psOther, str: []byte("]")}, true
+		case c == '{' || c == '}':
+			lx.pos++
+			return psToken{kind: psOther}, true
+		case c == '/':
+			lx.pos++
+			return psToken{kind: psName, str: lx.regular()}, true
+		default:
+			word := lx.regular()
+			if len(word) == 0 {
+				lx.pos++
+				continue
+			}
+			if n, err := strconv.ParseFloat(string(word), 64); err == nil {
+				return psToken{kind: psNumber, num: n}, true
+			}
+			return psToken{kind: psOperator, str: word}, true
+		}
+	}
+	return psToken{}, false
+}
+
+func (lx *psLexer) regular() []byte {
+	start := lx.pos
+	for lx.pos < len(lx.data) && !isPSSpace(lx.data[lx.pos]) && !isPSDelimiter(lx.data[lx.pos]) {
+		lx.pos++
+	}
+	return lx.data[start:lx.pos]
+}
+
+// literal читает строку в круглых скобках с учётом вложенности и экранирования.
+func (lx *psLexer) literal() []byte {
+	lx.pos++ // (
+	var out []byte
+	depth := 1
+	for lx.pos < len(lx.data) {
+		c := lx.data[lx.pos]
+		lx.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return out
+			}
+		case '\\':
+			if lx.pos >= len(lx.data) {
+				return out
+			}
+			e := lx.data[lx.pos]
+			lx.pos++
+			switch e {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r', '\n':
+				// Перенос строки внутри строки игнорируется
+				if e == '\r' && lx.pos < len(lx.data) && lx.data[lx.pos] == '\n' {
+					lx.pos++
+				}
+				continue
+			default:
+				if e >= '0' && e <= '7' {
+					n := int(e - '0')
+					for k := 0; k < 2 && lx.pos < len(lx.data) && lx.data[lx.pos] >= '0' && lx.data[lx.pos] <= '7'; k++ {
+						n = n*8 + int(lx.data[lx.pos]-'0')
+						lx.pos++
+					}
+					c = byte(n)
+				} else {
+					c = e
+				}
+			}
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// skipInlineImage пропускает двоичные данные встроенного изображения до EI.
+func (lx *psLexer) skipInlineImage() {
+	for lx.pos+2 < len(lx.data) {
+		if isPSSpace(lx.data[lx.pos]) && lx.data[lx.pos+1] == 'E' && lx.data[lx.pos+2] == 'I' &&
+			(lx.pos+3 >= len(lx.data) || isPSSpace(lx.data[lx.pos+3])) {
+			lx.pos += 3
+			return
+		}
+		lx.pos++
+	}
+	lx.pos = len(lx.data)
+}
@@ -0,0 +1,271 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"rag/loader/convert"
+	"rag/model"
+	"rag/types"
+	"sync"
+	"time"
+)
+
+// SaveFunc сохраняет документ; ошибка сохранения считается временной.
+type SaveFunc func(context.Context, *types.Document) error
+
+// ErrSaveFailed оборачивает ошибки сохранения документа в БД.
+var ErrSaveFailed = errors.New("failed to save document")
+
+// isTransient сообщает, имеет ли смысл повторить обработку файла позже.
+func isTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, convert.ErrDoclingUnavailable) ||
+		errors.Is(err, model.ErrUnavailable) ||
+		errors.Is(err, ErrSaveFailed) ||
+		errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// jobStats собирает длительность этапов обработки одного задания и число
+// переиспользованных и пересчитанных чанков. Этапы могут выполняться
+// из нескольких горутин, поэтому нужна блокировка.
+type jobStats struct {
+	mu     sync.Mutex
+	ms     map[string]int64
+	chunks types.ChunkStats
+	stage  types.JobStage
+	// onStage вызывается при смене этапа
+	onStage func(types.JobStage)
+}
+
+type jobStatsKey struct{}
+
+func withJobStats(ctx context.Context) (context.Context, *jobStats) {
+	t := &jobStats{ms: make(map[string]int64)}
+	return context.WithValue(ctx, jobStatsKey{}, t), t
+}
+
+// trackStage добавляет время, прошедшее с start, к этапу stage.
+// Вне задания (обход дерева, сайты) ничего не делает.
+func trackStage(ctx context.Context, stage string, start time.Time) {
+	t, ok := ctx.Value(jobStatsKey{}).(*jobStats)
+	if !ok {
+		return
+	}
+	t.mu.Lock()
+	t.ms[stage] += time.Since(start).Milliseconds()
+	t.mu.Unlock()
+}
+
+// setStage отмечает этап, на котором находится задание. Этапы чередуются
+// (описание изображения, затем эмбеддинг), поэтому сообщается только смена этапа.
+func setStage(ctx context.Context, stage types.JobStage) {
+	t, ok := ctx.Value(jobStatsKey{}).(*jobStats)
+	if !ok {
+		return
+	}
+	t.mu.Lock()
+	changed := t.stage != stage
+	t.stage = stage
+	t.mu.Unlock()
+	if changed && t.onStage != nil {
+		t.onStage(stage)
+	}
+}
+
+// countChunks добавляет к заданию статистику чанков одного документа.
+func countChunks(ctx context.Context, stats types.ChunkStats) {
+	t, ok := ctx.Value(jobStatsKey{}).(*jobStats)
+	if !ok {
+		return
+	}
+	t.mu.Lock()
+	t.chunks.Reused += stats.Reused
+	t.chunks.Computed += stats.Computed
+	t.mu.Unlock()
+}
+
+func (t *jobStats) timings() map[string]int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make(map[string]int64, len(t.ms))
+	for k, v := range t.ms {
+		out[k] = v
+	}
+	return out
+}
+
+func (t *jobStats) chunkStats() types.ChunkStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.chunks
+}
+
+// claimJob забирает следующее задание из очереди, ожидая его не дольше PollInterval.
+func (l *PDFLoader) claimJob(ctx context.Context) (*types.Job, error) {
+	job, err := l.store.ClaimJob(ctx, l.cfg.Jobs.Lease)
+	if err == nil {
+		return job, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		fmt.Printf("error claiming job: %s\n", err)
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(l.cfg.Jobs.PollInterval):
+		return nil, nil
+	}
+}
+
+// runJob загружает файл задания, сохраняет документы и фиксирует результат.
+func (l *PDFLoader) runJob(ctx context.Context, worker int, job *types.Job, save SaveFunc) {
+	fmt.Printf("Worker #%d processing job %s (attempt %d): %s\n", worker, job.ID, job.Attempts, job.Path)
+
+	if err := restoreFile(job); err != nil {
+		l.failJob(job, err, nil)
+		return
+	}
+
+	jobCtx, stats := withJobStats(ctx)
+	stats.onStage = func(stage types.JobStage) {
+		if err := l.store.SetJobStage(ctx, job.ID, stage); err != nil {
+			fmt.Printf("error updating stage of job %s: %s\n", job.ID, err)
+		}
+	}
+	err := l.processFile(jobCtx, job.Path, save)
+
+	// Задание возвращается в очередь, только если обработку прервала
+	// остановка: успешно обработанный файл уже сохранён и перенесён в архив
+	if err != nil && ctx.Err() != nil {
+		fmt.Printf("Job %s interrupted due to context cancellation, returning to queue\n", job.ID)
+		// Файл остаётся в source, задание будет выполнено при следующем запуске
+		if err := l.store.ReleaseJob(context.Background(), job.ID); err != nil {
+			fmt.Printf("error releasing job %s: %s\n", job.ID, err)
+		}
+		return
+	}
+
+	if err != nil {
+		l.failJob(job, err, stats.timings())
+		return
+	}
+
+	chunks := stats.chunkStats()
+	// Отдельный контекст: результат фиксируется и при остановке
+	if err := l.store.CompleteJob(context.Background(), job.ID, stats.timings(), chunks); err != nil {
+		fmt.Printf("error completing job %s: %s\n", job.ID, err)
+	}
+	fmt.Printf("Job %s done: %d chunks reused, %d recomputed\n", job.ID, chunks.Reused, chunks.Computed)
+	cache := l.embedCache.Stats()
+	fmt.Printf("Embedding cache: %d memory hits, %d store hits, %d misses, hit rate %.1f%%\n",
+		cache.MemoryHit, cache.StoreHit, cache.Misses, cache.HitRate*100)
+}
+
+// processFile загружает файл и передаёт документы на сохранение.
+func (l *PDFLoader) processFile(ctx context.Context, filePath string, save SaveFunc) error {
+	docs, err := l.loadFile(ctx, filePath)
+	if err != nil {
+		return err
+	}
+
+	setStage(ctx, types.JobStageSaving)
+	start := time.Now()
+	for _, doc := range docs {
+		if err := save(ctx, doc); err != nil {
+			return fmt.Errorf("%w: %v", ErrSaveFailed, err)
+		}
+	}
+	trackStage(ctx, "save", start)
+
+	// Письма порождают несколько документов, поэтому архивируем файл здесь,
+	// а не после сохранения каждого документа
+	if isMailFile(filePath) {
+		l.MoveToArchive(filePath, 0)
+	}
+	return nil
+}
+
+// failJob планирует повтор временной ошибки с экспоненциальной задержкой
+// либо переводит задание в dead и перемещает файл в BadDir.
+func (l *PDFLoader) failJob(job *types.Job, jobErr error, timings map[string]int64) {
+	ctx := context.Background()
+	if timings == nil {
+		timings = map[string]int64{}
+	}
+
+	if isTransient(jobErr) && job.Attempts < l.cfg.Jobs.MaxAttempts {
+		at := time.Now().Add(l.backoff(job.Attempts))
+		fmt.Printf("Job %s failed (attempt %d/%d), retry at %s: %v\n", job.ID, job.Attempts, l.cfg.Jobs.MaxAttempts, at.Format(time.RFC3339), jobErr)
+		if err := l.store.RetryJob(ctx, job.ID, jobErr, at, timings); err != nil {
+			fmt.Printf("error scheduling retry for job %s: %s\n", job.ID, err)
+		}
+		return
+	}
+
+	fmt.Printf("Job %s is dead after %d attempts: %v\n", job.ID, job.Attempts, jobErr)
+	badPath := ""
+	if _, err := os.Stat(job.Path); err == nil {
+		badPath = l.MoveToArchive(job.Path, 1)
+	}
+	if err := l.store.FailJob(ctx, job.ID, jobErr, badPath, timings); err != nil {
+		fmt.Printf("error marking job %s as dead: %s\n", job.ID, err)
+	}
+}
+
+// backoff возвращает задержку перед повтором: Backoff * 2^(attempt-1), не больше MaxBackoff.
+func (l *PDFLoader) backoff(attempt int) time.Duration {
+	d := l.cfg.Jobs.Backoff
+	for i := 1; i < attempt && d < l.cfg.Jobs.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > l.cfg.Jobs.MaxBackoff {
+		d = l.cfg.Jobs.MaxBackoff
+	}
+	return d
+}
+
+// restoreFile возвращает файл повторно поставленного в очередь задания
+// из BadDir в каталог источника.
+func restoreFile(job *types.Job) error {
+	if _, err := os.Stat(job.Path); err == nil || job.BadPath == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(job.Path), 0755); err != nil {
+		return err
+	}
+	in, err := os.Open(job.BadPath)
+	if err != nil {
+		return fmt.Errorf("file is missing in source and bad dir: %w", err)
+	}
+	defer in.Close()
+
+	out, err := os.Create(job.Path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	fmt.Printf("File restored from bad dir: %s\n", job.Path)
+	in.Close()
+	return os.Remove(job.BadPath)
+}
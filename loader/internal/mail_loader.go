@@ -0,0 +1,373 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"rag/types"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/net/html"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+const SourceEmail = "email"
+
+// isMailFile сообщает, является ли файл письмом или почтовым архивом.
+func isMailFile(filePath string) bool {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".eml", ".mbox":
+		return true
+	}
+	return false
+}
+
+// mailMessage — разобранное письмо.
+type mailMessage struct {
+	Header      mail.Header
+	Text        string
+	HTML        string
+	Attachments []mailAttachment
+}
+
+type mailAttachment struct {
+	Filename string
+	Data     []byte
+}
+
+var wordDecoder = &mime.WordDecoder{CharsetReader: charsetReader}
+
+// fetchMail разбивает .eml/.mbox на письма и возвращает документ для каждого
+// письма и дочерние документы для его PDF/DOCX вложений.
+func (l *PDFLoader) fetchMail(ctx context.Context, filePath string) ([]*types.Document, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw [][]byte
+	if strings.EqualFold(filepath.Ext(filePath), ".mbox") {
+		raw = splitMbox(data)
+	} else {
+		raw = [][]byte{data}
+	}
+
+	var docs []*types.Document
+	for i, r := range raw {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		msg, err := parseMail(r)
+		if err != nil {
+			fmt.Printf("Error parsing message #%d in %s: %v\n", i, filePath, err)
+			continue
+		}
+
+		msgDocs, err := l.mailDocuments(ctx, filePath, i, msg)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, msgDocs...)
+	}
+
+	if len(docs) == 0 {
+		return nil, fmt.Errorf("no messages found in %s", filePath)
+	}
+	return docs, nil
+}
+
+func (l *PDFLoader) mailDocuments(ctx context.Context, filePath string, index int, msg *mailMessage) ([]*types.Document, error) {
+	meta := mailMetadata(msg.Header)
+
+	key := meta["message_id"]
+	if key == "" {
+		key = fmt.Sprintf("%s#%d", filePath, index)
+	}
+	id, _ := uuid.Parse(generateDocumentID(key))
+
+	date := time.Now()
+	if d, err := msg.Header.Date(); err == nil {
+		date = d
+	}
+
+	body := msg.Text
+	if strings.TrimSpace(body) == "" && msg.HTML != "" {
+		if root, err := html.Parse(strings.NewReader(msg.HTML)); err == nil {
+			body = htmlToMarkdown(root)
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Тема: %s\nОт: %s\nДата: %s\n\n", meta["subject"], meta["from"], meta["date"])
+	b.WriteString(body)
+
+	chunks, tables, err := l.chunkMarkdown(ctx, b.String(), id, l.cfg.ChunkSize, l.cfg.ChunkOverlap, l.previousChunks(ctx, id, ""))
+	if err != nil {
+		return nil, err
+	}
+
+	title := meta["subject"]
+	if title == "" {
+		title = generateTitle(filePath)
+	}
+	sourcePath := fmt.Sprintf("%s#%s", filePath, key)
+
+	parent := &types.Document{
+		ID:         id,
+		Title:      title,
+		Chunks:     chunks,
+		FullTable:  tables,
+		Source:     SourceEmail,
+		SourcePath: sourcePath,
+		CreatedAt:  date,
+		UpdatedAt:  date,
+		Version:    1,
+		Metadata:   meta,
+		Converter:  types.ConverterEmail,
+	}
+	docs := []*types.Document{parent}
+
+	for i, att := range msg.Attachments {
+		child, err := l.attachmentDocument(ctx, parent, i, att)
+		if isTransient(err) {
+			return nil, err
+		}
+		if err != nil {
+			fmt.Printf("Error processing attachment %s: %v\n", att.Filename, err)
+			continue
+		}
+		docs = append(docs, child)
+	}
+
+	return docs, nil
+}
+
+// attachmentDocument прогоняет вложение через Docling как обычный файл.
+func (l *PDFLoader) attachmentDocument(ctx context.Context, parent *types.Document, index int, att mailAttachment) (*types.Document, error) {
+	tmpDir, err := os.MkdirTemp("", "rag-attachment-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	tmpPath := filepath.Join(tmpDir, filepath.Base(att.Filename))
+	if err := os.WriteFile(tmpPath, att.Data, 0644); err != nil {
+		return nil, err
+	}
+
+	md, converter, err := l.convertToMD(ctx, tmpPath, types.SourceSettings{})
+	if err != nil {
+		return nil, err
+	}
+
+	id, _ := uuid.Parse(generateDocumentID(fmt.Sprintf("%s/%d/%s", parent.ID, index, att.Filename)))
+	chunks, tables, err := l.chunkMarkdown(ctx, md, id, l.cfg.ChunkSize, l.cfg.ChunkOverlap, l.previousChunks(ctx, id, ""))
+	if err != nil {
+		return nil, err
+	}
+
+	meta := map[string]string{
+		"filename":   att.Filename,
+		"message_id": parent.Metadata["message_id"],
+		"thread_id":  parent.Metadata["thread_id"],
+		"subject":    parent.Metadata["subject"],
+	}
+
+	return &types.Document{
+		ID:         id,
+		Title:      generateTitle(att.Filename),
+		Chunks:     chunks,
+		FullTable:  tables,
+		Source:     SourceEmail,
+		SourcePath: parent.SourcePath + "/" + att.Filename,
+		CreatedAt:  parent.CreatedAt,
+		UpdatedAt:  parent.UpdatedAt,
+		Version:    1,
+		ParentID:   uuid.NullUUID{UUID: parent.ID, Valid: true},
+		Metadata:   meta,
+		Converter:  converter,
+	}, nil
+}
+
+func mailMetadata(h mail.Header) map[string]string {
+	decode := func(key string) string {
+		v := h.Get(key)
+		if d, err := wordDecoder.DecodeHeader(v); err == nil {
+			return strings.TrimSpace(d)
+		}
+		return strings.TrimSpace(v)
+	}
+
+	messageID := strings.Trim(h.Get("Message-Id"), "<> ")
+
+	// Идентификатор треда — первое письмо цепочки
+	threadID := messageID
+	if refs := strings.Fields(h.Get("References")); len(refs) > 0 {
+		threadID = strings.Trim(refs[0], "<>")
+	} else if reply := strings.Trim(h.Get("In-Reply-To"), "<> "); reply != "" {
+		threadID = reply
+	}
+
+	return map[string]string{
+		"from":       decode("From"),
+		"to":         decode("To"),
+		"date":       h.Get("Date"),
+		"subject":    decode("Subject"),
+		"message_id": messageID,
+		"thread_id":  threadID,
+	}
+}
+
+// splitMbox делит mbox на письма по строкам-разделителям "From ".
+func splitMbox(data []byte) [][]byte {
+	var messages [][]byte
+	var cur bytes.Buffer
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if bytes.HasPrefix(line, []byte("From ")) {
+			if cur.Len() > 0 {
+				messages = append(messages, bytes.Clone(cur.Bytes()))
+				cur.Reset()
+			}
+			continue
+		}
+		// Снимаем экранирование >From (mboxrd)
+		if bytes.HasPrefix(line, []byte(">")) && bytes.HasPrefix(bytes.TrimLeft(line, ">"), []byte("From ")) {
+			line = line[1:]
+		}
+		cur.Write(line)
+		cur.WriteString("\n")
+	}
+	if cur.Len() > 0 {
+		messages = append(messages, cur.Bytes())
+	}
+	return messages
+}
+
+func parseMail(raw []byte) (*mailMessage, error) {
+	m, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &mailMessage{Header: m.Header}
+	err = walkMailPart(msg, m.Header.Get("Content-Type"), m.Header.Get("Content-Transfer-Encoding"), m.Header.Get("Content-Disposition"), m.Body)
+	return msg, err
+}
+
+func walkMailPart(msg *mailMessage, contentType, encoding, disposition string, body io.Reader) error {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			err = walkMailPart(msg,
+				part.Header.Get("Content-Type"),
+				part.Header.Get("Content-Transfer-Encoding"),
+				part.Header.Get("Content-Disposition"),
+				part)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := io.ReadAll(decodeTransfer(encoding, body))
+	if err != nil {
+		return err
+	}
+
+	filename := attachmentName(disposition, params)
+	if filename != "" || strings.HasPrefix(disposition, "attachment") {
+		if isIngestibleAttachment(mediaType, filename) {
+			msg.Attachments = append(msg.Attachments, mailAttachment{Filename: filename, Data: data})
+		}
+		return nil
+	}
+
+	switch mediaType {
+	case "text/plain":
+		msg.Text += decodeCharset(params["charset"], data)
+	case "text/html":
+		msg.HTML += decodeCharset(params["charset"], data)
+	}
+	return nil
+}
+
+func attachmentName(disposition string, params map[string]string) string {
+	name := params["name"]
+	if _, dp, err := mime.ParseMediaType(disposition); err == nil && dp["filename"] != "" {
+		name = dp["filename"]
+	}
+	if d, err := wordDecoder.DecodeHeader(name); err == nil {
+		name = d
+	}
+	return name
+}
+
+func isIngestibleAttachment(mediaType, filename string) bool {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".pdf", ".docx":
+		return true
+	}
+	return mediaType == "application/pdf" ||
+		mediaType == "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+}
+
+func decodeTransfer(encoding string, r io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, r)
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	}
+	return r
+}
+
+func decodeCharset(charset string, data []byte) string {
+	if charset == "" || strings.EqualFold(charset, "utf-8") || strings.EqualFold(charset, "us-ascii") {
+		return string(data)
+	}
+	r, err := charsetReader(charset, bytes.NewReader(data))
+	if err != nil {
+		return string(data)
+	}
+	decoded, err := io.ReadAll(r)
+	if err != nil {
+		return string(data)
+	}
+	return string(decoded)
+}
+
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, err
+	}
+	return enc.NewDecoder().Reader(input), nil
+}
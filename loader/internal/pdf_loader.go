@@ -1,19 +1,22 @@
 package internal
 
 import (
-	"bytes"
 	"context"
 	"crypto/md5"
+	"crypto/sha256"
 	"database/sql"
-	"encoding/json"
+	"encoding/hex"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"log"
-	"mime/multipart"
-	"net/http"
 	"os"
 	"path/filepath"
+	"rag/cleanup"
+	"rag/loader/convert"
 	"rag/model"
+	"rag/prompt"
+	"rag/store"
 	"rag/types"
 	"regexp"
 	"strings"
@@ -23,173 +26,141 @@ import (
 	"github.com/google/uuid"
 )
 
-type PDFLoader struct {
-	cfg       types.Config
-	embedder  model.EmbedderInterface
-	converter model.VisionModel
+const SourcePDF = "pdf"
 
-	FileMutex       sync.Mutex
-	FileFirstSeen   map[string]time.Time
-	FilesProcessing map[string]bool
+type PDFLoader struct {
+	cfg      types.Config
+	embedder model.EmbedderInterface
+	// Тот же embedder; нужен для статистики кэша
+	embedCache *model.CachedEmbedder
+	converter  model.VisionModel
+	prompts    *prompt.Library // Шаблоны converter; версия describe_image входит в хеш изображений
+	store      store.LoaderStorer
+	docling    *convert.DoclingClient
+	files      *fileTracker
+	include    []*regexp.Regexp
+	exclude    []*regexp.Regexp
+
+	// Ограничения параллельности этапов, общие для всех воркеров
+	convertLimit limiter
+	visionLimit  limiter
+	embedLimit   limiter
 }
 
-func NewPDFLoader(cfg types.Config) *PDFLoader {
+func NewPDFLoader(cfg types.Config, storer store.LoaderStorer) *PDFLoader {
 	createDirectories(cfg.SourceDir, cfg.ArchiveDir, cfg.BadDir)
-	embedder := model.NewOllamaEmbedder()
+	embedder := model.NewCachedEmbedder(model.NewEmbedder(), storer)
+	prompts := prompt.NewLibrary(storer)
 	converter := model.NewLLaVA()
+	converter.Prompts = prompts
 	return &PDFLoader{
-		cfg:             cfg,
-		FileFirstSeen:   make(map[string]time.Time),
-		FilesProcessing: make(map[string]bool),
-		embedder:        embedder,
-		converter:       converter,
+		cfg:          cfg,
+		embedder:     embedder,
+		embedCache:   embedder,
+		converter:    converter,
+		prompts:      prompts,
+		store:        storer,
+		docling:      convert.NewDoclingClient(cfg.Docling),
+		files:        newFileTracker(),
+		include:      compileGlobs(cfg.Watch.Include),
+		exclude:      compileGlobs(cfg.Watch.Exclude),
+		convertLimit: newLimiter(cfg.Workers.Convert),
+		visionLimit:  newLimiter(cfg.Workers.Vision),
+		embedLimit:   newLimiter(cfg.Workers.Embed),
 	}
 }
 
-func (l *PDFLoader) WatchFile(ctx context.Context, fileChan chan<- string) {
-	fmt.Printf("Start monitoring folder: %s\n", l.cfg.SourceDir)
-
-	ticker := time.NewTicker(1 * time.Second)
-	defer ticker.Stop()
-	defer fmt.Println("File watcher stopped and cleaned up")
-
-	for {
-		// Проверяем контекст перед каждой итерацией для быстрой остановки
-		if ctx.Err() != nil {
-			fmt.Println("Stopping file watcher (pre-check)...")
-			return
-		}
-
-		select {
-		case <-ctx.Done():
-			fmt.Println("Stopping file watcher (context cancelled)...")
-			return
-		case <-ticker.C:
-			files, err := os.ReadDir(l.cfg.SourceDir)
-			if err != nil {
-				fmt.Printf("error while reading source directory: %s\n", err)
-				continue
-			}
-
-			currentFiles := make(map[string]bool)
+// sourceSettings возвращает настройки самого вложенного каталога-источника,
+// содержащего файл. Если настроек нет, возвращаются значения по умолчанию.
+func (l *PDFLoader) sourceSettings(filePath string) types.SourceSettings {
+	all, err := l.store.ListSourceSettings(context.Background())
+	if err != nil {
+		fmt.Printf("error while loading source settings: %s\n", err)
+		return types.SourceSettings{}
+	}
+	return convert.MatchSourceSettings(all, filePath)
+}
 
-			for _, file := range files {
-				if file.IsDir() {
-					continue
-				}
+// cleanMarkdown применяет набор правил очистки, назначенный каталогу-источнику.
+func (l *PDFLoader) cleanMarkdown(settings types.SourceSettings, md string) string {
+	if settings.CleanupRules == "" {
+		return md
+	}
 
-				filePath := filepath.Join(l.cfg.SourceDir, file.Name())
-				currentFiles[filePath] = true
+	set, err := l.store.GetCleanupRuleSet(context.Background(), settings.CleanupRules)
+	if err != nil {
+		fmt.Printf("error while loading cleanup rules %q: %s\n", settings.CleanupRules, err)
+		return md
+	}
 
-				l.FileMutex.Lock()
-				// Проверяем, не находится ли файл в обработке
-				if l.FilesProcessing[filePath] {
-					l.FileMutex.Unlock()
-					continue
-				}
+	cleaner, err := cleanup.Compile(*set)
+	if err != nil {
+		fmt.Printf("invalid cleanup rules %q: %s\n", settings.CleanupRules, err)
+		return md
+	}
+	return cleaner.Apply(md)
+}
 
-				// Если файл новый, добавляем его в отслеживание
-				if _, exists := l.FileFirstSeen[filePath]; !exists {
-					l.FileFirstSeen[filePath] = time.Now()
-					fmt.Printf("New file detected: %s\n", filePath)
-					l.FileMutex.Unlock()
-					continue
-				}
+// ProcessFile запускает пул из cfg.Workers.Files воркеров, забирающих задания
+// из очереди, и ждёт их завершения. При остановке прерванные задания
+// возвращаются в очередь, а их файлы остаются в каталоге источника.
+func (l *PDFLoader) ProcessFile(ctx context.Context, save SaveFunc) {
+	defer fmt.Println("File processor stopped and cleaned up")
 
-				// Проверяем, готов ли файл к отправке
-				firstSeen := l.FileFirstSeen[filePath]
-				l.FileMutex.Unlock()
-
-				if time.Since(firstSeen) > l.cfg.MonitoringTime {
-					fmt.Printf("The file %s has not been modified for more than %v seconds. Start processing...\n", filePath, l.cfg.MonitoringTime.Seconds())
-
-					// Помечаем файл как находящийся в обработке
-					l.FileMutex.Lock()
-					l.FilesProcessing[filePath] = true
-					l.FileMutex.Unlock()
-
-					// Отправляем файл в канал (неблокирующая отправка с контекстом)
-					select {
-					case fileChan <- filePath:
-					case <-ctx.Done():
-						return
-					}
-				} else {
-					fmt.Printf("The file %s is not ready for sending yet\n", filePath)
-				}
-			}
+	workers := l.cfg.Workers.Files
+	if workers <= 0 {
+		workers = 1
+	}
 
-			// Удаляем из карты файлы, которых больше нет в директории
-			l.FileMutex.Lock()
-			for filePath := range l.FileFirstSeen {
-				if !currentFiles[filePath] {
-					delete(l.FileFirstSeen, filePath)
-					delete(l.FilesProcessing, filePath)
-					fmt.Printf("The file has been removed from tracking: %s\n", filePath)
-				}
-			}
-			l.FileMutex.Unlock()
-		}
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			l.processWorker(ctx, worker, save)
+		}(i)
 	}
+	wg.Wait()
 }
 
-func (l *PDFLoader) ProcessFile(ctx context.Context, fileChan <-chan string, docChan chan<- *types.Document) {
-	defer fmt.Println("File processor stopped and cleaned up")
-
+func (l *PDFLoader) processWorker(ctx context.Context, worker int, save SaveFunc) {
 	for {
 		// Проверяем контекст перед каждой итерацией для быстрой остановки
 		if ctx.Err() != nil {
-			fmt.Println("Stopping file processor (pre-check)...")
+			fmt.Printf("Stopping file worker #%d (context cancelled)...\n", worker)
 			return
 		}
 
-		select {
-		case <-ctx.Done():
-			fmt.Println("Stopping file processor (context cancelled)...")
-			return
-		case filePath, ok := <-fileChan:
-			if !ok {
-				// Канал закрыт, завершаем работу
-				fmt.Println("File channel closed, stopping processor...")
-				return
-			}
-
-			// Проверяем контекст перед обработкой файла
-			if ctx.Err() != nil {
-				fmt.Println("Context cancelled before processing, stopping processor...")
-				return
-			}
-			l.FileMutex.Lock()
-			fmt.Printf("Processing file: %s\n", filePath)
-			doc, err := l.fetchFile(ctx, filePath)
-			if err != nil {
-				fmt.Println("Error to fatch file", err)
-			}
-			docChan <- doc
-			l.FileMutex.Unlock()
-
-			// Проверяем был ли контекст отменён во время обработки
-			if ctx.Err() != nil {
-				fmt.Printf("File processing interrupted due to context cancellation: %s\n", filePath)
-				// Очищаем состояние файла но не удаляем его из source
-				l.FileMutex.Lock()
-				delete(l.FilesProcessing, filePath)
-				// НЕ удаляем из fileFirstSeen, чтобы файл был обработан при следующем запуске
-				l.FileMutex.Unlock()
-				return
-			}
-
-			if err != nil {
-				fmt.Printf("Error processing file %s: %v\n", filePath, err)
-			}
+		job, err := l.claimJob(ctx)
+		if err != nil || job == nil {
+			continue
+		}
+		l.runJob(ctx, worker, job, save)
+	}
+}
 
-			// Удаляем файл из списка обрабатываемых
-			l.FileMutex.Lock()
-			delete(l.FilesProcessing, filePath)
-			delete(l.FileFirstSeen, filePath)
-			l.FileMutex.Unlock()
+// loadFile выбирает загрузчик по типу файла.
+// Коллекция и теги документов берутся из подкаталогов, в которых лежит файл.
+func (l *PDFLoader) loadFile(ctx context.Context, filePath string) ([]*types.Document, error) {
+	var docs []*types.Document
+	if isMailFile(filePath) {
+		var err error
+		if docs, err = l.fetchMail(ctx, filePath); err != nil {
+			return nil, err
 		}
+	} else {
+		doc, err := l.fetchFile(ctx, filePath)
+		if err != nil {
+			return nil, err
+		}
+		docs = []*types.Document{doc}
 	}
+
+	collection, tags := l.pathLabels(filePath)
+	for _, doc := range docs {
+		doc.Collection, doc.Tags = collection, tags
+	}
+	return docs, nil
 }
 
 func (l *PDFLoader) fetchFile(ctx context.Context, filePath string) (*types.Document, error) {
@@ -200,31 +171,38 @@ func (l *PDFLoader) fetchFile(ctx context.Context, filePath string) (*types.Docu
 		return nil, fmt.Errorf("file does not exist: %s", filePath)
 	}
 
-	docIDStr := generateDocumentID(filePath)
-
-	id, err := uuid.Parse(docIDStr)
+	// Документ идентифицируется содержимым, а не путём: повторная загрузка
+	// того же файла под другим именем не создаёт дубликат
+	hash, err := hashFile(filePath)
 	if err != nil {
-		fmt.Println("invalid uuid:", err)
 		return nil, err
 	}
+	id := types.DocumentIDFromHash(hash)
+
+	doc := &types.Document{
+		ID:          id,
+		Title:       generateTitle(filePath),
+		Source:      SourcePDF,
+		SourcePath:  filePath,
+		CreatedAt:   fileInfo.ModTime(),
+		UpdatedAt:   fileInfo.ModTime(),
+		Version:     1,
+		ContentHash: hash,
+		LogicalPath: filePath,
+	}
 
-	chunks, tables, err := l.splitByChunks(filePath, id, l.cfg.ChunkSize, l.cfg.ChunkOverlap)
+	// Такое содержимое уже загружено — конвертация не нужна, достаточно обновить путь
+	if _, err := l.store.GetDocumentByID(ctx, id); err == nil {
+		fmt.Printf("Content of %s is already loaded as document %s\n", filePath, id)
+		return doc, nil
+	}
+
+	prev := l.previousChunks(ctx, id, filePath)
+	chunks, tables, converter, err := l.splitByChunks(ctx, filePath, id, l.cfg.ChunkSize, l.cfg.ChunkOverlap, prev)
 	if err != nil {
 		return nil, err
 	}
-
-	doc := &types.Document{
-		ID:         id,
-		Title:      generateTitle(filePath),
-		Chunks:     chunks,
-		FullTable:  tables,
-		Source:     "pdf",
-		SourcePath: filePath,
-		CreatedAt:  fileInfo.ModTime(),
-		UpdatedAt:  fileInfo.ModTime(),
-		Version:    1,
-	}
-	//fmt.Println(doc)
+	doc.Chunks, doc.FullTable, doc.Converter = chunks, tables, converter
 
 	// Проверка контекста перед запросом к БД
 	if ctx.Err() != nil {
@@ -240,10 +218,8 @@ func (l *PDFLoader) fetchFile(ctx context.Context, filePath string) (*types.Docu
 
 func generateTitle(filePath string) string {
 	fileName := filepath.Base(filePath)
-	// Удаляем расширение .pdf
-	if strings.HasSuffix(strings.ToLower(fileName), ".pdf") {
-		fileName = fileName[:len(fileName)-4]
-	}
+	// Удаляем расширение (.pdf, .md, ...)
+	fileName = strings.TrimSuffix(fileName, filepath.Ext(fileName))
 	// Заменяем подчеркивания и дефисы на пробелы
 	fileName = strings.ReplaceAll(fileName, "_", " ")
 	fileName = strings.ReplaceAll(fileName, "-", " ")
@@ -255,7 +231,24 @@ func generateDocumentID(filePath string) string {
 	return fmt.Sprintf("%x", hash)
 }
 
-func (l *PDFLoader) MoveToArchive(filePath string, fileState int) {
+// hashFile возвращает sha256 содержимого файла.
+func hashFile(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// MoveToArchive перемещает файл в архив (fileState 0) или в BadDir (1)
+// и возвращает новый путь файла.
+func (l *PDFLoader) MoveToArchive(filePath string, fileState int) string {
 	var state string
 	switch fileState {
 	case 1:
@@ -271,7 +264,7 @@ func (l *PDFLoader) MoveToArchive(filePath string, fileState int) {
 	if _, err := os.Stat(destDir); os.IsNotExist(err) {
 		if err := os.MkdirAll(destDir, 0755); err != nil {
 			fmt.Printf("error creating directory: %s\n", err)
-			return
+			return ""
 		}
 	}
 
@@ -314,6 +307,7 @@ func (l *PDFLoader) MoveToArchive(filePath string, fileState int) {
 	fmt.Printf("File moved to archive: %s\n", destPath)
 	in.Close()
 	os.Remove(filePath)
+	return destPath
 }
 
 func createDirectories(sourceDir, archiveDir, badDir string) error {
@@ -326,58 +320,162 @@ func createDirectories(sourceDir, archiveDir, badDir string) error {
 	return nil
 }
 
-func (l *PDFLoader) addTextChunks(chunks *[]types.Chunk, text string, docID uuid.UUID, startIndex *int, chunkSize, overlap int) {
-	words := strings.Fields(text)
+func (l *PDFLoader) addTextChunks(ctx context.Context, chunks *[]types.Chunk, text string, docID uuid.UUID, startIndex *int, chunkSize, overlap int, prev *chunkPool) error {
+	contents := splitTextChunks(text, chunkSize, overlap)
 
-	for i := 0; i < len(words); i += chunkSize - overlap {
-		end := i + chunkSize
-		if end > len(words) {
-			end = len(words)
-		}
-
-		content := strings.Join(words[i:end], " ")
-		if strings.TrimSpace(content) == "" {
-			continue
-		}
+	embedded, err := l.reuseOrEmbed(ctx, types.ChunkText, contents, prev)
+	if err != nil {
+		return err
+	}
 
-		embedding, err := l.embedder.Embed(content)
-		if err != nil {
-			log.Printf("embedding error: %v", err)
+	for i, content := range contents {
+		// Чанк без эмбеддинга не найдётся поиском, пропускаем его
+		if embedded[i].Embedding == nil {
 			continue
 		}
-
 		*chunks = append(*chunks, types.Chunk{
-			ID:        uuid.New(),
-			DocID:     docID,
-			Index:     *startIndex,
-			Type:      string(types.ChunkText),
-			Content:   content,
-			Embedding: embedding,
+			ID:          embedded[i].ID,
+			DocID:       docID,
+			Index:       *startIndex,
+			Type:        string(types.ChunkText),
+			Content:     content,
+			Embedding:   embedded[i].Embedding,
+			ContentHash: embedded[i].ContentHash,
 		})
-
 		*startIndex++
+	}
+	return nil
+}
 
-		if end == len(words) {
-			break
+// Абзац, чей хеш делится на anchorModulo, завершает чанк, если набрано
+// не меньше половины чанка. Граница зависит только от текста абзаца,
+// поэтому после правки чанки совпадают с прежними начиная со следующей
+// такой границы, а не сдвигаются до конца раздела.
+const anchorModulo = 3
+
+var paragraphRe = regexp.MustCompile(`\n\s*\n`)
+
+// textUnit — абзац или кусок длинного абзаца.
+type textUnit struct {
+	words   []string
+	heading bool // Абзац начинается с заголовка: перед ним всегда граница чанка
+}
+
+// splitTextChunks режет текст на чанки по границам абзацев: абзацы
+// набираются в чанк до chunkSize слов вместе с overlap последних слов
+// предыдущего чанка. Абзац длиннее чанка режется на куски от своего начала.
+func splitTextChunks(text string, chunkSize, overlap int) []string {
+	limit := max(chunkSize-overlap, 1)
+
+	var (
+		contents []string
+		cur      []string
+		tail     []string
+	)
+	flush := func() {
+		if len(cur) == 0 {
+			return
+		}
+		words := append(append([]string{}, tail...), cur...)
+		contents = append(contents, strings.Join(words, " "))
+		tail = append([]string{}, cur[max(len(cur)-overlap, 0):]...)
+		cur = nil
+	}
+
+	for _, u := range textUnits(text, limit) {
+		if u.heading || len(cur)+len(u.words) > limit {
+			flush()
+		}
+		cur = append(cur, u.words...)
+		if len(cur) >= limit/2 && isAnchor(u.words) {
+			flush()
 		}
 	}
+	flush()
+	return contents
 }
 
-func (l *PDFLoader) splitByChunks(filePath string, id uuid.UUID, chunkSize, overlap int) ([]types.Chunk, []types.FullTable, error) {
+// textUnits делит текст на абзацы по пустым строкам; абзацы длиннее limit
+// слов режутся на куски по limit слов.
+func textUnits(text string, limit int) []textUnit {
+	var units []textUnit
+	for _, para := range paragraphRe.Split(text, -1) {
+		words := strings.Fields(para)
+		heading := strings.HasPrefix(strings.TrimSpace(para), "#")
+		for len(words) > 0 {
+			n := min(len(words), limit)
+			units = append(units, textUnit{words: words[:n], heading: heading})
+			words, heading = words[n:], false
+		}
+	}
+	return units
+}
 
-	var chunks []types.Chunk
-	var tables []types.FullTable
-	pos := 0
+func isAnchor(words []string) bool {
+	h := fnv.New32a()
+	h.Write([]byte(strings.Join(words, " ")))
+	return h.Sum32()%anchorModulo == 0
+}
 
-	err := RemoveHeaderFooterCrop(filePath, filePath, 46, 57)
+// reuseOrEmbed возвращает для каждого текста id, хеш и эмбеддинг чанка:
+// найденные в prev берутся оттуда, остальные считаются одним пакетом.
+// Эмбеддинг текста, который модель не смогла обработать, равен nil.
+func (l *PDFLoader) reuseOrEmbed(ctx context.Context, kind types.ChunkType, texts []string, prev *chunkPool) ([]types.Chunk, error) {
+	out := make([]types.Chunk, len(texts))
+	var pending []string
+	var pendingIdx []int
+	for i, text := range texts {
+		hash := chunkHash(kind, text)
+		if c, ok := prev.take(hash); ok {
+			out[i] = types.Chunk{ID: c.ID, Embedding: c.Embedding, ContentHash: hash}
+			continue
+		}
+		out[i] = types.Chunk{ID: uuid.New(), ContentHash: hash}
+		pending = append(pending, text)
+		pendingIdx = append(pendingIdx, i)
+	}
+
+	embeddings, err := l.embedBatch(ctx, pending)
 	if err != nil {
-		return nil, tables, err
+		return nil, err
+	}
+	for j, i := range pendingIdx {
+		out[i].Embedding = embeddings[j]
+		prev.computed()
 	}
+	return out, nil
+}
 
-	mdFile, err := convertPDFToMD(filePath)
+func (l *PDFLoader) splitByChunks(ctx context.Context, filePath string, id uuid.UUID, chunkSize, overlap int, prev *chunkPool) ([]types.Chunk, []types.FullTable, string, error) {
+	settings := l.sourceSettings(filePath)
+	convertPath, cleanupCrop, err := convert.Crop(filePath, settings)
 	if err != nil {
-		return nil, tables, err
+		return nil, nil, "", err
 	}
+	defer cleanupCrop()
+
+	mdFile, converter, err := l.convertToMD(ctx, convertPath, settings)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	mdFile = l.cleanMarkdown(settings, mdFile)
+
+	chunks, tables, err := l.chunkMarkdown(ctx, mdFile, id, chunkSize, overlap, prev)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	return chunks, tables, converter, nil
+}
+
+// chunkMarkdown разбивает markdown на чанки (текст, изображения, строки таблиц)
+// и считает для них эмбеддинги. Недоступность моделей прерывает разбиение,
+// чтобы файл можно было обработать повторно; прочие ошибки пропускают чанк.
+// Чанки, найденные в prev по хешу содержимого, берутся оттуда без обращения
+// к моделям; prev может быть nil.
+func (l *PDFLoader) chunkMarkdown(ctx context.Context, mdFile string, id uuid.UUID, chunkSize, overlap int, prev *chunkPool) ([]types.Chunk, []types.FullTable, error) {
+	var chunks []types.Chunk
+	var tables []types.FullTable
+	pos := 0
 
 	// 1. Tokenize markdown (TEXT + IMAGE + TABLE)
 	tokens := tokenizeMD(mdFile)
@@ -390,50 +488,71 @@ func (l *PDFLoader) splitByChunks(filePath string, id uuid.UUID, chunkSize, over
 
 	// 4. Unified pass
 	for _, token := range tokens {
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
 
 		switch token.Type {
 
 		// -------- TEXT --------
 		case tokenText:
-			l.addTextChunks(
+			err := l.addTextChunks(
+				ctx,
 				&chunks,
 				token.Content,
 				id,
 				&pos,
 				chunkSize,
 				overlap,
+				prev,
 			)
+			if err != nil {
+				return nil, nil, err
+			}
 
 		// -------- IMAGE --------
 		case tokenImage:
-			ctx, cancel := context.WithTimeout(context.Background(), 300*time.Minute)
+			// Хеш считается по изображению и шаблону описания: описание
+			// vision-модели переиспользуется вместе с эмбеддингом, пока не
+			// сменится шаблон describe_image
+			hash := chunkHash(types.ChunkImage, l.describeTemplate(ctx)+"\x00"+token.Content)
+			chunk, ok := prev.take(hash)
+			if !ok {
+				jsonContent, err := l.describeImage(ctx, token.Content)
+				if isTransient(err) {
+					return nil, nil, err
+				}
+				if err != nil {
+					log.Printf("image recognition error: %v", err)
+					continue
+				}
 
-			jsonContent, err := l.converter.Retry(ctx, token.Content, 3)
-			cancel()
-			if err != nil {
-				log.Printf("image recognition error: %v", err)
-				continue
+				embedding, err := l.embed(ctx, jsonContent)
+				if isTransient(err) {
+					return nil, nil, err
+				}
+				if err != nil {
+					log.Printf("embedding image json error: %v", err)
+				}
+				prev.computed()
+				chunk = types.Chunk{ID: uuid.New(), Content: jsonContent, Embedding: embedding}
 			}
 
-			prev := sql.NullInt64{}
+			cohPrev := sql.NullInt64{}
 			if pos > 0 {
-				prev.Int64 = int64(pos - 1)
-				prev.Valid = true
-			}
-
-			embedding, err := l.embedder.Embed(jsonContent)
-			if err != nil {
-				log.Printf("embedding image json error: %v", err)
+				cohPrev.Int64 = int64(pos - 1)
+				cohPrev.Valid = true
 			}
 
 			chunks = append(chunks, types.Chunk{
-				ID:        uuid.New(),
-				DocID:     id,
-				Index:     pos,
-				Type:      string(types.ChunkImage),
-				Content:   jsonContent,
-				CohPrev:   prev,
-				Embedding: embedding,
+				ID:          chunk.ID,
+				DocID:       id,
+				Index:       pos,
+				Type:        string(types.ChunkImage),
+				Content:     chunk.Content,
+				CohPrev:     cohPrev,
+				Embedding:   chunk.Embedding,
+				ContentHash: hash,
 			})
 
 			pos++
@@ -463,26 +582,33 @@ func (l *PDFLoader) splitByChunks(filePath string, id uuid.UUID, chunkSize, over
 			}
 			tables = append(tables, fullTable)
 
+			rowTexts := make([]string, 0, len(token.Table))
 			for _, row := range token.Table {
-
 				var b strings.Builder
 				b.WriteString("Параметр: ")
 				b.WriteString(row.Key)
 				b.WriteString(". Описание: ")
 				b.WriteString(row.Value)
 				b.WriteString(".")
+				rowTexts = append(rowTexts, b.String())
+			}
 
-				text := b.String()
-				emb, _ := l.embedder.Embed(text)
+			embedded, err := l.reuseOrEmbed(ctx, types.ChunkTableRow, rowTexts, prev)
+			if err != nil {
+				return nil, nil, err
+			}
 
-				prev := sql.NullInt64{}
+			for i, row := range token.Table {
+				chunk := embedded[i]
+
+				cohPrev := sql.NullInt64{}
 				if pos > 0 {
-					prev.Int64 = int64(pos - 1)
-					prev.Valid = true
+					cohPrev.Int64 = int64(pos - 1)
+					cohPrev.Valid = true
 				}
 
 				chunks = append(chunks, types.Chunk{
-					ID:    uuid.New(),
+					ID:    chunk.ID,
 					DocID: id,
 					Index: pos,
 					Type:  string(types.ChunkTableRow),
@@ -491,9 +617,10 @@ func (l *PDFLoader) splitByChunks(filePath string, id uuid.UUID, chunkSize, over
 						UUID:  tableID,
 						Valid: true,
 					},
-					Content:   row.Value,
-					CohPrev:   prev,
-					Embedding: emb,
+					Content:     row.Value,
+					CohPrev:     cohPrev,
+					Embedding:   chunk.Embedding,
+					ContentHash: chunk.ContentHash,
 				})
 
 				pos++
@@ -501,73 +628,88 @@ func (l *PDFLoader) splitByChunks(filePath string, id uuid.UUID, chunkSize, over
 		}
 	}
 
+	if prev != nil {
+		fmt.Printf("Document %s: %d chunks reused, %d recomputed\n", id, prev.stats.Reused, prev.stats.Computed)
+		countChunks(ctx, prev.stats)
+	}
 	return chunks, tables, nil
 }
 
-func convertPDFToMD(filePath string) (string, error) {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return "", err
+// embed считает эмбеддинг с учётом ограничения параллельности.
+func (l *PDFLoader) embed(ctx context.Context, text string) ([]float32, error) {
+	if err := l.embedLimit.acquire(ctx); err != nil {
+		return nil, err
 	}
-	defer file.Close()
-
-	var buf bytes.Buffer
-	writer := multipart.NewWriter(&buf)
+	defer l.embedLimit.release()
+	setStage(ctx, types.JobStageEmbedding)
+	defer trackStage(ctx, "embed", time.Now())
+	return l.embedder.Embed(text)
+}
 
-	part, err := writer.CreateFormFile("files", filePath)
-	if err != nil {
-		return "", err
+// embedBatch считает эмбеддинги пакетом с учётом ограничения параллельности:
+// embedder отправляет пакеты по очереди, поэтому слот — один запрос к модели.
+// Если модель отклонила пакет не из-за недоступности, тексты считаются
+// по одному, чтобы потерять только проблемные: для них возвращается nil.
+func (l *PDFLoader) embedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	if len(texts) == 0 {
+		return nil, nil
 	}
 
-	_, err = io.Copy(part, file)
-	if err != nil {
-		return "", err
+	if err := l.embedLimit.acquire(ctx); err != nil {
+		return nil, err
 	}
-
-	writer.Close()
-
-	req, err := http.NewRequest("POST", "http://localhost:5001/v1/convert/file", &buf)
-	if err != nil {
-		return "", err
+	setStage(ctx, types.JobStageEmbedding)
+	start := time.Now()
+	embeddings, err := l.embedder.EmbedBatch(texts)
+	trackStage(ctx, "embed", start)
+	l.embedLimit.release()
+	if err == nil || isTransient(err) {
+		return embeddings, err
 	}
 
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", err
+	log.Printf("batch embedding error, embedding texts one by one: %v", err)
+	embeddings = make([][]float32, len(texts))
+	for i, text := range texts {
+		emb, err := l.embed(ctx, text)
+		if isTransient(err) {
+			return nil, err
+		}
+		if err != nil {
+			log.Printf("embedding error: %v", err)
+			continue
+		}
+		embeddings[i] = emb
 	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
+	return embeddings, nil
+}
 
-	var d types.DoclingResponse
-	err = json.Unmarshal(body, &d)
-	if err != nil {
+// describeImage распознаёт изображение vision-моделью с учётом ограничения параллельности.
+func (l *PDFLoader) describeImage(ctx context.Context, img string) (string, error) {
+	if err := l.visionLimit.acquire(ctx); err != nil {
 		return "", err
 	}
+	defer l.visionLimit.release()
+	setStage(ctx, types.JobStageDescribing)
+	defer trackStage(ctx, "vision", time.Now())
 
-	return d.Document.MdContent, nil
+	ctx, cancel := context.WithTimeout(ctx, 300*time.Minute)
+	defer cancel()
+	return l.converter.Retry(ctx, img, 3)
 }
 
-// var footerPageRe = regexp.MustCompile(
-// 	`(?m)^Система управления терминалами «XTMS»\s*\nстр\. \d+ из \d+\s*$`,
-// )
-
-// var headerRevisionRe = regexp.MustCompile(
-// 	`(?m)^Редакция от:\s*\d{2}\.\d{2}\.\d{4}\s+\d{2}:\d{2}\s*$`,
-// )
-
-// func cleanDoclingArtifacts(md string) string {
-// 	md = headerRevisionRe.ReplaceAllString(md, "")
-// 	md = footerPageRe.ReplaceAllString(md, "")
-
-// 	// подчистим лишние пустые строки
-// 	md = regexp.MustCompile(`\n{3,}`).ReplaceAllString(md, "\n\n")
+// convertToMD конвертирует файл в markdown конвертером, заданным для источника.
+// Если Docling недоступен, PDF конвертируется средствами pdfcpu.
+// Возвращает markdown и имя использованного конвертера.
+func (l *PDFLoader) convertToMD(ctx context.Context, filePath string, settings types.SourceSettings) (string, string, error) {
+	if err := l.convertLimit.acquire(ctx); err != nil {
+		return "", "", err
+	}
+	defer l.convertLimit.release()
+	setStage(ctx, types.JobStageConverting)
+	defer trackStage(ctx, "convert", time.Now())
 
-// 	return strings.TrimSpace(md)
-// }
+	return convert.ToMarkdown(ctx, l.docling, filePath, settings)
+}
 
 type mdTokenType int
 
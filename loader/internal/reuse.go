@@ -0,0 +1,109 @@
+package internal
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"rag/prompt"
+	"rag/types"
+
+	"github.com/google/uuid"
+)
+
+// chunkPool — чанки предыдущей версии документа, сгруппированные по хешу
+// содержимого. Неизменившийся чанк берётся из пула вместе с эмбеддингом
+// (и описанием изображения), поэтому модели вызываются только для новых
+// и изменённых чанков.
+type chunkPool struct {
+	byHash map[string][]types.Chunk
+	// Документы, чьи чанки можно забрать вместе с id: сам документ
+	// и предыдущая версия, на которую больше не указывают другие пути
+	movable map[uuid.UUID]bool
+	stats   types.ChunkStats
+}
+
+// previousChunks собирает пул из чанков документа id и документа, на который
+// сейчас указывает логический путь файла (предыдущая версия содержимого).
+// Ошибки БД не прерывают загрузку: чанки просто будут посчитаны заново.
+func (l *PDFLoader) previousChunks(ctx context.Context, id uuid.UUID, logicalPath string) *chunkPool {
+	pool := &chunkPool{
+		byHash:  make(map[string][]types.Chunk),
+		movable: map[uuid.UUID]bool{id: true},
+	}
+
+	ids := []uuid.UUID{id}
+	if logicalPath != "" {
+		if prev, err := l.store.GetDocumentPath(ctx, logicalPath); err == nil && prev != id {
+			ids = append(ids, prev)
+			// Копия файла под другим путём продолжает ссылаться на prev,
+			// забирать у неё чанки нельзя — переиспользуем только эмбеддинги
+			if n, err := l.store.CountDocumentPaths(ctx, prev); err == nil && n <= 1 {
+				pool.movable[prev] = true
+			}
+		}
+	}
+
+	for _, docID := range ids {
+		chunks, err := l.store.ListChunksByDocID(ctx, docID)
+		if err != nil {
+			fmt.Printf("error loading chunks of document %s: %s\n", docID, err)
+			continue
+		}
+		for _, c := range chunks {
+			if c.ContentHash == "" || len(c.Embedding) == 0 {
+				continue
+			}
+			pool.byHash[c.ContentHash] = append(pool.byHash[c.ContentHash], c)
+		}
+	}
+	return pool
+}
+
+// take возвращает ещё не использованный чанк с таким хешем. Чанк чужого
+// документа получает новый id. nil-пул ничего не возвращает.
+func (p *chunkPool) take(hash string) (types.Chunk, bool) {
+	if p == nil {
+		return types.Chunk{}, false
+	}
+	chunks := p.byHash[hash]
+	if len(chunks) == 0 {
+		return types.Chunk{}, false
+	}
+	c := chunks[0]
+	p.byHash[hash] = chunks[1:]
+	if !p.movable[c.DocID] {
+		c.ID = uuid.New()
+	}
+	p.stats.Reused++
+	return c, true
+}
+
+// computed учитывает чанк, посчитанный заново.
+func (p *chunkPool) computed() {
+	if p != nil {
+		p.stats.Computed++
+	}
+}
+
+// describeTemplate возвращает действующую версию и текст шаблона описания
+// изображений для хеша чанка: после правки шаблона изображения описываются
+// заново. Текст нужен, чтобы учесть и смену встроенного шаблона (версия 0).
+func (l *PDFLoader) describeTemplate(ctx context.Context) string {
+	tmpl, err := l.prompts.Template(ctx, prompt.DescribeImage, 0)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%s v%d\x00%s", tmpl.Name, tmpl.Version, tmpl.Body)
+}
+
+// chunkHash — хеш исходных данных чанка: текста, строки таблицы или
+// base64 изображения. Тип входит в хеш, чтобы одинаковый текст разных
+// типов не смешивался.
+func chunkHash(kind types.ChunkType, input string) string {
+	h := sha256.New()
+	h.Write([]byte(kind))
+	h.Write([]byte{0})
+	h.Write([]byte(input))
+	return hex.EncodeToString(h.Sum(nil))
+}
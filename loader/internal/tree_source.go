@@ -0,0 +1,292 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"rag/types"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	SourceGit  = "git"
+	SourceTree = "tree"
+)
+
+// TreeFile — файл дерева каталогов, подходящий под шаблоны источника.
+type TreeFile struct {
+	Path    string    // Абсолютный путь к файлу
+	RelPath string    // Путь относительно корня дерева
+	Commit  string    // Последний коммит, изменивший файл (только для git)
+	ModTime time.Time // Время коммита или mtime файла
+	Hash    string    // sha256 содержимого
+}
+
+// TreeSource обходит каталог рекурсивно (в отличие от WatchFile) и,
+// если каталог является git-репозиторием, берёт версию файлов из git.
+type TreeSource struct {
+	cfg    types.TreeConfig
+	loader *PDFLoader
+	globs  []*regexp.Regexp
+}
+
+func NewTreeSource(cfg types.TreeConfig, loader *PDFLoader) *TreeSource {
+	globs := make([]*regexp.Regexp, 0, len(cfg.Globs))
+	for _, g := range cfg.Globs {
+		globs = append(globs, globToRegexp(g))
+	}
+	return &TreeSource{
+		cfg:    cfg,
+		loader: loader,
+		globs:  globs,
+	}
+}
+
+// Root возвращает идентификатор источника, которым начинается SourcePath его документов.
+func (t *TreeSource) Root() string {
+	if t.cfg.GitURL != "" {
+		return t.cfg.GitURL
+	}
+	return t.cfg.Dir
+}
+
+// Interval возвращает период повторного обхода.
+func (t *TreeSource) Interval() time.Duration {
+	return t.cfg.Interval
+}
+
+// Source возвращает тип источника для documents.source.
+func (t *TreeSource) Source() string {
+	if t.isGit() {
+		return SourceGit
+	}
+	return SourceTree
+}
+
+func (t *TreeSource) isGit() bool {
+	_, err := os.Stat(filepath.Join(t.cfg.Dir, ".git"))
+	return err == nil
+}
+
+// Sync клонирует репозиторий или подтягивает изменения.
+// Для обычного каталога ничего не делает.
+func (t *TreeSource) Sync(ctx context.Context) error {
+	if t.cfg.GitURL == "" {
+		return nil
+	}
+
+	if !t.isGit() {
+		fmt.Printf("Cloning %s into %s\n", t.cfg.GitURL, t.cfg.Dir)
+		args := []string{"clone"}
+		if t.cfg.GitBranch != "" {
+			args = append(args, "--branch", t.cfg.GitBranch)
+		}
+		args = append(args, t.cfg.GitURL, t.cfg.Dir)
+		_, err := runGit(ctx, "", args...)
+		return err
+	}
+
+	_, err := runGit(ctx, t.cfg.Dir, "pull", "--ff-only")
+	return err
+}
+
+// List возвращает файлы дерева, подходящие под шаблоны.
+func (t *TreeSource) List(ctx context.Context) ([]TreeFile, error) {
+	git := t.isGit()
+	var files []TreeFile
+
+	err := filepath.WalkDir(t.cfg.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		rel, err := filepath.Rel(t.cfg.Dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if !t.match(rel) {
+			return nil
+		}
+
+		file := TreeFile{Path: path, RelPath: rel}
+		if git {
+			file.Commit, file.ModTime, err = lastCommit(ctx, t.cfg.Dir, rel)
+			if err != nil {
+				return err
+			}
+			// Неотслеживаемые файлы пропускаем
+			if file.Commit == "" {
+				return nil
+			}
+		} else {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			file.ModTime = info.ModTime()
+		}
+
+		if file.Hash, err = hashFile(path); err != nil {
+			return err
+		}
+		files = append(files, file)
+		return nil
+	})
+
+	return files, err
+}
+
+// DocumentID возвращает идентификатор документа по содержимому файла:
+// переименованный файл остаётся тем же документом.
+func (t *TreeSource) DocumentID(f TreeFile) uuid.UUID {
+	return types.DocumentIDFromHash(f.Hash)
+}
+
+// LogicalPath возвращает путь файла, не зависящий от коммита; новая версия
+// файла заменяет документ, на который указывал этот путь.
+func (t *TreeSource) LogicalPath(f TreeFile) string {
+	return t.Root() + ":" + f.RelPath
+}
+
+// SourcePath формирует provenance документа: <repo>@<commit>:<path> для git
+// и путь к файлу для обычного каталога.
+func (t *TreeSource) SourcePath(f TreeFile) string {
+	if f.Commit != "" {
+		return fmt.Sprintf("%s@%s:%s", t.Root(), f.Commit, f.RelPath)
+	}
+	return filepath.Join(t.Root(), f.RelPath)
+}
+
+// Load конвертирует файл в markdown и разбивает на чанки.
+func (t *TreeSource) Load(ctx context.Context, f TreeFile) (*types.Document, error) {
+	settings := t.loader.sourceSettings(f.Path)
+
+	var md, converter string
+	switch strings.ToLower(filepath.Ext(f.Path)) {
+	case ".md", ".markdown", ".txt":
+		data, err := os.ReadFile(f.Path)
+		if err != nil {
+			return nil, err
+		}
+		md, converter = string(data), types.ConverterMarkdown
+	default:
+		// Остальные форматы конвертируем без обрезки полей,
+		// чтобы не менять файлы рабочей копии
+		var err error
+		md, converter, err = t.loader.convertToMD(ctx, f.Path, settings)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	md = t.loader.cleanMarkdown(settings, md)
+
+	id := t.DocumentID(f)
+	prev := t.loader.previousChunks(ctx, id, t.LogicalPath(f))
+	chunks, tables, err := t.loader.chunkMarkdown(ctx, md, id, t.loader.cfg.ChunkSize, t.loader.cfg.ChunkOverlap, prev)
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.Document{
+		ID:          id,
+		Title:       generateTitle(f.Path),
+		Chunks:      chunks,
+		FullTable:   tables,
+		Source:      t.Source(),
+		SourcePath:  t.SourcePath(f),
+		CreatedAt:   f.ModTime,
+		UpdatedAt:   f.ModTime,
+		Version:     1,
+		Converter:   converter,
+		ContentHash: f.Hash,
+		LogicalPath: t.LogicalPath(f),
+	}, nil
+}
+
+func (t *TreeSource) match(rel string) bool {
+	if len(t.globs) == 0 {
+		return true
+	}
+	for _, re := range t.globs {
+		if re.MatchString(rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// globToRegexp переводит glob в регулярное выражение.
+// ** совпадает с любым количеством каталогов, * и ? — в пределах одного.
+func globToRegexp(glob string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				i++
+				// **/ допускает и ноль каталогов
+				if i+1 < len(glob) && glob[i+1] == '/' {
+					i++
+					b.WriteString("(?:.*/)?")
+				} else {
+					b.WriteString(".*")
+				}
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+func lastCommit(ctx context.Context, dir, rel string) (string, time.Time, error) {
+	out, err := runGit(ctx, dir, "log", "-1", "--format=%H %ct", "--", rel)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	fields := strings.Fields(out)
+	if len(fields) != 2 {
+		return "", time.Time{}, nil
+	}
+	sec, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return fields[0], time.Unix(sec, 0), nil
+}
+
+func runGit(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
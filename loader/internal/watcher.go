@@ -0,0 +1,355 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"rag/types"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errNotifyUnsupported возвращается, если уведомления файловой системы недоступны.
+var errNotifyUnsupported = errors.New("file system notifications are not supported")
+
+// notifier сообщает пути файлов и каталогов, изменившихся в дереве.
+// Канал закрывается, если уведомления перестали работать.
+type notifier interface {
+	Events() <-chan string
+	Close() error
+}
+
+// WatchFile следит за каталогом-источником и его подкаталогами и ставит
+// в очередь заданий файлы, размер и mtime которых не менялись дольше
+// MonitoringTime. Изменения приходят от inotify, а если он недоступен —
+// из периодического сканирования.
+func (l *PDFLoader) WatchFile(ctx context.Context) {
+	fmt.Printf("Start monitoring folder: %s\n", l.cfg.SourceDir)
+	defer fmt.Println("File watcher stopped and cleaned up")
+
+	var events <-chan string
+	rescan := l.cfg.Watch.Rescan
+	n, err := newNotifier(l.cfg.SourceDir, l.skipDir)
+	if err != nil {
+		fmt.Printf("inotify is unavailable (%v), falling back to polling every %v\n", err, l.cfg.Watch.PollInterval)
+		rescan = l.cfg.Watch.PollInterval
+	} else {
+		defer n.Close()
+		events = n.Events()
+	}
+
+	l.scanSource()
+
+	scanTicker := time.NewTicker(rescan)
+	defer scanTicker.Stop()
+	checkTicker := time.NewTicker(time.Second)
+	defer checkTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Println("Stopping file watcher (context cancelled)...")
+			return
+		case path, ok := <-events:
+			if !ok {
+				fmt.Printf("inotify stopped, falling back to polling every %v\n", l.cfg.Watch.PollInterval)
+				events = nil
+				scanTicker.Reset(l.cfg.Watch.PollInterval)
+				continue
+			}
+			l.observePath(path)
+		case <-scanTicker.C:
+			l.scanSource()
+		case <-checkTicker.C:
+			l.enqueueStable(ctx)
+		}
+	}
+}
+
+// scanSource обходит дерево источника целиком и обновляет отслеживаемые файлы.
+func (l *PDFLoader) scanSource() {
+	current := make(map[string]bool)
+	err := filepath.WalkDir(l.cfg.SourceDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Файл мог исчезнуть во время обхода
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			if path != l.cfg.SourceDir && l.skipDir(path) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || !l.watched(path) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		current[path] = true
+		if l.files.observe(path, info) {
+			fmt.Printf("New file detected: %s\n", path)
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("error while reading source directory: %s\n", err)
+		return
+	}
+
+	// Удаляем из отслеживания файлы, которых больше нет в директории
+	for _, path := range l.files.prune(current) {
+		fmt.Printf("The file has been removed from tracking: %s\n", path)
+	}
+}
+
+// observePath обрабатывает уведомление об изменении пути.
+func (l *PDFLoader) observePath(path string) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		// Файл или каталог удалён либо перемещён
+		for _, p := range l.files.remove(path) {
+			fmt.Printf("The file has been removed from tracking: %s\n", p)
+		}
+		return
+	}
+
+	if info.IsDir() {
+		// Каталог мог появиться вместе с содержимым
+		if path == l.cfg.SourceDir || !l.skipDir(path) {
+			l.scanDir(path)
+		}
+		return
+	}
+	if info.Mode().IsRegular() && l.watched(path) {
+		if l.files.observe(path, info) {
+			fmt.Printf("New file detected: %s\n", path)
+		}
+	}
+}
+
+func (l *PDFLoader) scanDir(dir string) {
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if path != dir && l.skipDir(path) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || !l.watched(path) {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && l.files.observe(path, info) {
+			fmt.Printf("New file detected: %s\n", path)
+		}
+		return nil
+	})
+}
+
+// enqueueStable ставит в очередь файлы, которые перестали меняться.
+func (l *PDFLoader) enqueueStable(ctx context.Context) {
+	for _, path := range l.files.stable(l.cfg.MonitoringTime) {
+		info, err := os.Stat(path)
+		if err != nil {
+			l.files.remove(path)
+			continue
+		}
+		// Запись могла продолжиться без уведомления
+		if !l.files.markQueued(path, info) {
+			continue
+		}
+
+		fmt.Printf("The file %s has not been modified for more than %v seconds. Enqueue...\n", path, l.cfg.MonitoringTime.Seconds())
+
+		job, err := l.store.EnqueueJob(ctx, path, SourcePDF)
+		if err != nil {
+			fmt.Printf("error enqueueing file %s: %s\n", path, err)
+			// Попробуем снова на следующей проверке
+			l.files.release(path)
+			continue
+		}
+		fmt.Printf("File %s queued as job %s\n", path, job.ID)
+	}
+}
+
+// skipDir сообщает, нужно ли пропустить каталог: архив и каталог ошибок
+// могут находиться внутри источника.
+func (l *PDFLoader) skipDir(dir string) bool {
+	dir = filepath.Clean(dir)
+	return dir == filepath.Clean(l.cfg.ArchiveDir) || dir == filepath.Clean(l.cfg.BadDir)
+}
+
+// watched проверяет путь файла по шаблонам include/exclude.
+func (l *PDFLoader) watched(path string) bool {
+	rel, err := filepath.Rel(l.cfg.SourceDir, path)
+	if err != nil {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+
+	for _, re := range l.exclude {
+		if re.MatchString(rel) {
+			return false
+		}
+	}
+	if len(l.include) == 0 {
+		return true
+	}
+	for _, re := range l.include {
+		if re.MatchString(rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// pathLabels возвращает коллекцию и теги документа по подкаталогам,
+// в которых лежит файл, относительно каталога-источника.
+func (l *PDFLoader) pathLabels(filePath string) (string, []string) {
+	rel, err := filepath.Rel(l.cfg.SourceDir, filepath.Dir(filePath))
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", nil
+	}
+	dirs := strings.Split(filepath.ToSlash(rel), "/")
+
+	switch l.cfg.Watch.SubdirMode {
+	case types.SubdirCollection:
+		return dirs[0], dirs[1:]
+	case types.SubdirTags:
+		return "", dirs
+	}
+	return "", nil
+}
+
+func compileGlobs(globs []string) []*regexp.Regexp {
+	res := make([]*regexp.Regexp, 0, len(globs))
+	for _, g := range globs {
+		res = append(res, globToRegexp(g))
+	}
+	return res
+}
+
+// fileState — последний увиденный размер и mtime файла.
+type fileState struct {
+	size      int64
+	modTime   time.Time
+	changedAt time.Time // Когда размер или mtime изменились в последний раз
+	queued    bool
+}
+
+// fileTracker хранит состояние файлов каталога-источника.
+// Блокировка берётся только на время обращения к карте, а не на время обработки.
+type fileTracker struct {
+	mu    sync.Mutex
+	files map[string]*fileState
+}
+
+func newFileTracker() *fileTracker {
+	return &fileTracker{
+		files: make(map[string]*fileState),
+	}
+}
+
+// observe запоминает размер и mtime файла. Если они изменились, отсчёт
+// стабильности начинается заново. Возвращает true для нового файла.
+func (t *fileTracker) observe(path string, info fs.FileInfo) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	st, exists := t.files[path]
+	if !exists {
+		t.files[path] = &fileState{size: info.Size(), modTime: info.ModTime(), changedAt: time.Now()}
+		return true
+	}
+	if st.size != info.Size() || !st.modTime.Equal(info.ModTime()) {
+		st.size, st.modTime, st.changedAt = info.Size(), info.ModTime(), time.Now()
+		// Изменённый после постановки в очередь файл будет поставлен снова
+		st.queued = false
+	}
+	return false
+}
+
+// stable возвращает файлы, не поставленные в очередь и не менявшиеся дольше d.
+func (t *fileTracker) stable(d time.Duration) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var paths []string
+	for path, st := range t.files {
+		if !st.queued && time.Since(st.changedAt) > d {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// markQueued отмечает файл поставленным в очередь, если его размер и mtime
+// совпадают с запомненными; иначе обновляет их и возвращает false.
+func (t *fileTracker) markQueued(path string, info fs.FileInfo) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	st, exists := t.files[path]
+	if !exists {
+		return false
+	}
+	if st.size != info.Size() || !st.modTime.Equal(info.ModTime()) {
+		st.size, st.modTime, st.changedAt = info.Size(), info.ModTime(), time.Now()
+		return false
+	}
+	st.queued = true
+	return true
+}
+
+// release снимает отметку очереди: файл будет поставлен повторно
+// при следующей проверке.
+func (t *fileTracker) release(path string) {
+	t.mu.Lock()
+	if st, ok := t.files[path]; ok {
+		st.queued = false
+	}
+	t.mu.Unlock()
+}
+
+// remove снимает с отслеживания файл или все файлы удалённого каталога.
+func (t *fileTracker) remove(path string) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	prefix := path + string(filepath.Separator)
+	var removed []string
+	for p := range t.files {
+		if p == path || strings.HasPrefix(p, prefix) {
+			delete(t.files, p)
+			removed = append(removed, p)
+		}
+	}
+	return removed
+}
+
+// prune удаляет из отслеживания файлы, которых больше нет в каталоге.
+func (t *fileTracker) prune(current map[string]bool) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var removed []string
+	for path := range t.files {
+		if !current[path] {
+			delete(t.files, path)
+			removed = append(removed, path)
+		}
+	}
+	return removed
+}
@@ -0,0 +1,179 @@
+//go:build linux
+
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const inotifyMask = unix.IN_CREATE | unix.IN_MODIFY | unix.IN_CLOSE_WRITE |
+	unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_DELETE | unix.IN_DELETE_SELF
+
+// inotifyNotifier следит за деревом каталогов через inotify. Inotify не
+// рекурсивен, поэтому watch добавляется на каждый подкаталог, в том числе
+// на созданные после запуска.
+type inotifyNotifier struct {
+	file   *os.File
+	fd     int
+	skip   func(string) bool
+	mu     sync.Mutex
+	watch  map[int]string // wd -> каталог
+	events chan string
+	done   chan struct{}
+	root   string
+}
+
+func newNotifier(root string, skip func(string) bool) (notifier, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+
+	n := &inotifyNotifier{
+		// Неблокирующий дескриптор читается через poller рантайма,
+		// поэтому Close прерывает ожидающий Read
+		file:   os.NewFile(uintptr(fd), "inotify"),
+		fd:     fd,
+		skip:   skip,
+		watch:  make(map[int]string),
+		events: make(chan string, 1024),
+		done:   make(chan struct{}),
+		root:   root,
+	}
+	if err := n.addTree(root); err != nil {
+		n.file.Close()
+		return nil, err
+	}
+
+	go n.run()
+	return n, nil
+}
+
+func (n *inotifyNotifier) Events() <-chan string {
+	return n.events
+}
+
+func (n *inotifyNotifier) Close() error {
+	close(n.done)
+	return n.file.Close()
+}
+
+// addTree добавляет watch на каталог и все его подкаталоги.
+func (n *inotifyNotifier) addTree(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != n.root && n.skip(path) {
+			return filepath.SkipDir
+		}
+
+		wd, err := unix.InotifyAddWatch(n.fd, path, inotifyMask)
+		if err != nil {
+			return fmt.Errorf("inotify watch %s: %w", path, err)
+		}
+		n.mu.Lock()
+		n.watch[wd] = path
+		n.mu.Unlock()
+		return nil
+	})
+}
+
+// removeTree снимает watch с каталога и всех его подкаталогов.
+func (n *inotifyNotifier) removeTree(dir string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for wd, path := range n.watch {
+		if path != dir && !strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			continue
+		}
+		// IN_IGNORED после снятия придёт для уже неизвестного wd и будет пропущен
+		if _, err := unix.InotifyRmWatch(n.fd, uint32(wd)); err != nil {
+			fmt.Printf("error removing watch %s: %s\n", path, err)
+		}
+		delete(n.watch, wd)
+	}
+}
+
+func (n *inotifyNotifier) run() {
+	defer close(n.events)
+
+	buf := make([]byte, 64*1024)
+	for {
+		count, err := n.file.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				fmt.Printf("inotify read error: %s\n", err)
+			}
+			return
+		}
+
+		for offset := 0; offset+unix.SizeofInotifyEvent <= count; {
+			ev := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+unix.SizeofInotifyEvent : offset+unix.SizeofInotifyEvent+int(ev.Len)]
+			offset += unix.SizeofInotifyEvent + int(ev.Len)
+
+			n.handle(ev, string(bytes.TrimRight(nameBytes, "\x00")))
+		}
+	}
+}
+
+func (n *inotifyNotifier) handle(ev *unix.InotifyEvent, name string) {
+	// Очередь событий переполнена — просим пересканировать всё дерево
+	if ev.Mask&unix.IN_Q_OVERFLOW != 0 {
+		n.send(n.root)
+		return
+	}
+
+	n.mu.Lock()
+	dir, ok := n.watch[int(ev.Wd)]
+	if ev.Mask&unix.IN_IGNORED != 0 {
+		delete(n.watch, int(ev.Wd))
+	}
+	n.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	path := dir
+	if name != "" {
+		path = filepath.Join(dir, name)
+	}
+
+	if ev.Mask&unix.IN_ISDIR != 0 && ev.Mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0 {
+		if err := n.addTree(path); err != nil {
+			fmt.Printf("error watching new directory %s: %s\n", path, err)
+		}
+	}
+	// Перемещённый каталог ядро продолжает отслеживать по старому wd,
+	// поэтому его watch снимаем сами, как при удалении
+	if ev.Mask&unix.IN_ISDIR != 0 && ev.Mask&unix.IN_MOVED_FROM != 0 {
+		n.removeTree(path)
+	}
+
+	n.send(path)
+}
+
+// send передаёт путь, не блокируясь после Close.
+func (n *inotifyNotifier) send(path string) {
+	select {
+	case n.events <- path:
+	case <-n.done:
+	}
+}
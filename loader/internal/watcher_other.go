@@ -0,0 +1,8 @@
+//go:build !linux
+
+package internal
+
+// newNotifier без inotify недоступен, WatchFile переходит на периодическое сканирование.
+func newNotifier(root string, skip func(string) bool) (notifier, error) {
+	return nil, errNotifyUnsupported
+}
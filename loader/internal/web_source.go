@@ -0,0 +1,562 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"rag/types"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const SourceWeb = "web"
+
+// WebCrawler обходит страницы одного хоста, начиная с seed URL,
+// с ограничением по глубине и количеству страниц.
+type WebCrawler struct {
+	cfg    types.WebConfig
+	loader *PDFLoader
+	client *http.Client
+	robots map[string]*robotsRules
+}
+
+func NewWebCrawler(cfg types.WebConfig, loader *PDFLoader) *WebCrawler {
+	w := &WebCrawler{
+		cfg:    cfg,
+		loader: loader,
+		robots: make(map[string]*robotsRules),
+	}
+	w.client = &http.Client{Timeout: cfg.Timeout, CheckRedirect: w.checkRedirect}
+	return w
+}
+
+// checkRedirect не даёт редиректу увести обход на другой хост или на путь,
+// закрытый robots.txt.
+func (w *WebCrawler) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	if req.URL.Host != via[0].URL.Host {
+		return fmt.Errorf("redirect to another host: %s", req.URL)
+	}
+	if req.URL.Path != "/robots.txt" && !w.allowed(req.Context(), req.URL) {
+		return fmt.Errorf("redirect disallowed by robots.txt: %s", req.URL)
+	}
+	return nil
+}
+
+// PollInterval возвращает период проверки новых заявок на обход.
+func (w *WebCrawler) PollInterval() time.Duration {
+	return w.cfg.PollInterval
+}
+
+// Lease возвращает срок аренды заявки на обход.
+func (w *WebCrawler) Lease() time.Duration {
+	return w.cfg.Lease
+}
+
+type crawlItem struct {
+	url   *url.URL
+	depth int
+}
+
+// Crawl обходит сайт и отправляет документ для каждой загруженной страницы.
+// Возвращает количество отправленных документов.
+func (w *WebCrawler) Crawl(ctx context.Context, crawl types.Crawl, docChan chan<- *types.Document) (int, error) {
+	seed, err := url.Parse(crawl.SeedURL)
+	if err != nil {
+		return 0, err
+	}
+	if seed.Scheme != "http" && seed.Scheme != "https" {
+		return 0, fmt.Errorf("unsupported url scheme: %s", seed.Scheme)
+	}
+	seed.Fragment = ""
+
+	queue := []crawlItem{{url: seed}}
+	seen := map[string]bool{seed.String(): true}
+	pages := 0
+
+	for len(queue) > 0 && pages < crawl.MaxPages {
+		if ctx.Err() != nil {
+			return pages, ctx.Err()
+		}
+
+		item := queue[0]
+		queue = queue[1:]
+
+		if !w.allowed(ctx, item.url) {
+			fmt.Printf("[CRAWL] Disallowed by robots.txt: %s\n", item.url)
+			continue
+		}
+
+		page, err := w.fetch(ctx, item.url)
+		if err != nil {
+			fmt.Printf("[CRAWL] Error fetching %s: %v\n", item.url, err)
+			continue
+		}
+		if page == nil {
+			continue
+		}
+
+		if item.depth < crawl.MaxDepth {
+			for _, link := range page.Links {
+				if link.Host != seed.Host || seen[link.String()] {
+					continue
+				}
+				seen[link.String()] = true
+				queue = append(queue, crawlItem{url: link, depth: item.depth + 1})
+			}
+		}
+
+		if strings.TrimSpace(page.Markdown) == "" {
+			continue
+		}
+
+		doc, err := w.document(ctx, page)
+		if err != nil {
+			return pages, err
+		}
+		select {
+		case docChan <- doc:
+		case <-ctx.Done():
+			return pages, ctx.Err()
+		}
+		pages++
+		fmt.Printf("[CRAWL] Page %d/%d loaded: %s\n", pages, crawl.MaxPages, page.Canonical)
+
+		if w.cfg.Delay > 0 {
+			timer := time.NewTimer(w.cfg.Delay)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return pages, ctx.Err()
+			}
+		}
+	}
+
+	return pages, nil
+}
+
+func (w *WebCrawler) document(ctx context.Context, page *webPage) (*types.Document, error) {
+	id, _ := uuid.Parse(generateDocumentID(page.Canonical))
+	chunks, tables, err := w.loader.chunkMarkdown(ctx, page.Markdown, id, w.loader.cfg.ChunkSize, w.loader.cfg.ChunkOverlap, w.loader.previousChunks(ctx, id, ""))
+	if err != nil {
+		return nil, err
+	}
+
+	title := page.Title
+	if title == "" {
+		title = page.Canonical
+	}
+
+	return &types.Document{
+		ID:         id,
+		Title:      title,
+		Chunks:     chunks,
+		FullTable:  tables,
+		Source:     SourceWeb,
+		SourcePath: page.Canonical,
+		CreatedAt:  page.Modified,
+		UpdatedAt:  page.Modified,
+		Version:    1,
+		Converter:  types.ConverterHTML,
+	}, nil
+}
+
+type webPage struct {
+	Canonical string
+	Title     string
+	Markdown  string
+	Links     []*url.URL
+	Modified  time.Time
+}
+
+// fetch загружает страницу. Для не-HTML ответов возвращает nil.
+func (w *WebCrawler) fetch(ctx context.Context, u *url.URL) (*webPage, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", w.cfg.UserAgent)
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	if !strings.Contains(resp.Header.Get("Content-Type"), "text/html") {
+		return nil, nil
+	}
+
+	root, err := html.Parse(io.LimitReader(resp.Body, 10<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	// После редиректов базовым считается конечный URL
+	base := resp.Request.URL
+	page := &webPage{
+		Canonical: normalizeURL(base),
+		Modified:  time.Now(),
+	}
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		page.Modified = t
+	}
+
+	var body *html.Node
+	walkHTML(root, func(n *html.Node) bool {
+		switch n.DataAtom {
+		case atom.Title:
+			if page.Title == "" {
+				page.Title = strings.TrimSpace(textContent(n))
+			}
+		case atom.Link:
+			// Канонический адрес на другом хосте не принимается: обход
+			// остаётся в пределах хоста
+			if strings.EqualFold(attr(n, "rel"), "canonical") {
+				if c, err := base.Parse(attr(n, "href")); err == nil && c.Host == base.Host {
+					page.Canonical = normalizeURL(c)
+				}
+			}
+		case atom.A:
+			if link, err := base.Parse(attr(n, "href")); err == nil && (link.Scheme == "http" || link.Scheme == "https") {
+				link.Fragment = ""
+				page.Links = append(page.Links, link)
+			}
+		case atom.Body:
+			body = n
+		}
+		return true
+	})
+
+	if body != nil {
+		page.Markdown = htmlToMarkdown(contentRoot(body))
+	}
+	return page, nil
+}
+
+func normalizeURL(u *url.URL) string {
+	c := *u
+	c.Fragment = ""
+	return c.String()
+}
+
+// -------- robots.txt --------
+
+type robotsRules struct {
+	allow    []string
+	disallow []string
+}
+
+func (w *WebCrawler) allowed(ctx context.Context, u *url.URL) bool {
+	key := u.Scheme + "://" + u.Host
+	rules, ok := w.robots[key]
+	if !ok {
+		rules = w.loadRobots(ctx, key)
+		w.robots[key] = rules
+	}
+	return rules.allowed(u.EscapedPath())
+}
+
+func (w *WebCrawler) loadRobots(ctx context.Context, origin string) *robotsRules {
+	rules := &robotsRules{}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", origin+"/robots.txt", nil)
+	if err != nil {
+		return rules
+	}
+	req.Header.Set("User-Agent", w.cfg.UserAgent)
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return rules
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return rules
+	}
+
+	return parseRobots(resp.Body, w.cfg.UserAgent)
+}
+
+// parseRobots выбирает правила для нашего User-Agent, а если их нет — для "*".
+func parseRobots(r io.Reader, userAgent string) *robotsRules {
+	agent := strings.ToLower(strings.SplitN(userAgent, "/", 2)[0])
+	specific, generic := &robotsRules{}, &robotsRules{}
+	hasSpecific := false
+
+	var groups []*robotsRules
+	inAgents := false
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			if !inAgents {
+				groups = nil
+			}
+			inAgents = true
+			ua := strings.ToLower(value)
+			if ua == "*" {
+				groups = append(groups, generic)
+			} else if agent != "" && strings.Contains(agent, ua) {
+				groups = append(groups, specific)
+				hasSpecific = true
+			}
+		case "allow", "disallow":
+			inAgents = false
+			if value == "" {
+				continue
+			}
+			for _, g := range groups {
+				if key == "allow" {
+					g.allow = append(g.allow, value)
+				} else {
+					g.disallow = append(g.disallow, value)
+				}
+			}
+		default:
+			inAgents = false
+		}
+	}
+
+	if hasSpecific {
+		return specific
+	}
+	return generic
+}
+
+// allowed применяет правило с самым длинным совпадающим префиксом.
+func (r *robotsRules) allowed(path string) bool {
+	if path == "" {
+		path = "/"
+	}
+	best, allow := -1, true
+	for _, p := range r.disallow {
+		if robotsMatch(p, path) && len(p) > best {
+			best, allow = len(p), false
+		}
+	}
+	for _, p := range r.allow {
+		if robotsMatch(p, path) && len(p) >= best {
+			best, allow = len(p), true
+		}
+	}
+	return allow
+}
+
+func robotsMatch(pattern, path string) bool {
+	if !strings.ContainsAny(pattern, "*$") {
+		return strings.HasPrefix(path, pattern)
+	}
+	re := "^" + strings.ReplaceAll(regexp.QuoteMeta(strings.TrimSuffix(pattern, "$")), `\*`, ".*")
+	if strings.HasSuffix(pattern, "$") {
+		re += "$"
+	}
+	ok, _ := regexp.MatchString(re, path)
+	return ok
+}
+
+// -------- HTML → markdown --------
+
+// Классы и id блоков-обёрток навигации; сравниваются целиком, чтобы
+// не выбрасывать контент вроде class="card-header" или id="menu-options"
+var boilerplateRe = regexp.MustCompile(`(?i)^(nav|navbar|menu|sidebar|footer|header|breadcrumbs?|toc|cookie-banner|cookie|banner)$`)
+
+// contentRoot возвращает основной контент страницы (<main>/<article>), если он размечен.
+func contentRoot(body *html.Node) *html.Node {
+	var found *html.Node
+	walkHTML(body, func(n *html.Node) bool {
+		if found != nil {
+			return false
+		}
+		if n.DataAtom == atom.Main || n.DataAtom == atom.Article || attr(n, "role") == "main" {
+			found = n
+			return false
+		}
+		return true
+	})
+	if found != nil {
+		return found
+	}
+	return body
+}
+
+func isBoilerplate(n *html.Node) bool {
+	switch n.DataAtom {
+	case atom.Nav, atom.Header, atom.Footer, atom.Aside, atom.Script, atom.Style,
+		atom.Noscript, atom.Form, atom.Button, atom.Iframe, atom.Svg, atom.Template:
+		return true
+	}
+	switch attr(n, "role") {
+	case "navigation", "banner", "contentinfo", "search", "complementary":
+		return true
+	}
+	// По классу и id отбрасываются только блоки-контейнеры, а не абзацы,
+	// списки и таблицы внутри текста
+	switch n.DataAtom {
+	case atom.Div, atom.Section:
+	default:
+		return false
+	}
+	for _, name := range append(strings.Fields(attr(n, "class")), attr(n, "id")) {
+		if boilerplateRe.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func htmlToMarkdown(root *html.Node) string {
+	var b strings.Builder
+	for c := root.FirstChild; c != nil; c = c.NextSibling {
+		writeMarkdown(&b, c)
+	}
+	md := regexp.MustCompile(`\n{3,}`).ReplaceAllString(b.String(), "\n\n")
+	return strings.TrimSpace(md)
+}
+
+func writeMarkdown(b *strings.Builder, n *html.Node) {
+	if n.Type == html.TextNode {
+		text := strings.Join(strings.Fields(n.Data), " ")
+		if text == "" {
+			if n.Data != "" {
+				b.WriteString(" ")
+			}
+			return
+		}
+		if strings.TrimLeftFunc(n.Data, unicode.IsSpace) != n.Data {
+			b.WriteString(" ")
+		}
+		b.WriteString(text)
+		if strings.TrimRightFunc(n.Data, unicode.IsSpace) != n.Data {
+			b.WriteString(" ")
+		}
+		return
+	}
+	if n.Type == html.ElementNode && isBoilerplate(n) {
+		return
+	}
+
+	switch n.DataAtom {
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		level := int(n.Data[1] - '0')
+		b.WriteString("\n\n" + strings.Repeat("#", level) + " ")
+		b.WriteString(strings.TrimSpace(textContent(n)))
+		b.WriteString("\n\n")
+		return
+	case atom.Table:
+		b.WriteString("\n\n")
+		writeTable(b, n)
+		b.WriteString("\n")
+		return
+	case atom.Pre:
+		b.WriteString("\n\n```\n")
+		b.WriteString(strings.TrimRight(textContent(n), "\n"))
+		b.WriteString("\n```\n\n")
+		return
+	case atom.Br:
+		b.WriteString("\n")
+		return
+	case atom.Li:
+		b.WriteString("\n- ")
+	case atom.P, atom.Div, atom.Section, atom.Ul, atom.Ol, atom.Blockquote, atom.Dl:
+		b.WriteString("\n\n")
+	case atom.Dt, atom.Dd, atom.Tr:
+		b.WriteString("\n")
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		writeMarkdown(b, c)
+	}
+
+	switch n.DataAtom {
+	case atom.P, atom.Div, atom.Section, atom.Ul, atom.Ol, atom.Blockquote, atom.Dl:
+		b.WriteString("\n\n")
+	}
+}
+
+// writeTable выводит таблицу в markdown с разделителем после первой строки,
+// чтобы tokenizeMD распознал её как таблицу.
+func writeTable(b *strings.Builder, table *html.Node) {
+	var rows [][]string
+	walkHTML(table, func(n *html.Node) bool {
+		if n.DataAtom != atom.Tr {
+			return true
+		}
+		var cells []string
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.DataAtom == atom.Td || c.DataAtom == atom.Th {
+				cell := strings.Join(strings.Fields(textContent(c)), " ")
+				cells = append(cells, strings.ReplaceAll(cell, "|", "/"))
+			}
+		}
+		if len(cells) > 0 {
+			rows = append(rows, cells)
+		}
+		return false
+	})
+
+	for i, row := range rows {
+		b.WriteString("| " + strings.Join(row, " | ") + " |\n")
+		if i == 0 {
+			b.WriteString("|" + strings.Repeat("---|", len(row)) + "\n")
+		}
+	}
+}
+
+// walkHTML обходит дерево в глубину; fn возвращает false, чтобы не спускаться в потомков.
+func walkHTML(n *html.Node, fn func(*html.Node) bool) {
+	if n.Type == html.ElementNode && !fn(n) {
+		return
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		walkHTML(c, fn)
+	}
+}
+
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		b.WriteString(textContent(c))
+	}
+	return b.String()
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
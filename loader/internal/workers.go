@@ -0,0 +1,30 @@
+package internal
+
+import (
+	"context"
+)
+
+// limiter ограничивает число одновременных вызовов одного этапа обработки
+// (конвертация, vision-модель, эмбеддинги), общее для всех воркеров.
+type limiter chan struct{}
+
+func newLimiter(n int) limiter {
+	if n <= 0 {
+		n = 1
+	}
+	return make(limiter, n)
+}
+
+// acquire ждёт свободный слот или отмены контекста.
+func (s limiter) acquire(ctx context.Context) error {
+	select {
+	case s <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s limiter) release() {
+	<-s
+}
@@ -2,13 +2,17 @@ package service
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"log/slog"
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 
+	"rag/loader/convert"
 	"rag/loader/internal"
 	"rag/store"
 	"rag/types"
@@ -23,6 +27,8 @@ type Service struct {
 	logger *slog.Logger
 	store  store.DBStorer
 	loader *internal.PDFLoader
+	tree   *internal.TreeSource
+	web    *internal.WebCrawler
 }
 
 func NewConfig() types.Config {
@@ -42,15 +48,149 @@ func NewConfig() types.Config {
 		BadDir:         os.Getenv("LOADER_BAD_DIR"),
 		ChunkSize:      chunkSize,
 		ChunkOverlap:   chunkOverlap,
+		Tree:           newTreeConfig(),
+		Web:            newWebConfig(),
+		Docling:        convert.NewDoclingConfig(),
+		Workers:        newWorkersConfig(),
+		Jobs:           newJobsConfig(),
+		Watch:          newWatchConfig(),
+	}
+}
+
+func newWatchConfig() types.WatchConfig {
+	splitGlobs := func(key string) []string {
+		var globs []string
+		for _, g := range strings.Split(os.Getenv(key), ",") {
+			if g = strings.TrimSpace(g); g != "" {
+				globs = append(globs, g)
+			}
+		}
+		return globs
+	}
+
+	mode := os.Getenv("LOADER_SUBDIR_MODE")
+	switch mode {
+	case types.SubdirCollection, types.SubdirTags, types.SubdirNone:
+	default:
+		mode = types.SubdirCollection
+	}
+
+	pollInterval, err := time.ParseDuration(os.Getenv("LOADER_POLL_INTERVAL"))
+	if err != nil || pollInterval <= 0 {
+		pollInterval = time.Second
+	}
+	rescan, err := time.ParseDuration(os.Getenv("LOADER_RESCAN_INTERVAL"))
+	if err != nil || rescan <= 0 {
+		rescan = 5 * time.Minute
+	}
+
+	return types.WatchConfig{
+		Include:      splitGlobs("LOADER_INCLUDE"),
+		Exclude:      splitGlobs("LOADER_EXCLUDE"),
+		SubdirMode:   mode,
+		PollInterval: pollInterval,
+		Rescan:       rescan,
+	}
+}
+
+func newJobsConfig() types.JobsConfig {
+	envDuration := func(key string, def time.Duration) time.Duration {
+		v, err := time.ParseDuration(os.Getenv(key))
+		if err != nil || v <= 0 {
+			return def
+		}
+		return v
+	}
+	maxAttempts, err := strconv.Atoi(os.Getenv("LOADER_JOB_MAX_ATTEMPTS"))
+	if err != nil || maxAttempts <= 0 {
+		maxAttempts = 5
+	}
+
+	return types.JobsConfig{
+		MaxAttempts:  maxAttempts,
+		Backoff:      envDuration("LOADER_JOB_BACKOFF", 30*time.Second),
+		MaxBackoff:   envDuration("LOADER_JOB_MAX_BACKOFF", 30*time.Minute),
+		Lease:        envDuration("LOADER_JOB_LEASE", 2*time.Hour),
+		PollInterval: time.Second,
+	}
+}
+
+func newWorkersConfig() types.WorkersConfig {
+	envInt := func(key string, def int) int {
+		v, err := strconv.Atoi(os.Getenv(key))
+		if err != nil || v <= 0 {
+			return def
+		}
+		return v
+	}
+
+	return types.WorkersConfig{
+		Files:   envInt("LOADER_WORKERS", 4),
+		Convert: envInt("LOADER_CONVERT_CONCURRENCY", 2),
+		Vision:  envInt("LOADER_VISION_CONCURRENCY", 1),
+		Embed:   envInt("LOADER_EMBED_CONCURRENCY", 4),
+	}
+}
+
+func newWebConfig() types.WebConfig {
+	userAgent := os.Getenv("LOADER_WEB_USER_AGENT")
+	if userAgent == "" {
+		userAgent = "rag-loader/1.0"
+	}
+	timeout, err := time.ParseDuration(os.Getenv("LOADER_WEB_TIMEOUT"))
+	if err != nil {
+		timeout = 30 * time.Second
+	}
+	delay, _ := time.ParseDuration(os.Getenv("LOADER_WEB_DELAY"))
+	lease, err := time.ParseDuration(os.Getenv("LOADER_WEB_LEASE"))
+	if err != nil || lease < time.Second {
+		lease = 5 * time.Minute
+	}
+
+	return types.WebConfig{
+		UserAgent:    userAgent,
+		Timeout:      timeout,
+		Delay:        delay,
+		PollInterval: 5 * time.Second,
+		Lease:        lease,
+	}
+}
+
+func newTreeConfig() types.TreeConfig {
+	interval, err := time.ParseDuration(os.Getenv("LOADER_TREE_INTERVAL"))
+	if err != nil {
+		interval = 5 * time.Minute
+	}
+
+	globs := []string{"**/*.md"}
+	if v := os.Getenv("LOADER_TREE_GLOB"); v != "" {
+		globs = strings.Split(v, ",")
+	}
+
+	return types.TreeConfig{
+		Dir:       os.Getenv("LOADER_TREE_DIR"),
+		GitURL:    os.Getenv("LOADER_TREE_GIT_URL"),
+		GitBranch: os.Getenv("LOADER_TREE_GIT_BRANCH"),
+		Globs:     globs,
+		Interval:  interval,
 	}
 }
 
 func New(storer store.DBStorer) *Service {
 	cfg := NewConfig()
+	loader := internal.NewPDFLoader(cfg, storer)
+
+	var tree *internal.TreeSource
+	if cfg.Tree.Dir != "" {
+		tree = internal.NewTreeSource(cfg.Tree, loader)
+	}
+
 	return &Service{
 		logger: slog.Default(),
 		store:  storer,
-		loader: internal.NewPDFLoader(cfg),
+		loader: loader,
+		tree:   tree,
+		web:    internal.NewWebCrawler(cfg.Web, loader),
 	}
 }
 
@@ -63,24 +203,22 @@ func (s *Service) Run() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	fileChan := make(chan string, 10) // Буферизованный канал для предотвращения блокировок
 	var wg sync.WaitGroup
 
 	docChan := make(chan *types.Document)
 
-	// Запуск горутины для мониторинга файлов
+	// Запуск горутины для мониторинга файлов, найденные файлы ставятся в очередь заданий
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		defer close(fileChan) // Закрываем канал при завершении watchFile
-		s.loader.WatchFile(ctx, fileChan)
+		s.loader.WatchFile(ctx)
 	}()
 
-	// Запуск горутины для обработки файлов
+	// Запуск пула воркеров, выполняющих задания из очереди
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		s.loader.ProcessFile(ctx, fileChan, docChan)
+		s.loader.ProcessFile(ctx, s.SaveDocument)
 	}()
 
 	// Запуск горутины для сохранения документа
@@ -90,6 +228,22 @@ func (s *Service) Run() {
 		s.DocumentSave(ctx, docChan)
 	}()
 
+	// Запуск горутины для обхода дерева каталогов / git-репозитория
+	if s.tree != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.WatchTree(ctx, docChan)
+		}()
+	}
+
+	// Запуск горутины для обхода сайтов по заявкам из API
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.WatchCrawls(ctx, docChan)
+	}()
+
 	// query := "Test PDF file for testing."
 	// queryEmbedding, err := s.embedder.Embed(query)
 	// if err != nil {
@@ -137,44 +291,207 @@ func (s *Service) Run() {
 	log.Println("Service stopped successfully")
 }
 
-func (s *Service) DocumentSave(ctx context.Context, docChan <-chan *types.Document) error {
+// DocumentSave сохраняет документы, найденные обходом дерева и сайтов.
+func (s *Service) DocumentSave(ctx context.Context, docChan <-chan *types.Document) {
 	for {
-		doc, ok := <-docChan
-		if !ok {
-			break
+		select {
+		case <-ctx.Done():
+			return
+		case doc, ok := <-docChan:
+			if !ok {
+				return
+			}
+			if err := s.SaveDocument(ctx, doc); err != nil {
+				fmt.Printf("error saving document %s: %s\n", doc.SourcePath, err)
+			}
 		}
+	}
+}
 
-		if !s.ShouldUpdateFile(ctx, doc.ID, doc.UpdatedAt) {
-			s.loader.MoveToArchive(doc.SourcePath, 1)
-			return nil
+// SaveDocument заменяет чанки и таблицы документа, если он изменился,
+// обновляет путь к нему и архивирует исходный файл.
+func (s *Service) SaveDocument(ctx context.Context, doc *types.Document) error {
+	if !s.needsSave(ctx, doc) {
+		fmt.Printf("Document %s is unchanged, skipping\n", doc.SourcePath)
+		if err := s.setPath(ctx, doc); err != nil {
+			return err
 		}
+		s.archive(doc, 0)
+		return nil
+	}
 
-		//remove old chunks from DB
-		if err := s.store.DeleteChunksByDocID(ctx, doc.ID); err != nil {
-			fmt.Println(err)
+	// Неизменившиеся чанки сохраняют id и переносятся из предыдущей версии,
+	// поэтому старые чанки удаляются после сохранения новых, а не до
+	tableIDs := make([]uuid.UUID, 0, len(doc.FullTable))
+	for i := range doc.FullTable {
+		if err := s.store.SaveTable(ctx, doc.FullTable[i]); err != nil {
 			return err
 		}
-
-		// Используем переданный контекст для операций с БД
-		if err := s.store.SaveDocument(ctx, *doc); err != nil {
-			fmt.Println(err)
+		tableIDs = append(tableIDs, doc.FullTable[i].ID)
+	}
+	chunkIDs := make([]uuid.UUID, 0, len(doc.Chunks))
+	for i := range doc.Chunks {
+		if err := s.store.SaveChunk(ctx, doc.Chunks[i]); err != nil {
 			return err
 		}
-		for i := range doc.FullTable {
-			if err := s.store.SaveTable(ctx, doc.FullTable[i]); err != nil {
-				fmt.Println(err)
-				return err
-			}
+		chunkIDs = append(chunkIDs, doc.Chunks[i].ID)
+	}
+	if err := s.store.DeleteStaleChunks(ctx, doc.ID, chunkIDs, tableIDs); err != nil {
+		return err
+	}
+	// Строка документа пишется последней: её наличие означает, что документ
+	// сохранён целиком и его содержимое можно не загружать повторно
+	if err := s.store.SaveDocument(ctx, *doc); err != nil {
+		return err
+	}
+	if err := s.setPath(ctx, doc); err != nil {
+		return err
+	}
+
+	fmt.Printf("Successfuly Saved document\n")
+	s.archive(doc, 0)
+	return nil
+}
+
+// needsSave сообщает, нужно ли сохранять документ. Документ с хешем
+// содержимого сохраняется, только если такого содержимого ещё нет;
+// остальные — если изменилось время обновления.
+func (s *Service) needsSave(ctx context.Context, doc *types.Document) bool {
+	if doc.ContentHash == "" {
+		return s.ShouldUpdateFile(ctx, doc.ID, doc.UpdatedAt)
+	}
+	_, err := s.store.GetDocumentByID(ctx, doc.ID)
+	return err != nil
+}
+
+// setPath направляет логический путь файла на документ.
+func (s *Service) setPath(ctx context.Context, doc *types.Document) error {
+	if doc.LogicalPath == "" {
+		return nil
+	}
+	return s.store.SetDocumentPath(ctx, types.DocumentPath{
+		Path:       doc.LogicalPath,
+		DocID:      doc.ID,
+		Source:     doc.Source,
+		SourcePath: doc.SourcePath,
+	})
+}
+
+// archive перемещает в архив исходный файл документа. Архивируются
+// только файлы из LOADER_SOURCE_DIR, остальные источники остаются на месте.
+func (s *Service) archive(doc *types.Document, fileState int) {
+	if doc.Source != internal.SourcePDF {
+		return
+	}
+	s.loader.MoveToArchive(doc.SourcePath, fileState)
+}
+
+// WatchTree периодически обходит дерево каталогов, отправляет на загрузку
+// новые и изменённые файлы и удаляет документы, чьи файлы были удалены.
+func (s *Service) WatchTree(ctx context.Context, docChan chan<- *types.Document) {
+	fmt.Printf("Start crawling tree: %s\n", s.tree.Root())
+	defer fmt.Println("Tree crawler stopped")
+
+	ticker := time.NewTicker(s.tree.Interval())
+	defer ticker.Stop()
+
+	for {
+		if err := s.syncTree(ctx, docChan); err != nil && ctx.Err() == nil {
+			fmt.Printf("error while crawling tree %s: %s\n", s.tree.Root(), err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Service) syncTree(ctx context.Context, docChan chan<- *types.Document) error {
+	if err := s.tree.Sync(ctx); err != nil {
+		return err
+	}
+
+	files, err := s.tree.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	current := make(map[string]bool, len(files))
+	ids := make(map[uuid.UUID]bool, len(files))
+	for _, f := range files {
+		id := s.tree.DocumentID(f)
+		path := s.tree.LogicalPath(f)
+		current[path] = true
+		ids[id] = true
+
+		// Путь уже указывает на это содержимое
+		if prev, err := s.store.GetDocumentPath(ctx, path); err == nil && prev == id {
+			continue
 		}
-		for i := range doc.Chunks {
-			if err := s.store.SaveChunk(ctx, doc.Chunks[i]); err != nil {
-				fmt.Println(err)
+
+		// Содержимое уже загружено под другим путём: файл переименован или скопирован
+		if _, err := s.store.GetDocumentByID(ctx, id); err == nil {
+			fmt.Printf("Tree file %s matches loaded document %s, updating path\n", f.RelPath, id)
+			err := s.store.SetDocumentPath(ctx, types.DocumentPath{
+				Path:       path,
+				DocID:      id,
+				Source:     s.tree.Source(),
+				SourcePath: s.tree.SourcePath(f),
+			})
+			if err != nil {
 				return err
 			}
+			continue
+		}
+
+		fmt.Printf("Processing tree file: %s\n", f.RelPath)
+		doc, err := s.tree.Load(ctx, f)
+		if err != nil {
+			fmt.Printf("Error processing tree file %s: %v\n", f.RelPath, err)
+			continue
+		}
+
+		select {
+		case docChan <- doc:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	// Удаляем пути файлов, которых больше нет в дереве; документ удаляется
+	// вместе с последним путём к нему
+	paths, err := s.store.ListDocumentPaths(ctx, s.tree.Source(), s.tree.Root()+":")
+	if err != nil {
+		return err
+	}
+	for _, dp := range paths {
+		if current[dp.Path] {
+			continue
+		}
+		fmt.Printf("File removed upstream, deleting path: %s\n", dp.Path)
+		if err := s.store.DeleteDocumentPath(ctx, dp.Path); err != nil {
+			return err
 		}
+	}
 
-		fmt.Printf("Successfuly Saved document\n")
-		s.loader.MoveToArchive(doc.SourcePath, 0)
+	// Документы без путей (например, загруженные до появления таблицы путей)
+	docs, err := s.store.ListDocumentsBySource(ctx, s.tree.Source(), s.tree.Root())
+	if err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		if ids[doc.ID] {
+			continue
+		}
+		deleted, err := s.store.DeleteOrphanDocument(ctx, doc.ID)
+		if err != nil {
+			return err
+		}
+		if deleted {
+			fmt.Printf("Deleted orphan document: %s\n", doc.SourcePath)
+		}
 	}
 	return nil
 }
@@ -193,3 +510,69 @@ func (s *Service) ShouldUpdateFile(ctx context.Context, docID uuid.UUID, modTime
 	fmt.Println("Need to update:", modTime.After(doc.UpdatedAt))
 	return modTime.After(doc.UpdatedAt)
 }
+
+// WatchCrawls забирает заявки на обход сайтов и выполняет их по одной.
+// Пока обход идёт, аренда заявки продлевается; заявку, чья аренда истекла
+// (загрузчик упал), забирают снова. При остановке незавершённый обход
+// возвращается в очередь.
+func (s *Service) WatchCrawls(ctx context.Context, docChan chan<- *types.Document) {
+	defer fmt.Println("Web crawler stopped")
+
+	ticker := time.NewTicker(s.web.PollInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		crawl, err := s.store.ClaimCrawl(ctx, s.web.Lease())
+		if err != nil {
+			if !errors.Is(err, sql.ErrNoRows) && ctx.Err() == nil {
+				fmt.Printf("error while claiming crawl: %s\n", err)
+			}
+			continue
+		}
+
+		fmt.Printf("Start crawling %s (depth %d, pages %d)\n", crawl.SeedURL, crawl.MaxDepth, crawl.MaxPages)
+		crawlCtx, stopLease := context.WithCancel(ctx)
+		go s.extendCrawlLease(crawlCtx, crawl.ID)
+		pages, err := s.web.Crawl(ctx, *crawl, docChan)
+		stopLease()
+
+		if ctx.Err() != nil {
+			fmt.Printf("Crawl %s interrupted, returning to queue\n", crawl.ID)
+			if err := s.store.ReleaseCrawl(context.Background(), crawl.ID); err != nil {
+				fmt.Printf("error releasing crawl %s: %s\n", crawl.ID, err)
+			}
+			return
+		}
+		if err != nil {
+			fmt.Printf("error while crawling %s: %s\n", crawl.SeedURL, err)
+		}
+
+		if err := s.store.FinishCrawl(context.Background(), crawl.ID, pages, err); err != nil {
+			fmt.Printf("error while saving crawl result: %s\n", err)
+		}
+	}
+}
+
+// extendCrawlLease продлевает аренду заявки на обход, пока не отменён ctx.
+func (s *Service) extendCrawlLease(ctx context.Context, id uuid.UUID) {
+	lease := s.web.Lease()
+	ticker := time.NewTicker(lease / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := s.store.ExtendCrawl(ctx, id, lease); err != nil && ctx.Err() == nil {
+			fmt.Printf("error extending lease of crawl %s: %s\n", id, err)
+		}
+	}
+}
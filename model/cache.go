@@ -0,0 +1,229 @@
+package model
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EmbeddingCache — постоянное хранилище эмбеддингов по модели и хешу текста.
+type EmbeddingCache interface {
+	GetCachedEmbeddings(ctx context.Context, model string, hashes []string) (map[string][]float32, error)
+	SaveCachedEmbeddings(ctx context.Context, model string, embeddings map[string][]float32) error
+}
+
+// CacheStats — статистика попаданий в кэш эмбеддингов с момента запуска.
+type CacheStats struct {
+	Model     string  `json:"model"`
+	MemoryHit int64   `json:"memory_hits"`
+	StoreHit  int64   `json:"store_hits"`
+	Misses    int64   `json:"misses"`
+	HitRate   float64 `json:"hit_rate"`
+	Size      int     `json:"memory_size"`
+}
+
+// CachedEmbedder оборачивает EmbedderInterface: эмбеддинг ищется сначала
+// в LRU в памяти процесса, затем в БД, и только потом считается моделью.
+// Ошибки кэша не мешают получить эмбеддинг.
+type CachedEmbedder struct {
+	inner EmbedderInterface
+	store EmbeddingCache
+
+	mu        sync.Mutex
+	lru       *lru
+	memHits   int64
+	dbHits    int64
+	misses    int64
+	dbTimeout time.Duration
+}
+
+// NewCachedEmbedder создаёт кэш перед inner. Размер LRU задаётся
+// EMBED_CACHE_SIZE (число векторов), по умолчанию 10000.
+func NewCachedEmbedder(inner EmbedderInterface, store EmbeddingCache) *CachedEmbedder {
+	size, err := strconv.Atoi(os.Getenv("EMBED_CACHE_SIZE"))
+	if err != nil || size <= 0 {
+		size = 10000
+	}
+	return &CachedEmbedder{
+		inner:     inner,
+		store:     store,
+		lru:       newLRU(size),
+		dbTimeout: 5 * time.Second,
+	}
+}
+
+func (c *CachedEmbedder) Model() string {
+	return c.inner.Model()
+}
+
+func (c *CachedEmbedder) Embed(text string) ([]float32, error) {
+	embeddings, err := c.EmbedBatch([]string{text})
+	if err != nil {
+		return nil, err
+	}
+	return embeddings[0], nil
+}
+
+func (c *CachedEmbedder) EmbedBatch(texts []string) ([][]float32, error) {
+	out := make([][]float32, len(texts))
+	hashes := make([]string, len(texts))
+
+	// 1. LRU в памяти
+	var missing []int
+	c.mu.Lock()
+	for i, text := range texts {
+		hashes[i] = textHash(text)
+		if emb, ok := c.lru.get(hashes[i]); ok {
+			out[i] = emb
+			c.memHits++
+			continue
+		}
+		missing = append(missing, i)
+	}
+	c.mu.Unlock()
+	if len(missing) == 0 {
+		return out, nil
+	}
+
+	// 2. Таблица в БД
+	model := c.inner.Model()
+	ctx, cancel := context.WithTimeout(context.Background(), c.dbTimeout)
+	defer cancel()
+
+	lookup := make([]string, len(missing))
+	for j, i := range missing {
+		lookup[j] = hashes[i]
+	}
+	stored, err := c.store.GetCachedEmbeddings(ctx, model, lookup)
+	if err != nil {
+		fmt.Printf("error reading embedding cache: %s\n", err)
+	}
+
+	var pending []int
+	c.mu.Lock()
+	for _, i := range missing {
+		if emb, ok := stored[hashes[i]]; ok {
+			out[i] = emb
+			c.lru.add(hashes[i], emb)
+			c.dbHits++
+			continue
+		}
+		pending = append(pending, i)
+	}
+	c.mu.Unlock()
+	if len(pending) == 0 {
+		return out, nil
+	}
+
+	// 3. Модель; одинаковые тексты внутри пакета считаются один раз
+	unique := make(map[string]int)
+	var toEmbed []string
+	for _, i := range pending {
+		if _, ok := unique[hashes[i]]; !ok {
+			unique[hashes[i]] = len(toEmbed)
+			toEmbed = append(toEmbed, texts[i])
+		}
+	}
+	computed, err := c.inner.EmbedBatch(toEmbed)
+	if err != nil {
+		return nil, err
+	}
+
+	fresh := make(map[string][]float32, len(toEmbed))
+	c.mu.Lock()
+	for _, i := range pending {
+		emb := computed[unique[hashes[i]]]
+		out[i] = emb
+		fresh[hashes[i]] = emb
+		c.lru.add(hashes[i], emb)
+		c.misses++
+	}
+	c.mu.Unlock()
+
+	// Модель могла считать дольше таймаута чтения, поэтому контекст новый
+	saveCtx, saveCancel := context.WithTimeout(context.Background(), c.dbTimeout)
+	defer saveCancel()
+	if err := c.store.SaveCachedEmbeddings(saveCtx, model, fresh); err != nil {
+		fmt.Printf("error writing embedding cache: %s\n", err)
+	}
+	return out, nil
+}
+
+// Stats возвращает статистику попаданий в кэш.
+func (c *CachedEmbedder) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := CacheStats{
+		Model:     c.inner.Model(),
+		MemoryHit: c.memHits,
+		StoreHit:  c.dbHits,
+		Misses:    c.misses,
+		Size:      c.lru.len(),
+	}
+	if total := c.memHits + c.dbHits + c.misses; total > 0 {
+		stats.HitRate = float64(c.memHits+c.dbHits) / float64(total)
+	}
+	return stats
+}
+
+// textHash — sha256 текста с нормализованными пробелами.
+func textHash(text string) string {
+	sum := sha256.Sum256([]byte(strings.Join(strings.Fields(text), " ")))
+	return hex.EncodeToString(sum[:])
+}
+
+// lru — кэш фиксированного размера, вытесняющий давно не использованные записи.
+// Не потокобезопасен, блокировка на стороне CachedEmbedder.
+type lru struct {
+	size  int
+	order *list.List
+	items map[string]*list.Element
+}
+
+type lruEntry struct {
+	key string
+	val []float32
+}
+
+func newLRU(size int) *lru {
+	return &lru{
+		size:  size,
+		order: list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+func (l *lru) get(key string) ([]float32, bool) {
+	el, ok := l.items[key]
+	if !ok {
+		return nil, false
+	}
+	l.order.MoveToFront(el)
+	return el.Value.(*lruEntry).val, true
+}
+
+func (l *lru) add(key string, val []float32) {
+	if el, ok := l.items[key]; ok {
+		el.Value.(*lruEntry).val = val
+		l.order.MoveToFront(el)
+		return
+	}
+	l.items[key] = l.order.PushFront(&lruEntry{key: key, val: val})
+	if l.order.Len() > l.size {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.items, oldest.Value.(*lruEntry).key)
+	}
+}
+
+func (l *lru) len() int {
+	return l.order.Len()
+}
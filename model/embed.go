@@ -4,22 +4,48 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"math"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// ErrUnavailable возвращается, когда сервис модели не отвечает или
+// отвечает ошибкой 5xx. Такие ошибки имеет смысл повторять.
+var ErrUnavailable = errors.New("model service is unavailable")
+
 // EmbedderInterface определяет интерфейс для создания эмбеддингов
 type EmbedderInterface interface {
 	Embed(text string) ([]float32, error)
+	// EmbedBatch возвращает эмбеддинги в порядке texts
+	EmbedBatch(texts []string) ([][]float32, error)
+	// Model — идентификатор эмбеддингов: модель и всё, что меняет вектор
+	// (провайдер, адрес, размерность). Эмбеддинги разных идентификаторов
+	// несовместимы, кэш хранит их раздельно
+	Model() string
+}
+
+// NewEmbedder создаёт embedder по EMBEDDING_PROVIDER: ollama (по умолчанию)
+// или openai — любой сервер с OpenAI-совместимым /v1/embeddings.
+func NewEmbedder() EmbedderInterface {
+	switch strings.ToLower(os.Getenv("EMBEDDING_PROVIDER")) {
+	case "openai":
+		return NewOpenAIEmbedder()
+	default:
+		return NewOllamaEmbedder()
+	}
 }
 
 type OllamaEmbedder struct {
-	apiURL string
-	model  string
+	apiURL    string
+	batchURL  string
+	model     string
+	batchSize int
 }
 
 type OllamaEmbeddingRequest struct {
@@ -31,15 +57,45 @@ type OllamaEmbeddingResponse struct {
 	Embedding []float64 `json:"embedding"`
 }
 
+// OllamaEmbedRequest — запрос к пакетному /api/embed.
+type OllamaEmbedRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type OllamaEmbedResponse struct {
+	Embeddings [][]float64 `json:"embeddings"`
+}
+
 func NewOllamaEmbedder() *OllamaEmbedder {
 	ollamaURL := os.Getenv("OLLAMA_EMBEDDING_URL")
 	ollamaModel := os.Getenv("OLLAMA_EMBEDDING_MODEL")
+
+	// OLLAMA_EMBEDDING_URL указывает на /api/embeddings, пакетный
+	// /api/embed находится рядом
+	batchURL := os.Getenv("OLLAMA_EMBED_BATCH_URL")
+	if batchURL == "" && strings.HasSuffix(ollamaURL, "/api/embeddings") {
+		batchURL = strings.TrimSuffix(ollamaURL, "/api/embeddings") + "/api/embed"
+	}
+	batchSize, err := strconv.Atoi(os.Getenv("OLLAMA_EMBED_BATCH_SIZE"))
+	if err != nil || batchSize <= 0 {
+		batchSize = 32
+	}
+
 	return &OllamaEmbedder{
-		apiURL: ollamaURL,
-		model:  ollamaModel,
+		apiURL:    ollamaURL,
+		batchURL:  batchURL,
+		model:     ollamaModel,
+		batchSize: batchSize,
 	}
 }
 
+// Model возвращает провайдера, адрес сервера и модель: одна и та же модель
+// на другом сервере Ollama может быть другой сборки.
+func (e *OllamaEmbedder) Model() string {
+	return fmt.Sprintf("ollama:%s/%s", strings.TrimSuffix(e.apiURL, "/api/embeddings"), e.model)
+}
+
 func (e *OllamaEmbedder) Embed(text string) ([]float32, error) {
 	req := OllamaEmbeddingRequest{
 		Model:  e.model,
@@ -62,10 +118,14 @@ func (e *OllamaEmbedder) Embed(text string) ([]float32, error) {
 
 	resp, err := http.DefaultClient.Do(httpReq)
 	if err != nil {
-		return nil, fmt.Errorf("failed to make request: %w", err)
+		return nil, fmt.Errorf("%w: failed to make request: %v", ErrUnavailable, err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode >= 500 {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("%w: ollama API error: status %d, body: %s", ErrUnavailable, resp.StatusCode, string(body))
+	}
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
 		return nil, fmt.Errorf("ollama API error: status %d, body: %s", resp.StatusCode, string(body))
@@ -92,6 +152,91 @@ func (e *OllamaEmbedder) Embed(text string) ([]float32, error) {
 	return embedding, nil
 }
 
+// EmbedBatch делит texts на пакеты по OLLAMA_EMBED_BATCH_SIZE и отправляет
+// их по очереди: одновременность запросов ограничивает вызывающий
+// (LOADER_EMBED_CONCURRENCY). Если адрес /api/embed неизвестен, тексты
+// отправляются по одному через Embed.
+func (e *OllamaEmbedder) EmbedBatch(texts []string) ([][]float32, error) {
+	out := make([][]float32, len(texts))
+	if len(texts) == 0 {
+		return out, nil
+	}
+
+	if e.batchURL == "" {
+		for i, text := range texts {
+			emb, err := e.Embed(text)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = emb
+		}
+		return out, nil
+	}
+
+	for start := 0; start < len(texts); start += e.batchSize {
+		end := min(start+e.batchSize, len(texts))
+		embs, err := e.embedBatch(texts[start:end])
+		if err != nil {
+			return nil, err
+		}
+		copy(out[start:end], embs)
+	}
+	return out, nil
+}
+
+// embedBatch отправляет один пакет в /api/embed.
+func (e *OllamaEmbedder) embedBatch(texts []string) ([][]float32, error) {
+	body, err := json.Marshal(OllamaEmbedRequest{
+		Model: e.model,
+		Input: texts,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	// Пакет считается дольше одного текста
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", e.batchURL, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to make request: %v", ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 500 {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("%w: ollama API error: status %d, body: %s", ErrUnavailable, resp.StatusCode, string(body))
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("ollama API error: status %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	var ollamaResp OllamaEmbedResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ollamaResp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	if len(ollamaResp.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("ollama returned %d embeddings for %d texts", len(ollamaResp.Embeddings), len(texts))
+	}
+
+	embeddings := make([][]float32, len(texts))
+	for i, vec := range ollamaResp.Embeddings {
+		norm := normalize64(vec)
+		embeddings[i] = make([]float32, len(norm))
+		for j, v := range norm {
+			embeddings[i][j] = float32(v)
+		}
+	}
+	return embeddings, nil
+}
+
 // Normalize принимает срез float32 и возвращает нормализованный вектор
 func normalize64(vec []float64) []float64 {
 	var sum float64
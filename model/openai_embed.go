@@ -0,0 +1,151 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OpenAIEmbedder считает эмбеддинги через OpenAI-совместимый /v1/embeddings
+// (OpenAI, vLLM, LocalAI, llama.cpp server).
+type OpenAIEmbedder struct {
+	baseURL    string
+	apiKey     string
+	model      string
+	dimensions int
+	batchSize  int
+	client     *http.Client
+}
+
+type OpenAIEmbeddingRequest struct {
+	Model          string   `json:"model"`
+	Input          []string `json:"input"`
+	Dimensions     int      `json:"dimensions,omitempty"`
+	EncodingFormat string   `json:"encoding_format"`
+}
+
+type OpenAIEmbeddingResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float64 `json:"embedding"`
+	} `json:"data"`
+}
+
+// NewOpenAIEmbedder читает настройки из OPENAI_EMBEDDING_URL (базовый адрес
+// с /v1), OPENAI_EMBEDDING_API_KEY, OPENAI_EMBEDDING_MODEL,
+// OPENAI_EMBEDDING_DIMENSIONS и OPENAI_EMBEDDING_BATCH_SIZE.
+// Размерность должна совпадать с колонкой chunks.embedding (1024).
+func NewOpenAIEmbedder() *OpenAIEmbedder {
+	baseURL := os.Getenv("OPENAI_EMBEDDING_URL")
+	if baseURL == "" {
+		baseURL = "https://api.openai.com/v1"
+	}
+	dimensions, _ := strconv.Atoi(os.Getenv("OPENAI_EMBEDDING_DIMENSIONS"))
+	batchSize, err := strconv.Atoi(os.Getenv("OPENAI_EMBEDDING_BATCH_SIZE"))
+	if err != nil || batchSize <= 0 {
+		batchSize = 64
+	}
+
+	return &OpenAIEmbedder{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		apiKey:     os.Getenv("OPENAI_EMBEDDING_API_KEY"),
+		model:      os.Getenv("OPENAI_EMBEDDING_MODEL"),
+		dimensions: dimensions,
+		batchSize:  batchSize,
+		client:     &http.Client{Timeout: 5 * time.Minute},
+	}
+}
+
+// Model возвращает провайдера, адрес, модель и размерность: одна и та же
+// модель с другим dimensions или на другом сервере даёт другие векторы.
+func (e *OpenAIEmbedder) Model() string {
+	return fmt.Sprintf("openai:%s/%s@%d", e.baseURL, e.model, e.dimensions)
+}
+
+func (e *OpenAIEmbedder) Embed(text string) ([]float32, error) {
+	embeddings, err := e.EmbedBatch([]string{text})
+	if err != nil {
+		return nil, err
+	}
+	return embeddings[0], nil
+}
+
+// EmbedBatch отправляет texts пакетами по OPENAI_EMBEDDING_BATCH_SIZE.
+func (e *OpenAIEmbedder) EmbedBatch(texts []string) ([][]float32, error) {
+	out := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += e.batchSize {
+		end := min(start+e.batchSize, len(texts))
+		embs, err := e.embedBatch(texts[start:end])
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, embs...)
+	}
+	return out, nil
+}
+
+func (e *OpenAIEmbedder) embedBatch(texts []string) ([][]float32, error) {
+	body, err := json.Marshal(OpenAIEmbeddingRequest{
+		Model:          e.model,
+		Input:          texts,
+		Dimensions:     e.dimensions,
+		EncodingFormat: "float",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(context.Background(), "POST", e.baseURL+"/embeddings", bytes.NewBuffer(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if e.apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+e.apiKey)
+	}
+
+	resp, err := e.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to make request: %v", ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	// Превышение лимита запросов у hosted API проходит само, как и 5xx
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("%w: embeddings API error: status %d, body: %s", ErrUnavailable, resp.StatusCode, string(body))
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("embeddings API error: status %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	var apiResp OpenAIEmbeddingResponse
+	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	if len(apiResp.Data) != len(texts) {
+		return nil, fmt.Errorf("embeddings API returned %d embeddings for %d texts", len(apiResp.Data), len(texts))
+	}
+
+	// Порядок ответа не гарантирован, восстанавливаем его по index
+	sort.Slice(apiResp.Data, func(i, j int) bool { return apiResp.Data[i].Index < apiResp.Data[j].Index })
+
+	embeddings := make([][]float32, len(texts))
+	for i, d := range apiResp.Data {
+		norm := normalize64(d.Embedding)
+		embeddings[i] = make([]float32, len(norm))
+		for j, v := range norm {
+			embeddings[i][j] = float32(v)
+		}
+	}
+	return embeddings, nil
+}
@@ -9,6 +9,8 @@ import (
 	"io"
 	"net/http"
 	"os"
+	promptlib "rag/prompt"
+	"rag/types"
 	"strings"
 	"time"
 )
@@ -21,6 +23,8 @@ type VisionModel interface {
 type LLaVA struct {
 	URL   string
 	Model string
+	// Шаблоны промптов; nil — только встроенные
+	Prompts *promptlib.Library
 }
 
 type LLaVARequest struct {
@@ -50,55 +54,11 @@ func NewLLaVA() *LLaVA {
 func (l *LLaVA) Describe(img string) (string, error) {
 	fmt.Println("Starting prompt to LLaVA for describe immage")
 
-	prompt := `You are a vision-language extraction model.
-
-Your task is to extract all visible UI text from the provided image
-and return it as a SINGLE valid JSON object.
-
-IMPORTANT RULES (MANDATORY):
-
-- Output MUST be valid JSON.
-- Output MUST start with '{' and end with '}'.
-- Do NOT include explanations, comments, or markdown.
-- Do NOT include any text outside JSON.
-- Do NOT invent or infer missing values.
-- If something is unclear or unlabeled, use an empty string "".
-
-JSON STRUCTURE (FIXED):
-
-{
-  "sections": [
-    {
-      "section_name": "",
-      "fields": [
-        {
-          "label": "",
-          "value": ""
-        }
-      ],
-      "buttons": [],
-      "other_text": []
-    }
-  ]
-}
-
-EXTRACTION RULES:
-
-- Preserve exact wording, capitalization, punctuation, and numbers.
-- Include all visible:
-  - labels
-  - input fields and their values
-  - dropdowns with selected values
-  - checkboxes and radio buttons with state ("checked"/"unchecked")
-  - buttons and menu items
-  - numeric values, units, and symbols
-- If there are no buttons, use an empty array.
-- If there is no other text, use an empty array.
-- Every section MUST include all four keys:
-  "section_name", "fields", "buttons", "other_text".
-
-NOW analyze the image and return ONLY the JSON object.
-`
+	prompt, ref, err := l.Prompts.Render(context.Background(), promptlib.DescribeImage, types.PromptVars{})
+	if err != nil {
+		return "", err
+	}
+	fmt.Printf("prompt: %s v%d\n", ref.Name, ref.Version)
 	fmt.Printf("cfg: model - %s, url - %s\n", l.Model, l.URL)
 	req := LLaVARequest{
 		Model:      l.Model, // Using llava model for image analysis
@@ -164,10 +124,15 @@ NOW analyze the image and return ONLY the JSON object.
 	resp, err := http.Post(l.URL, "application/json", bytes.NewBuffer(reqBody))
 	if err != nil {
 		fmt.Printf("Error sending request: %v\n", err)
-		return "", err
+		return "", fmt.Errorf("%w: %v", ErrUnavailable, err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode >= 500 {
+		body, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("%w: vision API error: status %d, body: %s", ErrUnavailable, resp.StatusCode, string(body))
+	}
+
 	decoder := json.NewDecoder(resp.Body)
 
 	var b strings.Builder
@@ -0,0 +1,111 @@
+package prompt
+
+// Встроенные шаблоны (версия 0). Действуют, пока в БД нет сохранённых версий.
+var builtins = map[string]string{
+	Answer: `Контекст из нескольких документов:
+Контекст:
+{{.Context}}
+Фрагменты контекста пронумерованы: [1], [2] и т.д. После каждого утверждения
+указывай номера фрагментов, на которых оно основано, в квадратных скобках,
+например [1] или [2, 3]. Не ссылайся на номера, которых нет в контексте.
+{{if .Language}}Отвечай на языке: {{.Language}}.
+{{end}}Вопрос:
+{{.Question}} 
+Ответ:`,
+
+	AnswerCohere: `Контекст з декількох документів:
+Контекст:
+{{.Context}}
+Фрагменти контексту пронумеровані: [1], [2] тощо. Після кожного твердження
+вказуй у квадратних дужках номери фрагментів, на яких воно ґрунтується,
+наприклад [1] або [2, 3]. Не посилайся на номери, яких немає в контексті.
+{{if .Language}}Відповідай мовою: {{.Language}}.
+{{end}}Запит:
+{{.Question}} 
+Відповідь:`,
+
+	Condense: `История диалога:
+{{.History}}
+Последний вопрос пользователя:
+{{.Question}}
+
+Перепиши последний вопрос так, чтобы он был понятен без истории диалога:
+подставь упомянутые ранее предметы, названия и числа. Не отвечай на вопрос.
+Верни только переписанный вопрос на языке пользователя.`,
+
+	Verify: `Контекст:
+{{.Context}}
+
+Утверждения:
+{{range $i, $c := .Claims}}{{inc $i}}. {{$c}}
+{{end}}
+Для каждого утверждения определи, подтверждается ли оно контекстом:
+"supported" — полностью следует из контекста, "partial" — подтверждается
+только часть, "unsupported" — в контексте этого нет или значения отличаются.
+Числа, тарифы и коды должны совпадать с контекстом точно.
+Верни только JSON-массив вида [{"n": 1, "status": "supported"}] без пояснений.`,
+
+	Translate: `Переведи вопрос на язык: {{.Language}}.
+Сохрани без изменений названия, коды, числа и термины.
+Верни только перевод без пояснений.
+
+Вопрос:
+{{.Question}}`,
+
+	Proofread: `
+Исправь орфографические, пунктуационные и грамматические ошибки в тексте.
+
+Текст:
+{{.Text}}
+`,
+
+	DescribeImage: `You are a vision-language extraction model.
+
+Your task is to extract all visible UI text from the provided image
+and return it as a SINGLE valid JSON object.
+
+IMPORTANT RULES (MANDATORY):
+
+- Output MUST be valid JSON.
+- Output MUST start with '{' and end with '}'.
+- Do NOT include explanations, comments, or markdown.
+- Do NOT include any text outside JSON.
+- Do NOT invent or infer missing values.
+- If something is unclear or unlabeled, use an empty string "".
+
+JSON STRUCTURE (FIXED):
+
+{
+  "sections": [
+    {
+      "section_name": "",
+      "fields": [
+        {
+          "label": "",
+          "value": ""
+        }
+      ],
+      "buttons": [],
+      "other_text": []
+    }
+  ]
+}
+
+EXTRACTION RULES:
+
+- Preserve exact wording, capitalization, punctuation, and numbers.
+- Include all visible:
+  - labels
+  - input fields and their values
+  - dropdowns with selected values
+  - checkboxes and radio buttons with state ("checked"/"unchecked")
+  - buttons and menu items
+  - numeric values, units, and symbols
+- If there are no buttons, use an empty array.
+- If there is no other text, use an empty array.
+- Every section MUST include all four keys:
+  "section_name", "fields", "buttons", "other_text".
+
+NOW analyze the image and return ONLY the JSON object.
+`,
+}
@@ -0,0 +1,175 @@
+package prompt
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"rag/types"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Имена шаблонов, которые использует код
+const (
+	Answer        = "answer"         // ответ по контексту
+	AnswerCohere  = "answer_cohere"  // ответ по контексту для Cohere (украинский, как до профилей)
+	Condense      = "condense"       // уточняющий вопрос диалога → самостоятельный запрос
+	Verify        = "verify"         // проверка утверждений ответа судьёй
+	Proofread     = "proofread"      // корректура текста
+	DescribeImage = "describe_image" // извлечение текста с изображения
+	Translate     = "translate"      // перевод вопроса на язык документов
+)
+
+// Последняя версия шаблона перечитывается из БД не чаще, чем раз в cacheTTL
+const cacheTTL = 30 * time.Second
+
+var funcs = template.FuncMap{
+	"inc": func(i int) int { return i + 1 },
+}
+
+// Store — хранилище версий шаблонов. version 0 — последняя версия;
+// если версий нет, возвращается sql.ErrNoRows.
+type Store interface {
+	GetPromptTemplate(ctx context.Context, name string, version int) (*types.PromptTemplate, error)
+}
+
+// Library отдаёт шаблоны из хранилища, а если их там нет — встроенные.
+// nil-библиотека и библиотека без хранилища отдают только встроенные шаблоны.
+type Library struct {
+	store Store
+
+	mu    sync.Mutex
+	cache map[string]cachedTemplate
+}
+
+type cachedTemplate struct {
+	tmpl    *types.PromptTemplate
+	expires time.Time
+}
+
+func NewLibrary(store Store) *Library {
+	return &Library{
+		store: store,
+		cache: make(map[string]cachedTemplate),
+	}
+}
+
+// Names возвращает имена всех шаблонов, которые использует код.
+func Names() []string {
+	names := make([]string, 0, len(builtins))
+	for name := range builtins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Builtin возвращает встроенный шаблон (версия 0).
+func Builtin(name string) (*types.PromptTemplate, bool) {
+	body, ok := builtins[name]
+	if !ok {
+		return nil, false
+	}
+	return &types.PromptTemplate{Name: name, Version: 0, Body: body, Description: "встроенный шаблон"}, true
+}
+
+// Template возвращает версию шаблона; version 0 — действующую: последнюю
+// из хранилища или встроенную. Если хранилище недоступно, используется
+// встроенный шаблон, чтобы ошибка БД не останавливала ответы.
+func (l *Library) Template(ctx context.Context, name string, version int) (*types.PromptTemplate, error) {
+	if l == nil || l.store == nil {
+		return builtinOrErr(name, version)
+	}
+
+	if version == 0 {
+		l.mu.Lock()
+		c, ok := l.cache[name]
+		l.mu.Unlock()
+		if ok && time.Now().Before(c.expires) {
+			return c.tmpl, nil
+		}
+	}
+
+	tmpl, err := l.store.GetPromptTemplate(ctx, name, version)
+	if errors.Is(err, sql.ErrNoRows) {
+		tmpl, err = builtinOrErr(name, version)
+	} else if err != nil && version == 0 {
+		fmt.Printf("error loading prompt template %s, using builtin: %s\n", name, err)
+		tmpl, err = builtinOrErr(name, version)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if version == 0 {
+		l.mu.Lock()
+		l.cache[name] = cachedTemplate{tmpl: tmpl, expires: time.Now().Add(cacheTTL)}
+		l.mu.Unlock()
+	}
+	return tmpl, nil
+}
+
+// Invalidate сбрасывает кэш шаблона после сохранения или удаления версии.
+func (l *Library) Invalidate(name string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	delete(l.cache, name)
+	l.mu.Unlock()
+}
+
+// Render подставляет переменные в действующую версию шаблона и возвращает
+// промпт и ссылку на использованную версию.
+func (l *Library) Render(ctx context.Context, name string, vars types.PromptVars) (string, types.PromptRef, error) {
+	tmpl, err := l.Template(ctx, name, 0)
+	if err != nil {
+		return "", types.PromptRef{}, err
+	}
+	ref := types.PromptRef{Name: tmpl.Name, Version: tmpl.Version}
+
+	out, err := Execute(tmpl.Body, vars)
+	if err != nil {
+		return "", ref, fmt.Errorf("prompt template %s v%d: %w", tmpl.Name, tmpl.Version, err)
+	}
+	return out, ref, nil
+}
+
+// Execute подставляет переменные в текст шаблона.
+func Execute(body string, vars types.PromptVars) (string, error) {
+	tmpl, err := template.New("prompt").Funcs(funcs).Parse(body)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, vars); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// Check проверяет, что шаблон разбирается и использует только известные
+// переменные: подставляет в него заполненные примеры.
+func Check(body string) error {
+	_, err := Execute(body, types.PromptVars{
+		Context:  "[1] context",
+		Question: "question",
+		History:  "user: question",
+		Language: "language",
+		Claims:   []string{"claim"},
+		Text:     "text",
+	})
+	return err
+}
+
+func builtinOrErr(name string, version int) (*types.PromptTemplate, error) {
+	tmpl, ok := Builtin(name)
+	if !ok || version != 0 {
+		return nil, sql.ErrNoRows
+	}
+	return tmpl, nil
+}
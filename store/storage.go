@@ -3,28 +3,139 @@ package store
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"rag/types"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/pgvector/pgvector-go"
 )
 
+// ErrActiveJob — у файла уже есть задание в очереди или в работе.
+var ErrActiveJob = errors.New("file already has an active job")
+
 type Configer interface {
 	SetConfig(context.Context, int, map[string]any) (types.ConfigParams, error)
 	GetConfig(context.Context, int) (types.LLMConfig, error)
+	GetProfile(context.Context, string) (types.LLMConfig, error)
+	ListProfiles(context.Context) ([]types.LLMConfig, error)
+	SetProfile(context.Context, types.LLMConfig) (types.LLMConfig, error)
+}
+type Crawler interface {
+	CreateCrawl(context.Context, types.Crawl) (*types.Crawl, error)
+	GetCrawl(context.Context, uuid.UUID) (*types.Crawl, error)
+	ClaimCrawl(context.Context, time.Duration) (*types.Crawl, error)
+	ExtendCrawl(context.Context, uuid.UUID, time.Duration) error
+	ReleaseCrawl(context.Context, uuid.UUID) error
+	FinishCrawl(context.Context, uuid.UUID, int, error) error
+}
+
+// Chatter хранит диалоги и их историю.
+type Chatter interface {
+	CreateChatSession(context.Context, types.ChatSession) (*types.ChatSession, error)
+	GetChatSession(context.Context, uuid.UUID) (*types.ChatSession, error)
+	ListChatSessions(context.Context, int) ([]types.ChatSession, error)
+	DeleteChatSession(context.Context, uuid.UUID) error
+	AddChatMessages(context.Context, ...types.ChatMessage) error
+	ListChatMessages(context.Context, uuid.UUID, int) ([]types.ChatMessage, error)
+}
+
+// GapLogger собирает вопросы, на которые в документации не нашлось ответа.
+type GapLogger interface {
+	LogUnansweredQuestion(context.Context, types.UnansweredQuestion) error
+	ListUnansweredQuestions(context.Context, int) ([]types.UnansweredQuestion, error)
+}
+
+// PromptTemplater хранит версии шаблонов промптов (реализует prompt.Store).
+type PromptTemplater interface {
+	GetPromptTemplate(context.Context, string, int) (*types.PromptTemplate, error)
+	ListPromptTemplates(context.Context) ([]types.PromptTemplate, error)
+	ListPromptTemplateVersions(context.Context, string) ([]types.PromptTemplate, error)
+	SavePromptTemplate(context.Context, types.PromptTemplate) (*types.PromptTemplate, error)
+	DeletePromptTemplate(context.Context, string, int) error
+}
+
+type JobQueuer interface {
+	EnqueueJob(context.Context, string, string) (*types.Job, error)
+	ClaimJob(context.Context, time.Duration) (*types.Job, error)
+	CompleteJob(context.Context, uuid.UUID, map[string]int64, types.ChunkStats) error
+	RetryJob(context.Context, uuid.UUID, error, time.Time, map[string]int64) error
+	FailJob(context.Context, uuid.UUID, error, string, map[string]int64) error
+	ReleaseJob(context.Context, uuid.UUID) error
+	GetJob(context.Context, uuid.UUID) (*types.Job, error)
+	SetJobStage(context.Context, uuid.UUID, types.JobStage) error
+	ListJobs(context.Context, types.JobState, int) ([]types.Job, error)
+	RequeueJob(context.Context, uuid.UUID) (*types.Job, error)
+}
+
+// Aliaser связывает логические пути файлов с документами, идентифицируемыми
+// по хешу содержимого: один документ может быть доступен по нескольким путям.
+type Aliaser interface {
+	GetDocumentByID(context.Context, uuid.UUID) (*types.Document, error)
+	GetDocumentPath(context.Context, string) (uuid.UUID, error)
+	CountDocumentPaths(context.Context, uuid.UUID) (int, error)
+	SetDocumentPath(context.Context, types.DocumentPath) error
+	ListDocumentPaths(context.Context, string, string) ([]types.DocumentPath, error)
+	DeleteDocumentPath(context.Context, string) error
+	DeleteOrphanDocument(context.Context, uuid.UUID) (bool, error)
+}
+
+// ChunkLister отдаёт чанки документа вместе с эмбеддингами, чтобы при
+// повторной загрузке не пересчитывать неизменившиеся.
+type ChunkLister interface {
+	ListChunksByDocID(context.Context, uuid.UUID) ([]types.Chunk, error)
 }
+
+// EmbeddingCacher хранит посчитанные эмбеддинги по модели и хешу текста
+// (реализует model.EmbeddingCache).
+type EmbeddingCacher interface {
+	GetCachedEmbeddings(context.Context, string, []string) (map[string][]float32, error)
+	SaveCachedEmbeddings(context.Context, string, map[string][]float32) error
+}
+
+// LoaderStorer — хранилище, нужное загрузчику файлов.
+type LoaderStorer interface {
+	SourceSettinger
+	JobQueuer
+	Aliaser
+	ChunkLister
+	EmbeddingCacher
+	PromptTemplater
+}
+
+type SourceSettinger interface {
+	ListSourceSettings(context.Context) ([]types.SourceSettings, error)
+	SetSourceSettings(context.Context, types.SourceSettings) error
+	ListCleanupRuleSets(context.Context) ([]types.CleanupRuleSet, error)
+	GetCleanupRuleSet(context.Context, string) (*types.CleanupRuleSet, error)
+	SetCleanupRuleSet(context.Context, types.CleanupRuleSet) error
+}
+
 type DBStorer interface {
 	Configer
+	Crawler
+	Chatter
+	GapLogger
+	PromptTemplater
+	SourceSettinger
+	JobQueuer
+	Aliaser
+	ChunkLister
+	EmbeddingCacher
 
 	SaveDocument(context.Context, types.Document) error
 	SaveTable(context.Context, types.FullTable) error
-	GetDocumentByID(context.Context, uuid.UUID) (*types.Document, error)
+	ListDocumentsBySource(context.Context, string, string) ([]types.Document, error)
+	DeleteDocument(context.Context, uuid.UUID) error
 	SaveChunk(context.Context, types.Chunk) error
 	DeleteChunksByDocID(context.Context, uuid.UUID) error
+	DeleteStaleChunks(context.Context, uuid.UUID, []uuid.UUID, []uuid.UUID) error
 	Search(context.Context, []float32, int) ([]types.Chunk, error)
 	GetNeighbours(context.Context, uuid.UUID) ([]types.Chunk, error)
 	GetTableByID(context.Context, uuid.UUID) (*types.FullTable, error)
@@ -72,31 +183,210 @@ func (p *PostgresStore) GetTableByID(ctx context.Context, tableID uuid.UUID) (*t
 	return table, nil
 }
 
-func (p *PostgresStore) GetDocumentByID(ctx context.Context, docID uuid.UUID) (*types.Document, error) {
-	rows, err := p.pool.Query(ctx, "select * from documents where id =$1", docID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close() // Обязательно закрываем rows для освобождения соединения
-
-	if !rows.Next() {
-		return nil, sql.ErrNoRows
-	}
+const documentColumns = "id, title, source, source_path, created_at, updated_at, version, parent_id, COALESCE(metadata, '{}'), converter, collection, COALESCE(tags, '{}'), content_hash"
 
+func scanDocument(row interface{ Scan(...any) error }) (*types.Document, error) {
 	doc := &types.Document{}
-	if err := rows.Scan(
+	err := row.Scan(
 		&doc.ID,
 		&doc.Title,
 		&doc.Source,
 		&doc.SourcePath,
 		&doc.CreatedAt,
 		&doc.UpdatedAt,
-		&doc.Version); err != nil {
+		&doc.Version,
+		&doc.ParentID,
+		&doc.Metadata,
+		&doc.Converter,
+		&doc.Collection,
+		&doc.Tags,
+		&doc.ContentHash)
+	if err != nil {
 		return nil, err
 	}
 	return doc, nil
 }
 
+func (p *PostgresStore) GetDocumentByID(ctx context.Context, docID uuid.UUID) (*types.Document, error) {
+	doc, err := scanDocument(p.pool.QueryRow(ctx, "SELECT "+documentColumns+" FROM documents WHERE id = $1", docID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, sql.ErrNoRows
+	}
+	return doc, err
+}
+
+// ListDocumentsBySource возвращает документы источника, чей source_path начинается с prefix.
+func (p *PostgresStore) ListDocumentsBySource(ctx context.Context, source, prefix string) ([]types.Document, error) {
+	query := "SELECT " + documentColumns + " FROM documents WHERE source = $1 AND starts_with(source_path, $2)"
+	rows, err := p.pool.Query(ctx, query, source, prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var docs []types.Document
+	for rows.Next() {
+		doc, err := scanDocument(rows)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, *doc)
+	}
+	return docs, rows.Err()
+}
+
+// DeleteDocument удаляет документ вместе с его чанками и таблицами.
+func (p *PostgresStore) DeleteDocument(ctx context.Context, docID uuid.UUID) error {
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := deleteDocumentTx(ctx, tx, docID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func deleteDocumentTx(ctx context.Context, tx pgx.Tx, docID uuid.UUID) error {
+	for _, query := range []string{
+		"DELETE FROM chunks WHERE doc_id = $1",
+		"DELETE FROM tables WHERE doc_id = $1",
+		"DELETE FROM document_paths WHERE doc_id = $1",
+		"DELETE FROM documents WHERE id = $1",
+	} {
+		if _, err := tx.Exec(ctx, query, docID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteOrphanTx удаляет документ, если на него не ссылается ни один путь.
+func deleteOrphanTx(ctx context.Context, tx pgx.Tx, docID uuid.UUID) (bool, error) {
+	var referenced bool
+	err := tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM document_paths WHERE doc_id = $1)", docID).Scan(&referenced)
+	if err != nil || referenced {
+		return false, err
+	}
+	return true, deleteDocumentTx(ctx, tx, docID)
+}
+
+// GetDocumentPath возвращает документ, на который указывает путь.
+func (p *PostgresStore) GetDocumentPath(ctx context.Context, path string) (uuid.UUID, error) {
+	var id uuid.UUID
+	err := p.pool.QueryRow(ctx, "SELECT doc_id FROM document_paths WHERE path = $1", path).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return id, sql.ErrNoRows
+	}
+	return id, err
+}
+
+func (p *PostgresStore) CountDocumentPaths(ctx context.Context, docID uuid.UUID) (int, error) {
+	var n int
+	err := p.pool.QueryRow(ctx, "SELECT count(*) FROM document_paths WHERE doc_id = $1", docID).Scan(&n)
+	return n, err
+}
+
+// SetDocumentPath направляет путь на документ и обновляет source_path документа.
+// Документ, на который путь указывал раньше, удаляется, если других путей
+// к нему не осталось (файл заменён новой версией).
+func (p *PostgresStore) SetDocumentPath(ctx context.Context, dp types.DocumentPath) error {
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var prev uuid.UUID
+	err = tx.QueryRow(ctx, "SELECT doc_id FROM document_paths WHERE path = $1 FOR UPDATE", dp.Path).Scan(&prev)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `INSERT INTO document_paths (path, doc_id, source, updated_at)
+		VALUES ($1, $2, $3, now())
+		ON CONFLICT (path) DO UPDATE SET
+			doc_id = EXCLUDED.doc_id,
+			source = EXCLUDED.source,
+			updated_at = now()`,
+		dp.Path, dp.DocID, dp.Source)
+	if err != nil {
+		return err
+	}
+	if dp.SourcePath != "" {
+		if _, err := tx.Exec(ctx, "UPDATE documents SET source_path = $1 WHERE id = $2", dp.SourcePath, dp.DocID); err != nil {
+			return err
+		}
+	}
+
+	if prev != uuid.Nil && prev != dp.DocID {
+		if _, err := deleteOrphanTx(ctx, tx, prev); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// ListDocumentPaths возвращает пути источника, начинающиеся с prefix.
+func (p *PostgresStore) ListDocumentPaths(ctx context.Context, source, prefix string) ([]types.DocumentPath, error) {
+	rows, err := p.pool.Query(ctx,
+		"SELECT path, doc_id, source, updated_at FROM document_paths WHERE source = $1 AND starts_with(path, $2)",
+		source, prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var paths []types.DocumentPath
+	for rows.Next() {
+		var dp types.DocumentPath
+		if err := rows.Scan(&dp.Path, &dp.DocID, &dp.Source, &dp.UpdatedAt); err != nil {
+			return nil, err
+		}
+		paths = append(paths, dp)
+	}
+	return paths, rows.Err()
+}
+
+// DeleteDocumentPath удаляет путь и документ, если других путей к нему не осталось.
+func (p *PostgresStore) DeleteDocumentPath(ctx context.Context, path string) error {
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var docID uuid.UUID
+	err = tx.QueryRow(ctx, "DELETE FROM document_paths WHERE path = $1 RETURNING doc_id", path).Scan(&docID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := deleteOrphanTx(ctx, tx, docID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// DeleteOrphanDocument удаляет документ, если на него не ссылается ни один путь.
+func (p *PostgresStore) DeleteOrphanDocument(ctx context.Context, docID uuid.UUID) (bool, error) {
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	deleted, err := deleteOrphanTx(ctx, tx, docID)
+	if err != nil {
+		return false, err
+	}
+	return deleted, tx.Commit(ctx)
+}
+
 func (p *PostgresStore) DeleteChunksByDocID(ctx context.Context, docID uuid.UUID) error {
 	_, err := p.pool.Exec(ctx, "DELETE FROM chunks WHERE doc_id = $1", docID)
 	// if err != nil {
@@ -105,27 +395,85 @@ func (p *PostgresStore) DeleteChunksByDocID(ctx context.Context, docID uuid.UUID
 	return err
 }
 
-func (p *PostgresStore) GetConfig(ctx context.Context, id int) (types.LLMConfig, error) {
-	var cfg types.LLMConfig
-	rows, err := p.pool.Query(ctx, "select * from config where id =$1", id)
-	if err != nil {
-		return cfg, err
+// DeleteStaleChunks удаляет чанки и таблицы документа, которых нет
+// в новой версии: всё, кроме chunkIDs и tableIDs.
+func (p *PostgresStore) DeleteStaleChunks(ctx context.Context, docID uuid.UUID, chunkIDs, tableIDs []uuid.UUID) error {
+	if chunkIDs == nil {
+		chunkIDs = []uuid.UUID{}
 	}
-	defer rows.Close() // Обязательно закрываем rows для освобождения соединения
-
-	if !rows.Next() {
-		return cfg, sql.ErrNoRows
+	if tableIDs == nil {
+		tableIDs = []uuid.UUID{}
 	}
+	if _, err := p.pool.Exec(ctx, "DELETE FROM chunks WHERE doc_id = $1 AND NOT (id = ANY($2))", docID, chunkIDs); err != nil {
+		return err
+	}
+	_, err := p.pool.Exec(ctx, "DELETE FROM tables WHERE doc_id = $1 AND NOT (id = ANY($2))", docID, tableIDs)
+	return err
+}
 
-	if err := rows.Scan(
-		&id,
+const configColumns = "id, COALESCE(name, ''), provider, COALESCE(llm_url, ''), COALESCE(llm_model, ''), COALESCE(prompt_str, ''), api_key_ref, context_window, max_answer_tokens"
+
+func scanConfig(row interface{ Scan(...any) error }) (types.LLMConfig, error) {
+	var cfg types.LLMConfig
+	err := row.Scan(
+		&cfg.ID,
+		&cfg.Name,
+		&cfg.Provider,
 		&cfg.Url,
 		&cfg.Model,
-		&cfg.PromptStr); err != nil {
-		return cfg, err
+		&cfg.PromptStr,
+		&cfg.APIKeyRef,
+		&cfg.ContextWindow,
+		&cfg.MaxAnswerTokens)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return cfg, sql.ErrNoRows
 	}
+	return cfg, err
+}
 
-	return cfg, nil
+func (p *PostgresStore) GetConfig(ctx context.Context, id int) (types.LLMConfig, error) {
+	return scanConfig(p.pool.QueryRow(ctx, "SELECT "+configColumns+" FROM config WHERE id = $1", id))
+}
+
+// GetProfile возвращает профиль LLM по имени.
+func (p *PostgresStore) GetProfile(ctx context.Context, name string) (types.LLMConfig, error) {
+	return scanConfig(p.pool.QueryRow(ctx, "SELECT "+configColumns+" FROM config WHERE name = $1", name))
+}
+
+func (p *PostgresStore) ListProfiles(ctx context.Context) ([]types.LLMConfig, error) {
+	rows, err := p.pool.Query(ctx, "SELECT "+configColumns+" FROM config ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var profiles []types.LLMConfig
+	for rows.Next() {
+		cfg, err := scanConfig(rows)
+		if err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, cfg)
+	}
+	return profiles, rows.Err()
+}
+
+// SetProfile создаёт или обновляет профиль по имени. Новый профиль
+// получает следующий свободный id.
+func (p *PostgresStore) SetProfile(ctx context.Context, cfg types.LLMConfig) (types.LLMConfig, error) {
+	query := `
+		INSERT INTO config (name, provider, llm_url, llm_model, prompt_str, api_key_ref, context_window, max_answer_tokens)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (name) DO UPDATE SET
+			provider = EXCLUDED.provider,
+			llm_url = EXCLUDED.llm_url,
+			llm_model = EXCLUDED.llm_model,
+			prompt_str = EXCLUDED.prompt_str,
+			api_key_ref = EXCLUDED.api_key_ref,
+			context_window = EXCLUDED.context_window,
+			max_answer_tokens = EXCLUDED.max_answer_tokens
+		RETURNING ` + configColumns
+	return scanConfig(p.pool.QueryRow(ctx, query, cfg.Name, cfg.Provider, cfg.Url, cfg.Model, cfg.PromptStr, cfg.APIKeyRef, cfg.ContextWindow, cfg.MaxAnswerTokens))
 }
 
 func (p *PostgresStore) SetConfig(ctx context.Context, id int, querySet map[string]any) (types.ConfigParams, error) {
@@ -145,15 +493,18 @@ func (p *PostgresStore) SetConfig(ctx context.Context, id int, querySet map[stri
 	Update config 
 	SET %s
 	WHERE id=$%d 
-	RETURNING id, llm_url, llm_model, prompt_str
+	RETURNING id, COALESCE(name, ''), provider, COALESCE(llm_url, ''), COALESCE(llm_model, ''), COALESCE(prompt_str, ''), api_key_ref
 	`, strings.Join(setClauses, ", "), argPos)
 
 	updCfg := types.ConfigParams{}
 	err := p.pool.QueryRow(ctx, query, args...).Scan(
 		&id,
+		&updCfg.Name,
+		&updCfg.Provider,
 		&updCfg.Url,
 		&updCfg.Model,
-		&updCfg.PromptStr)
+		&updCfg.PromptStr,
+		&updCfg.APIKeyRef)
 
 	if err != nil {
 		fmt.Println("no rows found")
@@ -165,14 +516,20 @@ func (p *PostgresStore) SetConfig(ctx context.Context, id int, querySet map[stri
 }
 
 func (p *PostgresStore) SaveDocument(ctx context.Context, doc types.Document) error {
-	query := `INSERT INTO documents (id, title, source, source_path, created_at, updated_at, version)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	query := `INSERT INTO documents (id, title, source, source_path, created_at, updated_at, version, parent_id, metadata, converter, collection, tags, content_hash)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
 		ON CONFLICT (id) DO UPDATE SET
 			title = EXCLUDED.title,
 			source = EXCLUDED.source,
 			source_path = EXCLUDED.source_path,
 			updated_at = EXCLUDED.updated_at,
-			version = EXCLUDED.version
+			version = EXCLUDED.version,
+			parent_id = EXCLUDED.parent_id,
+			metadata = EXCLUDED.metadata,
+			converter = EXCLUDED.converter,
+			collection = EXCLUDED.collection,
+			tags = EXCLUDED.tags,
+			content_hash = EXCLUDED.content_hash
 			`
 	_, err := p.pool.Exec(
 		ctx,
@@ -184,6 +541,12 @@ func (p *PostgresStore) SaveDocument(ctx context.Context, doc types.Document) er
 		doc.CreatedAt,
 		doc.UpdatedAt,
 		doc.Version,
+		doc.ParentID,
+		doc.Metadata,
+		doc.Converter,
+		doc.Collection,
+		doc.Tags,
+		doc.ContentHash,
 	)
 
 	return err
@@ -209,10 +572,23 @@ func (p *PostgresStore) SaveTable(ctx context.Context, t types.FullTable) error
 	return err
 }
 
+// SaveChunk сохраняет чанк. Чанк, взятый из предыдущей версии документа,
+// сохраняет свой id и переносится в новую версию.
 func (p *PostgresStore) SaveChunk(ctx context.Context, c types.Chunk) error {
 	query := `
-    INSERT INTO chunks (id, doc_id, index, coherence_prev, type, section, key, table_id, content, embedding)
-    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+    INSERT INTO chunks (id, doc_id, index, coherence_prev, type, section, key, table_id, content, embedding, content_hash)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+    ON CONFLICT (id) DO UPDATE SET
+		doc_id = EXCLUDED.doc_id,
+		index = EXCLUDED.index,
+		coherence_prev = EXCLUDED.coherence_prev,
+		type = EXCLUDED.type,
+		section = EXCLUDED.section,
+		key = EXCLUDED.key,
+		table_id = EXCLUDED.table_id,
+		content = EXCLUDED.content,
+		embedding = EXCLUDED.embedding,
+		content_hash = EXCLUDED.content_hash
     `
 	_, err := p.pool.Exec(ctx, query,
 		c.ID,
@@ -225,6 +601,7 @@ func (p *PostgresStore) SaveChunk(ctx context.Context, c types.Chunk) error {
 		c.TableID,
 		c.Content,
 		fmt.Sprintf("[%s]", toPgVector(c.Embedding)),
+		c.ContentHash,
 	)
 
 	return err
@@ -239,6 +616,90 @@ func toPgVector(v []float32) string {
 	return strings.Join(parts, ",")
 }
 
+func (p *PostgresStore) ListChunksByDocID(ctx context.Context, docID uuid.UUID) ([]types.Chunk, error) {
+	query := `
+		SELECT id, doc_id, index, type, COALESCE(key, ''), table_id, content, content_hash, embedding::text
+		FROM chunks
+		WHERE doc_id = $1
+		ORDER BY index
+	`
+	rows, err := p.pool.Query(ctx, query, docID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chunks []types.Chunk
+	for rows.Next() {
+		var chunk types.Chunk
+		var embedding sql.NullString
+		err := rows.Scan(
+			&chunk.ID,
+			&chunk.DocID,
+			&chunk.Index,
+			&chunk.Type,
+			&chunk.Key,
+			&chunk.TableID,
+			&chunk.Content,
+			&chunk.ContentHash,
+			&embedding)
+		if err != nil {
+			return nil, err
+		}
+		// Чанк без эмбеддинга не переиспользуется
+		if embedding.Valid {
+			var v pgvector.Vector
+			if err := v.Parse(embedding.String); err != nil {
+				return nil, err
+			}
+			chunk.Embedding = v.Slice()
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks, rows.Err()
+}
+
+func (p *PostgresStore) GetCachedEmbeddings(ctx context.Context, model string, hashes []string) (map[string][]float32, error) {
+	rows, err := p.pool.Query(ctx,
+		"SELECT hash, embedding::text FROM embedding_cache WHERE model = $1 AND hash = ANY($2)",
+		model, hashes)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make(map[string][]float32, len(hashes))
+	for rows.Next() {
+		var hash, embedding string
+		if err := rows.Scan(&hash, &embedding); err != nil {
+			return nil, err
+		}
+		var v pgvector.Vector
+		if err := v.Parse(embedding); err != nil {
+			return nil, err
+		}
+		res[hash] = v.Slice()
+	}
+	return res, rows.Err()
+}
+
+func (p *PostgresStore) SaveCachedEmbeddings(ctx context.Context, model string, embeddings map[string][]float32) error {
+	batch := &pgx.Batch{}
+	for hash, emb := range embeddings {
+		if len(emb) == 0 {
+			continue
+		}
+		batch.Queue(`INSERT INTO embedding_cache (model, hash, embedding)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (model, hash) DO NOTHING`,
+			model, hash, fmt.Sprintf("[%s]", toPgVector(emb)))
+	}
+	if batch.Len() == 0 {
+		return nil
+	}
+	return p.pool.SendBatch(ctx, batch).Close()
+}
+
 func (p *PostgresStore) GetNeighbours(ctx context.Context, chunkIndex uuid.UUID) ([]types.Chunk, error) {
 	query := `
 			SELECT id, doc_id, index, coherence_prev, coherence_next, content
@@ -285,7 +746,7 @@ func (p *PostgresStore) Search(ctx context.Context, queryVec []float32, limit in
 	vector := pgvector.NewVector(queryVec)
 
 	query := `
-		SELECT pc.id, pc.doc_id, pc.index, pc.type, pc.key, pc.table_id, pc.coherence_prev, pc.coherence_next, pc.content,
+		SELECT pc.id, pc.doc_id, pc.index, pc.type, COALESCE(pc.section, ''), pc.key, pc.table_id, pc.coherence_prev, pc.coherence_next, pc.content,
 		       1-(pc.embedding <=> $1) as distance
 		FROM chunks pc
 		JOIN documents doc ON pc.doc_id = doc.id
@@ -307,6 +768,7 @@ func (p *PostgresStore) Search(ctx context.Context, queryVec []float32, limit in
 			&chunk.DocID,
 			&chunk.Index,
 			&chunk.Type,
+			&chunk.Section,
 			&chunk.Key,
 			&chunk.TableID,
 			&chunk.CohPrev,
@@ -328,6 +790,619 @@ func (p *PostgresStore) Search(ctx context.Context, queryVec []float32, limit in
 	return chunks, nil
 }
 
+const crawlColumns = "id, seed_url, max_depth, max_pages, status, pages, error, created_at, updated_at"
+
+func scanCrawl(row interface{ Scan(...any) error }) (*types.Crawl, error) {
+	crawl := &types.Crawl{}
+	err := row.Scan(
+		&crawl.ID,
+		&crawl.SeedURL,
+		&crawl.MaxDepth,
+		&crawl.MaxPages,
+		&crawl.Status,
+		&crawl.Pages,
+		&crawl.Error,
+		&crawl.CreatedAt,
+		&crawl.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return crawl, nil
+}
+
+func (p *PostgresStore) CreateCrawl(ctx context.Context, c types.Crawl) (*types.Crawl, error) {
+	query := `INSERT INTO crawls (id, seed_url, max_depth, max_pages, status)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING ` + crawlColumns
+	return scanCrawl(p.pool.QueryRow(ctx, query, c.ID, c.SeedURL, c.MaxDepth, c.MaxPages, types.CrawlPending))
+}
+
+func (p *PostgresStore) GetCrawl(ctx context.Context, id uuid.UUID) (*types.Crawl, error) {
+	crawl, err := scanCrawl(p.pool.QueryRow(ctx, "SELECT "+crawlColumns+" FROM crawls WHERE id = $1", id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, sql.ErrNoRows
+	}
+	return crawl, err
+}
+
+// ClaimCrawl забирает самую старую ожидающую заявку или выполняющуюся
+// с истёкшей арендой (загрузчик остановился, не завершив обход) и переводит
+// её в running с арендой на lease. Если заявок нет, возвращает sql.ErrNoRows.
+func (p *PostgresStore) ClaimCrawl(ctx context.Context, lease time.Duration) (*types.Crawl, error) {
+	query := `
+		UPDATE crawls SET status = $1, pages = 0, error = '', locked_until = now() + $2 * interval '1 second', updated_at = now()
+		WHERE id = (
+			SELECT id FROM crawls
+			WHERE status = $3
+				OR (status = $1 AND (locked_until IS NULL OR locked_until < now()))
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + crawlColumns
+	crawl, err := scanCrawl(p.pool.QueryRow(ctx, query, types.CrawlRunning, lease.Seconds(), types.CrawlPending))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, sql.ErrNoRows
+	}
+	return crawl, err
+}
+
+// ExtendCrawl продлевает аренду выполняющейся заявки на lease.
+func (p *PostgresStore) ExtendCrawl(ctx context.Context, id uuid.UUID, lease time.Duration) error {
+	_, err := p.pool.Exec(ctx, `
+		UPDATE crawls SET locked_until = now() + $1 * interval '1 second', updated_at = now()
+		WHERE id = $2 AND status = $3`,
+		lease.Seconds(), id, types.CrawlRunning)
+	return err
+}
+
+// ReleaseCrawl возвращает прерванную заявку в очередь.
+func (p *PostgresStore) ReleaseCrawl(ctx context.Context, id uuid.UUID) error {
+	_, err := p.pool.Exec(ctx, `
+		UPDATE crawls SET status = $1, locked_until = NULL, updated_at = now()
+		WHERE id = $2 AND status = $3`,
+		types.CrawlPending, id, types.CrawlRunning)
+	return err
+}
+
+func (p *PostgresStore) FinishCrawl(ctx context.Context, id uuid.UUID, pages int, crawlErr error) error {
+	status, msg := types.CrawlDone, ""
+	if crawlErr != nil {
+		status, msg = types.CrawlFailed, crawlErr.Error()
+	}
+	_, err := p.pool.Exec(ctx,
+		"UPDATE crawls SET status = $1, pages = $2, error = $3, locked_until = NULL, updated_at = now() WHERE id = $4",
+		status, pages, msg, id)
+	return err
+}
+
+const chatSessionColumns = "id, title, profile, created_at, updated_at"
+
+func scanChatSession(row interface{ Scan(...any) error }) (*types.ChatSession, error) {
+	session := &types.ChatSession{}
+	err := row.Scan(
+		&session.ID,
+		&session.Title,
+		&session.Profile,
+		&session.CreatedAt,
+		&session.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+func (p *PostgresStore) CreateChatSession(ctx context.Context, cs types.ChatSession) (*types.ChatSession, error) {
+	query := `INSERT INTO chat_sessions (id, title, profile)
+		VALUES ($1, $2, $3)
+		RETURNING ` + chatSessionColumns
+	return scanChatSession(p.pool.QueryRow(ctx, query, cs.ID, cs.Title, cs.Profile))
+}
+
+func (p *PostgresStore) GetChatSession(ctx context.Context, id uuid.UUID) (*types.ChatSession, error) {
+	session, err := scanChatSession(p.pool.QueryRow(ctx, "SELECT "+chatSessionColumns+" FROM chat_sessions WHERE id = $1", id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, sql.ErrNoRows
+	}
+	return session, err
+}
+
+// ListChatSessions возвращает последние диалоги, недавно продолженные — первыми.
+func (p *PostgresStore) ListChatSessions(ctx context.Context, limit int) ([]types.ChatSession, error) {
+	rows, err := p.pool.Query(ctx, "SELECT "+chatSessionColumns+" FROM chat_sessions ORDER BY updated_at DESC LIMIT $1", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []types.ChatSession{}
+	for rows.Next() {
+		session, err := scanChatSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *session)
+	}
+	return sessions, rows.Err()
+}
+
+// DeleteChatSession удаляет диалог вместе с сообщениями. Если диалога нет,
+// возвращает sql.ErrNoRows.
+func (p *PostgresStore) DeleteChatSession(ctx context.Context, id uuid.UUID) error {
+	tag, err := p.pool.Exec(ctx, "DELETE FROM chat_sessions WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+const chatMessageColumns = "id, session_id, role, content, query, COALESCE(sources, '[]'), confidence, grounding, answered, COALESCE(suggestions, '[]'), prompt, budget, language, created_at"
+
+func scanChatMessage(row interface{ Scan(...any) error }) (*types.ChatMessage, error) {
+	msg := &types.ChatMessage{}
+	err := row.Scan(
+		&msg.ID,
+		&msg.SessionID,
+		&msg.Role,
+		&msg.Content,
+		&msg.Query,
+		&msg.Sources,
+		&msg.Confidence,
+		&msg.Grounding,
+		&msg.Answered,
+		&msg.Suggestions,
+		&msg.Prompt,
+		&msg.Budget,
+		&msg.LanguageInfo,
+		&msg.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// AddChatMessages сохраняет реплики одного диалога в одной транзакции
+// и отмечает время его продолжения. Диалог без названия получает
+// в качестве названия первый вопрос.
+func (p *PostgresStore) AddChatMessages(ctx context.Context, msgs ...types.ChatMessage) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `INSERT INTO chat_messages (id, session_id, role, content, query, sources, confidence, grounding, answered, suggestions, prompt, budget, language, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`
+	for _, m := range msgs {
+		_, err := tx.Exec(ctx, query, m.ID, m.SessionID, m.Role, m.Content, m.Query, m.Sources, m.Confidence, m.Grounding, m.Answered, m.Suggestions, m.Prompt, m.Budget, m.LanguageInfo, m.CreatedAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	title := ""
+	if msgs[0].Role == types.ChatRoleUser {
+		title = msgs[0].Content
+	}
+	_, err = tx.Exec(ctx, `UPDATE chat_sessions
+		SET updated_at = now(), title = CASE WHEN title = '' THEN left($2, 80) ELSE title END
+		WHERE id = $1`, msgs[0].SessionID, title)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// ListChatMessages возвращает последние limit сообщений диалога
+// в хронологическом порядке; limit 0 — все сообщения.
+func (p *PostgresStore) ListChatMessages(ctx context.Context, sessionID uuid.UUID, limit int) ([]types.ChatMessage, error) {
+	query := `SELECT * FROM (
+			SELECT ` + chatMessageColumns + ` FROM chat_messages
+			WHERE session_id = $1
+			ORDER BY created_at DESC, seq DESC
+			LIMIT NULLIF($2, 0)
+		) m ORDER BY created_at, seq`
+	rows, err := p.pool.Query(ctx, query, sessionID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	msgs := []types.ChatMessage{}
+	for rows.Next() {
+		msg, err := scanChatMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, *msg)
+	}
+	return msgs, rows.Err()
+}
+
+func (p *PostgresStore) LogUnansweredQuestion(ctx context.Context, q types.UnansweredQuestion) error {
+	_, err := p.pool.Exec(ctx, `INSERT INTO unanswered_questions (id, question, query, profile, session_id, best_distance)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		q.ID, q.Question, q.Query, q.Profile, q.SessionID, q.BestDistance)
+	return err
+}
+
+// ListUnansweredQuestions возвращает последние вопросы без ответа.
+func (p *PostgresStore) ListUnansweredQuestions(ctx context.Context, limit int) ([]types.UnansweredQuestion, error) {
+	rows, err := p.pool.Query(ctx, `SELECT id, question, query, profile, session_id, best_distance, created_at
+		FROM unanswered_questions ORDER BY created_at DESC LIMIT $1`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	questions := []types.UnansweredQuestion{}
+	for rows.Next() {
+		var q types.UnansweredQuestion
+		err := rows.Scan(
+			&q.ID,
+			&q.Question,
+			&q.Query,
+			&q.Profile,
+			&q.SessionID,
+			&q.BestDistance,
+			&q.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		questions = append(questions, q)
+	}
+	return questions, rows.Err()
+}
+
+const promptTemplateColumns = "name, version, body, description, created_at, deleted_at"
+
+func scanPromptTemplate(row interface{ Scan(...any) error }) (*types.PromptTemplate, error) {
+	tmpl := &types.PromptTemplate{}
+	err := row.Scan(
+		&tmpl.Name,
+		&tmpl.Version,
+		&tmpl.Body,
+		&tmpl.Description,
+		&tmpl.CreatedAt,
+		&tmpl.DeletedAt)
+	if err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// GetPromptTemplate возвращает версию шаблона, version 0 — последнюю
+// неудалённую. Удалённая версия возвращается только по номеру.
+// Если такой версии нет, возвращает sql.ErrNoRows.
+func (p *PostgresStore) GetPromptTemplate(ctx context.Context, name string, version int) (*types.PromptTemplate, error) {
+	query := "SELECT " + promptTemplateColumns + ` FROM prompt_templates
+		WHERE name = $1 AND (version = $2 OR ($2 = 0 AND deleted_at IS NULL))
+		ORDER BY version DESC LIMIT 1`
+	tmpl, err := scanPromptTemplate(p.pool.QueryRow(ctx, query, name, version))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, sql.ErrNoRows
+	}
+	return tmpl, err
+}
+
+// ListPromptTemplates возвращает последние неудалённые версии всех сохранённых шаблонов.
+func (p *PostgresStore) ListPromptTemplates(ctx context.Context) ([]types.PromptTemplate, error) {
+	query := "SELECT DISTINCT ON (name) " + promptTemplateColumns + " FROM prompt_templates WHERE deleted_at IS NULL ORDER BY name, version DESC"
+	return p.queryPromptTemplates(ctx, query)
+}
+
+// ListPromptTemplateVersions возвращает все версии шаблона, включая удалённые,
+// новые первыми.
+func (p *PostgresStore) ListPromptTemplateVersions(ctx context.Context, name string) ([]types.PromptTemplate, error) {
+	query := "SELECT " + promptTemplateColumns + " FROM prompt_templates WHERE name = $1 ORDER BY version DESC"
+	return p.queryPromptTemplates(ctx, query, name)
+}
+
+func (p *PostgresStore) queryPromptTemplates(ctx context.Context, query string, args ...any) ([]types.PromptTemplate, error) {
+	rows, err := p.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	templates := []types.PromptTemplate{}
+	for rows.Next() {
+		tmpl, err := scanPromptTemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, *tmpl)
+	}
+	return templates, rows.Err()
+}
+
+// SavePromptTemplate сохраняет шаблон следующей версией. Номера удалённых
+// версий не переиспользуются. Одновременные сохранения одного шаблона
+// выполняются по очереди под advisory-блокировкой по имени, иначе обе
+// транзакции получили бы один номер версии.
+func (p *PostgresStore) SavePromptTemplate(ctx context.Context, t types.PromptTemplate) (*types.PromptTemplate, error) {
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext('prompt_templates:' || $1))", t.Name); err != nil {
+		return nil, err
+	}
+
+	query := `INSERT INTO prompt_templates (name, version, body, description)
+		SELECT $1, COALESCE(MAX(version), 0) + 1, $2, $3 FROM prompt_templates WHERE name = $1
+		RETURNING ` + promptTemplateColumns
+	tmpl, err := scanPromptTemplate(tx.QueryRow(ctx, query, t.Name, t.Body, t.Description))
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// DeletePromptTemplate помечает версию шаблона удалённой; version 0 — все
+// версии, после чего снова действует встроенный. Строки остаются, чтобы
+// номера не переиспользовались, а сохранённые ответы ссылались на свой текст.
+// Если неудалённых версий нет, возвращает sql.ErrNoRows.
+func (p *PostgresStore) DeletePromptTemplate(ctx context.Context, name string, version int) error {
+	query := `UPDATE prompt_templates SET deleted_at = now()
+		WHERE name = $1 AND ($2 = 0 OR version = $2) AND deleted_at IS NULL`
+	tag, err := p.pool.Exec(ctx, query, name, version)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+const jobColumns = "id, path, source, state, stage, attempts, last_error, bad_path, timings, chunks_reused, chunks_computed, next_run_at, created_at, updated_at, started_at, finished_at"
+
+func scanJob(row interface{ Scan(...any) error }) (*types.Job, error) {
+	job := &types.Job{}
+	err := row.Scan(
+		&job.ID,
+		&job.Path,
+		&job.Source,
+		&job.State,
+		&job.Stage,
+		&job.Attempts,
+		&job.LastError,
+		&job.BadPath,
+		&job.Timings,
+		&job.Chunks.Reused,
+		&job.Chunks.Computed,
+		&job.NextRunAt,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+		&job.StartedAt,
+		&job.FinishedAt)
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// EnqueueJob ставит файл в очередь. Если для файла уже есть активное
+// задание (queued или processing), возвращается оно.
+func (p *PostgresStore) EnqueueJob(ctx context.Context, path, source string) (*types.Job, error) {
+	query := `INSERT INTO jobs (id, path, source, state)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (path) WHERE state IN ('queued', 'processing') DO NOTHING
+		RETURNING ` + jobColumns
+	job, err := scanJob(p.pool.QueryRow(ctx, query, uuid.New(), path, source, types.JobQueued))
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return job, err
+	}
+
+	query = "SELECT " + jobColumns + " FROM jobs WHERE path = $1 AND state IN ('queued', 'processing')"
+	return scanJob(p.pool.QueryRow(ctx, query, path))
+}
+
+// ClaimJob забирает задание, готовое к выполнению, и увеличивает счётчик попыток.
+// Задание в processing, чья аренда истекла (загрузчик упал), забирается повторно.
+// Если заданий нет, возвращает sql.ErrNoRows.
+func (p *PostgresStore) ClaimJob(ctx context.Context, lease time.Duration) (*types.Job, error) {
+	query := `
+		UPDATE jobs SET state = $1, stage = $4, attempts = attempts + 1, locked_until = now() + $2 * interval '1 second',
+			started_at = now(), finished_at = NULL, updated_at = now()
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE (state = $3 AND next_run_at <= now())
+				OR (state = $1 AND locked_until < now())
+			ORDER BY next_run_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + jobColumns
+	job, err := scanJob(p.pool.QueryRow(ctx, query, types.JobProcessing, lease.Seconds(), types.JobQueued, types.JobStageStarted))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, sql.ErrNoRows
+	}
+	return job, err
+}
+
+func (p *PostgresStore) CompleteJob(ctx context.Context, id uuid.UUID, timings map[string]int64, stats types.ChunkStats) error {
+	_, err := p.pool.Exec(ctx, `
+		UPDATE jobs SET state = $1, stage = $6, last_error = '', timings = $2, chunks_reused = $3, chunks_computed = $4,
+			locked_until = NULL, finished_at = now(), updated_at = now()
+		WHERE id = $5`,
+		types.JobDone, timings, stats.Reused, stats.Computed, id, types.JobStageSaved)
+	return err
+}
+
+// RetryJob возвращает задание в очередь с выполнением не раньше at.
+func (p *PostgresStore) RetryJob(ctx context.Context, id uuid.UUID, jobErr error, at time.Time, timings map[string]int64) error {
+	_, err := p.pool.Exec(ctx, `
+		UPDATE jobs SET state = $1, stage = $6, last_error = $2, next_run_at = $3, timings = $4, locked_until = NULL,
+			finished_at = now(), updated_at = now()
+		WHERE id = $5`,
+		types.JobQueued, jobErr.Error(), at, timings, id, types.JobStageQueued)
+	return err
+}
+
+// FailJob переводит задание в dead; badPath — куда перемещён исходный файл.
+func (p *PostgresStore) FailJob(ctx context.Context, id uuid.UUID, jobErr error, badPath string, timings map[string]int64) error {
+	_, err := p.pool.Exec(ctx, `
+		UPDATE jobs SET state = $1, stage = $6, last_error = $2, bad_path = $3, timings = $4, locked_until = NULL,
+			finished_at = now(), updated_at = now()
+		WHERE id = $5`,
+		types.JobDead, jobErr.Error(), badPath, timings, id, types.JobStageFailed)
+	return err
+}
+
+// ReleaseJob возвращает прерванное задание в очередь, не засчитывая попытку.
+func (p *PostgresStore) ReleaseJob(ctx context.Context, id uuid.UUID) error {
+	_, err := p.pool.Exec(ctx, `
+		UPDATE jobs SET state = $1, stage = $4, attempts = GREATEST(attempts - 1, 0), locked_until = NULL, updated_at = now()
+		WHERE id = $2 AND state = $3`,
+		types.JobQueued, id, types.JobProcessing, types.JobStageQueued)
+	return err
+}
+
+func (p *PostgresStore) GetJob(ctx context.Context, id uuid.UUID) (*types.Job, error) {
+	job, err := scanJob(p.pool.QueryRow(ctx, "SELECT "+jobColumns+" FROM jobs WHERE id = $1", id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, sql.ErrNoRows
+	}
+	return job, err
+}
+
+// SetJobStage отмечает текущий этап выполняющегося задания.
+func (p *PostgresStore) SetJobStage(ctx context.Context, id uuid.UUID, stage types.JobStage) error {
+	_, err := p.pool.Exec(ctx, `
+		UPDATE jobs SET stage = $1, updated_at = now()
+		WHERE id = $2 AND state = $3`,
+		stage, id, types.JobProcessing)
+	return err
+}
+
+// ListJobs возвращает последние задания; пустой state — задания в любом состоянии.
+func (p *PostgresStore) ListJobs(ctx context.Context, state types.JobState, limit int) ([]types.Job, error) {
+	rows, err := p.pool.Query(ctx, `
+		SELECT `+jobColumns+` FROM jobs
+		WHERE $1 = '' OR state = $1
+		ORDER BY updated_at DESC
+		LIMIT $2`,
+		string(state), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []types.Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, *job)
+	}
+	return jobs, rows.Err()
+}
+
+// RequeueJob возвращает задание из dead в очередь со сброшенным счётчиком попыток.
+// Если задания нет или оно не в dead, возвращает sql.ErrNoRows; если у файла
+// уже есть активное задание (idx_jobs_active_path) — ErrActiveJob.
+func (p *PostgresStore) RequeueJob(ctx context.Context, id uuid.UUID) (*types.Job, error) {
+	query := `
+		UPDATE jobs SET state = $1, stage = $4, attempts = 0, next_run_at = now(), updated_at = now()
+		WHERE id = $2 AND state = $3
+		RETURNING ` + jobColumns
+	job, err := scanJob(p.pool.QueryRow(ctx, query, types.JobQueued, id, types.JobDead, types.JobStageQueued))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, sql.ErrNoRows
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return nil, ErrActiveJob
+	}
+	return job, err
+}
+
+func (p *PostgresStore) ListSourceSettings(ctx context.Context) ([]types.SourceSettings, error) {
+	rows, err := p.pool.Query(ctx, "SELECT dir, crop_top, crop_bottom, cleanup_rules, converter FROM source_settings ORDER BY dir")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var settings []types.SourceSettings
+	for rows.Next() {
+		var s types.SourceSettings
+		if err := rows.Scan(&s.Dir, &s.CropTop, &s.CropBottom, &s.CleanupRules, &s.Converter); err != nil {
+			return nil, err
+		}
+		settings = append(settings, s)
+	}
+	return settings, rows.Err()
+}
+
+func (p *PostgresStore) SetSourceSettings(ctx context.Context, s types.SourceSettings) error {
+	query := `INSERT INTO source_settings (dir, crop_top, crop_bottom, cleanup_rules, converter)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (dir) DO UPDATE SET
+			crop_top = EXCLUDED.crop_top,
+			crop_bottom = EXCLUDED.crop_bottom,
+			cleanup_rules = EXCLUDED.cleanup_rules,
+			converter = EXCLUDED.converter
+			`
+	_, err := p.pool.Exec(ctx, query, s.Dir, s.CropTop, s.CropBottom, s.CleanupRules, s.Converter)
+	return err
+}
+
+func (p *PostgresStore) ListCleanupRuleSets(ctx context.Context) ([]types.CleanupRuleSet, error) {
+	rows, err := p.pool.Query(ctx, "SELECT rules FROM cleanup_rules ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sets []types.CleanupRuleSet
+	for rows.Next() {
+		var set types.CleanupRuleSet
+		if err := rows.Scan(&set); err != nil {
+			return nil, err
+		}
+		sets = append(sets, set)
+	}
+	return sets, rows.Err()
+}
+
+func (p *PostgresStore) GetCleanupRuleSet(ctx context.Context, name string) (*types.CleanupRuleSet, error) {
+	var set types.CleanupRuleSet
+	err := p.pool.QueryRow(ctx, "SELECT rules FROM cleanup_rules WHERE name = $1", name).Scan(&set)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, sql.ErrNoRows
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &set, nil
+}
+
+func (p *PostgresStore) SetCleanupRuleSet(ctx context.Context, set types.CleanupRuleSet) error {
+	query := `INSERT INTO cleanup_rules (name, rules)
+		VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET
+			rules = EXCLUDED.rules
+			`
+	_, err := p.pool.Exec(ctx, query, set.Name, set)
+	return err
+}
+
 func (p *PostgresStore) createRagTables(ctx context.Context) error {
 	fmt.Println("Starting create tables...")
 	query := `
@@ -342,6 +1417,24 @@ func (p *PostgresStore) createRagTables(ctx context.Context) error {
 	);
 	CREATE INDEX IF NOT EXISTS idx_id ON documents(id);
 
+	ALTER TABLE documents ADD COLUMN IF NOT EXISTS parent_id UUID NULL;
+	ALTER TABLE documents ADD COLUMN IF NOT EXISTS metadata JSONB;
+	ALTER TABLE documents ADD COLUMN IF NOT EXISTS converter TEXT NOT NULL DEFAULT '';
+	ALTER TABLE documents ADD COLUMN IF NOT EXISTS collection TEXT NOT NULL DEFAULT '';
+	ALTER TABLE documents ADD COLUMN IF NOT EXISTS tags TEXT[];
+	CREATE INDEX IF NOT EXISTS idx_documents_collection ON documents(collection);
+	ALTER TABLE documents ADD COLUMN IF NOT EXISTS content_hash TEXT NOT NULL DEFAULT '';
+
+	-- Логические пути файлов; несколько путей могут указывать на один документ
+	CREATE TABLE IF NOT EXISTS document_paths (
+		path       TEXT PRIMARY KEY,
+		doc_id     UUID NOT NULL,
+		source     TEXT NOT NULL,
+		updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+	);
+	CREATE INDEX IF NOT EXISTS idx_document_paths_doc_id ON document_paths(doc_id);
+	CREATE INDEX IF NOT EXISTS idx_documents_parent_id ON documents(parent_id);
+
     CREATE EXTENSION IF NOT EXISTS vector;
 
     CREATE TABLE IF NOT EXISTS chunks (
@@ -358,6 +1451,17 @@ func (p *PostgresStore) createRagTables(ctx context.Context) error {
         embedding vector(1024)
     );
 
+	ALTER TABLE chunks ADD COLUMN IF NOT EXISTS content_hash TEXT NOT NULL DEFAULT '';
+
+	-- Кэш эмбеддингов: размерность не фиксирована, у разных моделей она своя
+	CREATE TABLE IF NOT EXISTS embedding_cache (
+		model      TEXT NOT NULL,
+		hash       TEXT NOT NULL,
+		embedding  vector NOT NULL,
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+		PRIMARY KEY (model, hash)
+	);
+
 	-- Индекс для быстрого поиска по вектору
 	CREATE INDEX IF NOT EXISTS idx_chunks_embedding ON chunks USING ivfflat (embedding vector_cosine_ops)
 	WITH (lists = 100);
@@ -373,6 +1477,20 @@ func (p *PostgresStore) createRagTables(ctx context.Context) error {
 		llm_model TEXT,
 		prompt_str TEXT
     );
+	-- Именованные профили LLM; ключ API хранится не в БД, а в окружении или секрете
+	ALTER TABLE config ADD COLUMN IF NOT EXISTS name TEXT;
+	ALTER TABLE config ADD COLUMN IF NOT EXISTS provider TEXT NOT NULL DEFAULT 'ollama';
+	ALTER TABLE config ADD COLUMN IF NOT EXISTS api_key_ref TEXT NOT NULL DEFAULT '';
+	ALTER TABLE config ADD COLUMN IF NOT EXISTS context_window INT NOT NULL DEFAULT 0;
+	ALTER TABLE config ADD COLUMN IF NOT EXISTS max_answer_tokens INT NOT NULL DEFAULT 0;
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_config_name ON config(name);
+	-- id новых профилей берётся из последовательности, а не max(id)+1:
+	-- параллельные вставки не получают один и тот же id
+	CREATE SEQUENCE IF NOT EXISTS config_id_seq OWNED BY config.id;
+	-- Профили, добавленные вручную с явным id, сдвигают последовательность вперёд
+	SELECT setval('config_id_seq', (SELECT max(id) FROM config))
+	WHERE (SELECT max(id) FROM config) > (SELECT CASE WHEN is_called THEN last_value ELSE last_value - 1 END FROM config_id_seq);
+	ALTER TABLE config ALTER COLUMN id SET DEFAULT nextval('config_id_seq');
 
 	CREATE TABLE IF NOT EXISTS tables (
 		id           UUID PRIMARY KEY,
@@ -380,6 +1498,109 @@ func (p *PostgresStore) createRagTables(ctx context.Context) error {
 		index  		 INT,
 		content_md   TEXT
 	);
+
+	CREATE TABLE IF NOT EXISTS crawls (
+		id         UUID PRIMARY KEY,
+		seed_url   TEXT NOT NULL,
+		max_depth  INT NOT NULL,
+		max_pages  INT NOT NULL,
+		status     TEXT NOT NULL,
+		pages      INT NOT NULL DEFAULT 0,
+		error      TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+		updated_at TIMESTAMP WITH TIME ZONE DEFAULT now()
+	);
+	CREATE INDEX IF NOT EXISTS idx_crawls_status ON crawls(status, created_at);
+	ALTER TABLE crawls ADD COLUMN IF NOT EXISTS locked_until TIMESTAMP WITH TIME ZONE;
+
+	CREATE TABLE IF NOT EXISTS source_settings (
+		dir         TEXT PRIMARY KEY,
+		crop_top    DOUBLE PRECISION NOT NULL DEFAULT 0,
+		crop_bottom DOUBLE PRECISION NOT NULL DEFAULT 0
+	);
+	ALTER TABLE source_settings ADD COLUMN IF NOT EXISTS cleanup_rules TEXT NOT NULL DEFAULT '';
+	ALTER TABLE source_settings ADD COLUMN IF NOT EXISTS converter TEXT NOT NULL DEFAULT '';
+
+	CREATE TABLE IF NOT EXISTS cleanup_rules (
+		name  TEXT PRIMARY KEY,
+		rules JSONB NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS jobs (
+		id           UUID PRIMARY KEY,
+		path         TEXT NOT NULL,
+		source       TEXT NOT NULL,
+		state        TEXT NOT NULL,
+		attempts     INT NOT NULL DEFAULT 0,
+		last_error   TEXT NOT NULL DEFAULT '',
+		bad_path     TEXT NOT NULL DEFAULT '',
+		timings      JSONB NOT NULL DEFAULT '{}',
+		next_run_at  TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+		locked_until TIMESTAMP WITH TIME ZONE,
+		created_at   TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+		updated_at   TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+		started_at   TIMESTAMP WITH TIME ZONE,
+		finished_at  TIMESTAMP WITH TIME ZONE
+	);
+	-- Не больше одного активного задания на файл
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_jobs_active_path ON jobs(path) WHERE state IN ('queued', 'processing');
+	CREATE INDEX IF NOT EXISTS idx_jobs_state ON jobs(state, next_run_at);
+	ALTER TABLE jobs ADD COLUMN IF NOT EXISTS chunks_reused INT NOT NULL DEFAULT 0;
+	ALTER TABLE jobs ADD COLUMN IF NOT EXISTS chunks_computed INT NOT NULL DEFAULT 0;
+	ALTER TABLE jobs ADD COLUMN IF NOT EXISTS stage TEXT NOT NULL DEFAULT 'queued';
+
+	CREATE TABLE IF NOT EXISTS chat_sessions (
+		id         UUID PRIMARY KEY,
+		title      TEXT NOT NULL DEFAULT '',
+		profile    TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+		updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+	);
+	CREATE INDEX IF NOT EXISTS idx_chat_sessions_updated_at ON chat_sessions(updated_at);
+
+	-- Вопрос и ответ сохраняются вместе с одинаковым created_at,
+	-- порядок между ними задаёт seq
+	CREATE TABLE IF NOT EXISTS chat_messages (
+		id         UUID PRIMARY KEY,
+		seq        BIGSERIAL,
+		session_id UUID NOT NULL REFERENCES chat_sessions(id) ON DELETE CASCADE,
+		role       TEXT NOT NULL CHECK (role IN ('user','assistant')),
+		content    TEXT NOT NULL,
+		query      TEXT NOT NULL DEFAULT '',
+		sources    JSONB,
+		confidence DOUBLE PRECISION NOT NULL DEFAULT 0,
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+	);
+	CREATE INDEX IF NOT EXISTS idx_chat_messages_session ON chat_messages(session_id, created_at, seq);
+	ALTER TABLE chat_messages ADD COLUMN IF NOT EXISTS grounding JSONB;
+	ALTER TABLE chat_messages ADD COLUMN IF NOT EXISTS answered BOOLEAN;
+	ALTER TABLE chat_messages ADD COLUMN IF NOT EXISTS suggestions JSONB;
+
+	-- Версии шаблонов промптов; версия 0 — встроенный шаблон в коде
+	CREATE TABLE IF NOT EXISTS prompt_templates (
+		name        TEXT NOT NULL,
+		version     INT NOT NULL,
+		body        TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		created_at  TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+		PRIMARY KEY (name, version)
+	);
+	ALTER TABLE prompt_templates ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE;
+	ALTER TABLE chat_messages ADD COLUMN IF NOT EXISTS prompt JSONB;
+	ALTER TABLE chat_messages ADD COLUMN IF NOT EXISTS language JSONB;
+	ALTER TABLE chat_messages ADD COLUMN IF NOT EXISTS budget JSONB;
+
+	-- Вопросы без ответа в документации: по ним видно, чего в ней не хватает
+	CREATE TABLE IF NOT EXISTS unanswered_questions (
+		id            UUID PRIMARY KEY,
+		question      TEXT NOT NULL,
+		query         TEXT NOT NULL DEFAULT '',
+		profile       TEXT NOT NULL DEFAULT '',
+		session_id    UUID,
+		best_distance DOUBLE PRECISION NOT NULL DEFAULT 0,
+		created_at    TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+	);
+	CREATE INDEX IF NOT EXISTS idx_unanswered_questions_created_at ON unanswered_questions(created_at);
     `
 	_, err := p.pool.Exec(ctx, query)
 	return err
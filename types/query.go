@@ -13,14 +13,108 @@ type Validater interface {
 }
 
 type QueryParams struct {
-	Prompt   string `json:"prompt" validate:"required"`
-	UseLocal bool   `json:"use_local"`
+	Prompt  string `json:"prompt" validate:"required"`
+	Profile string `json:"profile"` // Имя профиля LLM; пустое — профиль по умолчанию
+	// Язык ответа; пустой — язык вопроса
+	AnswerLanguage string `json:"answer_language" validate:"omitempty,oneof=russian ukrainian english spanish french german italian portuguese polish czech"`
+}
+
+// LanguageInfo — языки вопроса и ответа и перевод вопроса для поиска.
+type LanguageInfo struct {
+	QueryLanguage   string `json:"query_language"`
+	AnswerLanguage  string `json:"answer_language"`
+	TranslationUsed bool   `json:"translation_used"`
+	OriginalQuery   string `json:"original_query,omitempty"`
+	TranslatedQuery string `json:"translated_query,omitempty"` // Запрос на языке документов, по которому шёл поиск
+}
+
+type CrawlParams struct {
+	URL      string `json:"url" validate:"required,http_url"`
+	MaxDepth *int   `json:"max_depth" validate:"omitempty,gte=0,lte=5"` // nil — глубина по умолчанию, 0 — только сама страница
+	MaxPages int    `json:"max_pages" validate:"gte=0,lte=1000"`
+}
+
+// JobListParams — фильтр списка заданий загрузки.
+type JobListParams struct {
+	State string `query:"state" validate:"omitempty,oneof=queued processing done dead"`
+	Limit int    `query:"limit" validate:"gte=0,lte=1000"`
+}
+
+// SourceSettings — настройки загрузки для каталога-источника.
+type SourceSettings struct {
+	Dir        string  `json:"dir" validate:"required"`
+	CropTop    float64 `json:"crop_top" validate:"gte=0"`
+	CropBottom float64 `json:"crop_bottom" validate:"gte=0"`
+	// Имя набора правил очистки текста, пусто — без очистки
+	CleanupRules string `json:"cleanup_rules"`
+	// Конвертер PDF: docling (по умолчанию) или pdfcpu
+	Converter string `json:"converter" validate:"omitempty,oneof=docling pdfcpu"`
+}
+
+// CleanupRuleSet — именованный набор правил очистки текста после конвертации.
+type CleanupRuleSet struct {
+	Name                string        `json:"name" validate:"required"`
+	Replace             []ReplaceRule `json:"replace" validate:"dive"`
+	DropLines           []string      `json:"drop_lines" validate:"dive,required"`
+	NormalizeWhitespace bool          `json:"normalize_whitespace"`
+}
+
+// ReplaceRule — замена по регулярному выражению, применяется ко всему тексту.
+type ReplaceRule struct {
+	Pattern     string `json:"pattern" validate:"required"`
+	Replacement string `json:"replacement"`
 }
 
 type ConfigParams struct {
+	Name      string `db:"name" json:"name,omitempty"`
+	Provider  string `db:"provider" json:"provider,omitempty" validate:"omitempty,oneof=ollama openai cohere"`
 	Url       string `db:"llm_url" json:"llm_url,omitempty"`
 	Model     string `db:"llm_model" json:"llm_model,omitempty"`
 	PromptStr string `db:"prompt_str" json:"prompt_str,omitempty"`
+	APIKeyRef string `db:"api_key_ref" json:"api_key_ref,omitempty"`
+}
+
+// ProfileParams — профиль LLM, создаваемый или обновляемый по имени.
+type ProfileParams struct {
+	Name      string `json:"name" validate:"required"`
+	Provider  string `json:"provider" validate:"required,oneof=ollama openai cohere"`
+	Url       string `json:"llm_url" validate:"omitempty,http_url"`
+	Model     string `json:"llm_model"`
+	PromptStr string `json:"prompt_str"`
+	APIKeyRef string `json:"api_key_ref" validate:"omitempty,excludesall= "`
+	// Окно контекста и запас под ответ в токенах; 0 — по модели
+	ContextWindow   int `json:"context_window" validate:"gte=0"`
+	MaxAnswerTokens int `json:"max_answer_tokens" validate:"gte=0"`
+}
+
+// ChatSessionParams — новый диалог.
+type ChatSessionParams struct {
+	Title   string `json:"title" validate:"lte=200"`
+	Profile string `json:"profile"`
+}
+
+// UnansweredListParams — страница списка вопросов без ответа.
+type UnansweredListParams struct {
+	Limit int `query:"limit" validate:"gte=0,lte=1000"`
+}
+
+// PromptTemplateParams — новая версия шаблона промпта.
+type PromptTemplateParams struct {
+	Body        string `json:"body" validate:"required"`
+	Description string `json:"description"`
+}
+
+// PromptRenderParams — пробная подстановка переменных в шаблон: сохранённую
+// версию (0 — текущую) или черновик body.
+type PromptRenderParams struct {
+	Version int        `json:"version" validate:"gte=0"`
+	Body    string     `json:"body"`
+	Vars    PromptVars `json:"vars"`
+}
+
+// ChatSessionListParams — страница списка диалогов.
+type ChatSessionListParams struct {
+	Limit int `query:"limit" validate:"gte=0,lte=1000"`
 }
 
 func Validate(v Validater) map[string]string {
@@ -41,6 +135,19 @@ func (params *ConfigParams) Validate() map[string]string {
 	return nil
 }
 
+func (params *ProfileParams) Validate() map[string]string {
+	validate := validator.New()
+	if err := validate.Struct(params); err != nil {
+		errs := err.(validator.ValidationErrors)
+		errors := make(map[string]string)
+		for _, e := range errs {
+			errors[e.Field()] = fmt.Sprintf("failed on '%s' tag", e.Tag())
+		}
+		return errors
+	}
+	return nil
+}
+
 func (params *QueryParams) Validate() map[string]string {
 	validate := validator.New()
 	if err := validate.Struct(params); err != nil {
@@ -55,6 +162,123 @@ func (params *QueryParams) Validate() map[string]string {
 	return nil
 }
 
+func (params *ChatSessionParams) Validate() map[string]string {
+	validate := validator.New()
+	if err := validate.Struct(params); err != nil {
+		errs := err.(validator.ValidationErrors)
+		errors := make(map[string]string)
+		for _, e := range errs {
+			errors[e.Field()] = fmt.Sprintf("failed on '%s' tag", e.Tag())
+		}
+		return errors
+	}
+	return nil
+}
+
+func (params *ChatSessionListParams) Validate() map[string]string {
+	validate := validator.New()
+	if err := validate.Struct(params); err != nil {
+		errs := err.(validator.ValidationErrors)
+		errors := make(map[string]string)
+		for _, e := range errs {
+			errors[e.Field()] = fmt.Sprintf("failed on '%s' tag", e.Tag())
+		}
+		return errors
+	}
+	return nil
+}
+
+func (params *UnansweredListParams) Validate() map[string]string {
+	validate := validator.New()
+	if err := validate.Struct(params); err != nil {
+		errs := err.(validator.ValidationErrors)
+		errors := make(map[string]string)
+		for _, e := range errs {
+			errors[e.Field()] = fmt.Sprintf("failed on '%s' tag", e.Tag())
+		}
+		return errors
+	}
+	return nil
+}
+
+func (params *PromptTemplateParams) Validate() map[string]string {
+	validate := validator.New()
+	if err := validate.Struct(params); err != nil {
+		errs := err.(validator.ValidationErrors)
+		errors := make(map[string]string)
+		for _, e := range errs {
+			errors[e.Field()] = fmt.Sprintf("failed on '%s' tag", e.Tag())
+		}
+		return errors
+	}
+	return nil
+}
+
+func (params *PromptRenderParams) Validate() map[string]string {
+	validate := validator.New()
+	if err := validate.Struct(params); err != nil {
+		errs := err.(validator.ValidationErrors)
+		errors := make(map[string]string)
+		for _, e := range errs {
+			errors[e.Field()] = fmt.Sprintf("failed on '%s' tag", e.Tag())
+		}
+		return errors
+	}
+	return nil
+}
+
+func (params *CrawlParams) Validate() map[string]string {
+	validate := validator.New()
+	if err := validate.Struct(params); err != nil {
+		errs := err.(validator.ValidationErrors)
+		errors := make(map[string]string)
+		for _, e := range errs {
+			errors[e.Field()] = fmt.Sprintf("failed on '%s' tag", e.Tag())
+		}
+		return errors
+	}
+	return nil
+}
+
+func (params *JobListParams) Validate() map[string]string {
+	validate := validator.New()
+	if err := validate.Struct(params); err != nil {
+		errs := err.(validator.ValidationErrors)
+		errors := make(map[string]string)
+		for _, e := range errs {
+			errors[e.Field()] = fmt.Sprintf("failed on '%s' tag", e.Tag())
+		}
+		return errors
+	}
+	return nil
+}
+
+func (params *SourceSettings) Validate() map[string]string {
+	validate := validator.New()
+	if err := validate.Struct(params); err != nil {
+		errs := err.(validator.ValidationErrors)
+		errors := make(map[string]string)
+		for _, e := range errs {
+			errors[e.Field()] = fmt.Sprintf("failed on '%s' tag", e.Tag())
+		}
+		return errors
+	}
+	return nil
+}
+
+func (params *CleanupRuleSet) Validate() map[string]string {
+	validate := validator.New()
+	if err := validate.Struct(params); err != nil {
+		errs := err.(validator.ValidationErrors)
+		errors := make(map[string]string)
+		for _, e := range errs {
+			errors[e.Field()] = fmt.Sprintf("failed on '%s' tag", e.Tag())
+		}
+		return errors
+	}
+	return nil
+}
+
 func NewValidationError(errors map[string]string) ValidationError {
 	return ValidationError{
 		Status: http.StatusUnprocessableEntity,
@@ -72,14 +296,72 @@ func (e ValidationError) Error() string {
 }
 
 type SearchResponse struct {
-	Answer     string    `json:"answer"`
-	Sources    []Source  `json:"sources"`
-	Confidence float64   `json:"confidence"`
-	Timestamp  time.Time `json:"timestamp"`
+	LanguageInfo
+	Answer            string       `json:"answer"`
+	Answered          bool         `json:"answered"`                     // false — в документации ответа не нашлось
+	Suggestions       []Suggestion `json:"suggestions,omitempty"`        // Ближайшие документы, если ответа нет
+	SuggestionsHeader string       `json:"suggestions_header,omitempty"` // Заголовок списка ближайших документов на языке ответа
+	Sources           []Source     `json:"sources"`
+	InvalidCitations  []int        `json:"invalid_citations,omitempty"` // Номера несуществующих источников, удалённые из ссылок ответа
+	Grounding         *Grounding   `json:"grounding,omitempty"`         // Заполняется, если включена проверка ответа
+	Prompt            *PromptRef   `json:"prompt,omitempty"`            // Шаблон, по которому построен промпт ответа
+	Budget            *TokenBudget `json:"budget,omitempty"`            // Распределение окна модели между промптом, контекстом и ответом
+	Confidence        float64      `json:"confidence"`
+	Timestamp         time.Time    `json:"timestamp"`
 }
+
+// Suggestion — документ или раздел, ближайший к вопросу без ответа.
+type Suggestion struct {
+	DocID    string  `json:"doc_id"`
+	Title    string  `json:"title"`
+	Section  string  `json:"section,omitempty"`
+	Distance float64 `json:"distance"`
+}
+
 type Source struct {
 	DocID     string `json:"doc_id"`
 	Title     string `json:"title"`
 	ChunkText string `json:"chunk_text"`
 	Index     int    `json:"index"`
+	Number    int    `json:"number"` // Номер блока контекста, на который ссылается ответ как [n]
+	Cited     bool   `json:"cited"`  // Есть ли в ответе ссылка на источник
+}
+
+// TokenBudget — распределение окна контекста модели в токенах.
+// Context не превышает ContextBudget = Window - AnswerReserve - Prompt.
+type TokenBudget struct {
+	Window        int `json:"window"`         // Окно контекста модели
+	AnswerReserve int `json:"answer_reserve"` // Оставлено под ответ
+	Prompt        int `json:"prompt"`         // Системный промпт, история и шаблон с вопросом
+	ContextBudget int `json:"context_budget"` // Доступно под контекст
+	Context       int `json:"context"`        // Занято контекстом
+	Chunks        int `json:"chunks"`         // Блоков контекста в промпте
+	DroppedChunks int `json:"dropped_chunks"` // Блоков, не поместившихся в бюджет
+	// Ранних реплик диалога, не поместившихся в долю окна под историю
+	DroppedHistory int    `json:"dropped_history,omitempty"`
+	Tokenizer      string `json:"tokenizer"` // Кодировка подсчёта; «~» — приблизительный подсчёт
+}
+
+// StreamTimings — длительность этапов ответа в миллисекундах.
+type StreamTimings struct {
+	RetrievalMs  int64 `json:"retrieval_ms"`
+	GenerationMs int64 `json:"generation_ms"`
+	TotalMs      int64 `json:"total_ms"`
+}
+
+// StreamDone — последнее событие потокового ответа. Источники повторяются
+// с отметками о цитировании, известными только после генерации.
+type StreamDone struct {
+	LanguageInfo
+	Answered          bool          `json:"answered"`
+	Suggestions       []Suggestion  `json:"suggestions,omitempty"`
+	SuggestionsHeader string        `json:"suggestions_header,omitempty"`
+	Sources           []Source      `json:"sources"`
+	InvalidCitations  []int         `json:"invalid_citations,omitempty"`
+	Grounding         *Grounding    `json:"grounding,omitempty"`
+	Prompt            *PromptRef    `json:"prompt,omitempty"`
+	Budget            *TokenBudget  `json:"budget,omitempty"`
+	Confidence        float64       `json:"confidence"`
+	Timings           StreamTimings `json:"timings"`
+	Timestamp         time.Time     `json:"timestamp"`
 }
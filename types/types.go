@@ -1,6 +1,7 @@
 package types
 
 import (
+	"crypto/md5"
 	"database/sql"
 	"time"
 
@@ -28,6 +29,9 @@ type Chunk struct {
 	Content   string
 	Embedding []float32
 	Distance  float64
+	// sha256 типа и исходного текста (или изображения) чанка: по нему при
+	// повторной загрузке находится неизменившийся чанк и его эмбеддинг
+	ContentHash string
 }
 
 type FullTable struct {
@@ -38,17 +42,42 @@ type FullTable struct {
 }
 
 type Document struct {
-	ID         uuid.UUID // Уникальный идентификатор документа
-	Title      string    // Заголовок документа
-	Chunks     []Chunk
-	FullTable  []FullTable
-	Source     string    // Источник документа (confluence, pdf, etc.)
-	SourcePath string    // URL или путь к источнику
-	CreatedAt  time.Time // Время создания
-	UpdatedAt  time.Time // Время последнего обновления
-	Version    int       // Версия документа
+	ID          uuid.UUID // Уникальный идентификатор документа
+	Title       string    // Заголовок документа
+	Chunks      []Chunk
+	FullTable   []FullTable
+	Source      string            // Источник документа (confluence, pdf, etc.)
+	SourcePath  string            // URL или путь к источнику
+	CreatedAt   time.Time         // Время создания
+	UpdatedAt   time.Time         // Время последнего обновления
+	Version     int               // Версия документа
+	ParentID    uuid.NullUUID     // Родительский документ (например, письмо для вложения)
+	Metadata    map[string]string // Дополнительные атрибуты источника (заголовки письма и т.п.)
+	Converter   string            // Конвертер, которым получен текст документа
+	Collection  string            // Коллекция, по подкаталогу источника
+	Tags        []string          // Теги, по вложенным подкаталогам источника
+	ContentHash string            // sha256 исходного файла; если задан, ID документа выводится из него
+	LogicalPath string            // Путь файла без версии/коммита для таблицы document_paths
 }
 
+// DocumentPath — логический путь файла, указывающий на документ.
+type DocumentPath struct {
+	Path       string
+	DocID      uuid.UUID
+	Source     string
+	SourcePath string // Новый source_path документа, пусто — не менять
+	UpdatedAt  time.Time
+}
+
+// Конвертеры исходных файлов в markdown
+const (
+	ConverterDocling  = "docling"
+	ConverterPDFCPU   = "pdfcpu"
+	ConverterMarkdown = "markdown"
+	ConverterHTML     = "html"
+	ConverterEmail    = "email"
+)
+
 type Config struct {
 	MonitoringTime time.Duration
 	SourceDir      string
@@ -56,16 +85,316 @@ type Config struct {
 	BadDir         string
 	ChunkSize      int
 	ChunkOverlap   int
+	Tree           TreeConfig
+	Web            WebConfig
+	Docling        DoclingConfig
+	Workers        WorkersConfig
+	Jobs           JobsConfig
+	Watch          WatchConfig
+}
+
+// JobsConfig задаёт поведение очереди заданий загрузки.
+type JobsConfig struct {
+	MaxAttempts  int           // Попыток до перевода задания в dead
+	Backoff      time.Duration // Задержка перед первым повтором, далее удваивается
+	MaxBackoff   time.Duration
+	Lease        time.Duration // Через сколько зависшее задание снова можно забрать
+	PollInterval time.Duration
+}
+
+// WatchConfig задаёт наблюдение за каталогом-источником.
+type WatchConfig struct {
+	Include      []string      // Glob-шаблоны относительных путей, пусто — все файлы
+	Exclude      []string      // Glob-шаблоны исключаемых путей
+	SubdirMode   string        // collection | tags | none
+	PollInterval time.Duration // Период сканирования без inotify
+	Rescan       time.Duration // Период полного пересканирования при работающем inotify
+}
+
+// Режимы разметки документов по подкаталогам источника
+const (
+	SubdirCollection = "collection" // Первый уровень — коллекция, глубже — теги
+	SubdirTags       = "tags"       // Все уровни — теги
+	SubdirNone       = "none"
+)
+
+// WorkersConfig задаёт размер пула обработки файлов и ограничения
+// параллельности отдельных этапов, общие для всех воркеров.
+type WorkersConfig struct {
+	Files   int // Число файлов, обрабатываемых одновременно
+	Convert int // Одновременные конвертации (Docling / pdfcpu)
+	Vision  int // Одновременные запросы к vision-модели
+	Embed   int // Одновременные запросы эмбеддингов
+}
+
+// TreeConfig описывает источник документов в виде дерева каталогов
+// или git-репозитория.
+type TreeConfig struct {
+	Dir       string        // Локальный каталог (рабочая копия репозитория)
+	GitURL    string        // URL или путь репозитория для clone/pull, пусто — без git
+	GitBranch string        // Ветка репозитория
+	Globs     []string      // Шаблоны файлов относительно Dir (поддерживается **)
+	Interval  time.Duration // Период повторного обхода
+}
+
+// WebConfig задаёт параметры обхода веб-страниц.
+type WebConfig struct {
+	UserAgent    string
+	Timeout      time.Duration
+	Delay        time.Duration // Пауза между загрузками страниц
+	PollInterval time.Duration // Период проверки новых заявок на обход
+	Lease        time.Duration // Через сколько заявку без продления можно забрать снова
+}
+
+type CrawlStatus string
+
+const (
+	CrawlPending CrawlStatus = "pending"
+	CrawlRunning CrawlStatus = "running"
+	CrawlDone    CrawlStatus = "done"
+	CrawlFailed  CrawlStatus = "failed"
+)
+
+// Crawl — заявка на обход сайта, созданная через API.
+type Crawl struct {
+	ID        uuid.UUID   `json:"id"`
+	SeedURL   string      `json:"seed_url"`
+	MaxDepth  int         `json:"max_depth"`
+	MaxPages  int         `json:"max_pages"`
+	Status    CrawlStatus `json:"status"`
+	Pages     int         `json:"pages"`
+	Error     string      `json:"error,omitempty"`
+	CreatedAt time.Time   `json:"created_at"`
+	UpdatedAt time.Time   `json:"updated_at"`
+}
+
+type JobState string
+
+const (
+	JobQueued     JobState = "queued"
+	JobProcessing JobState = "processing"
+	JobDone       JobState = "done"
+	JobDead       JobState = "dead"
+)
+
+// JobStage — этап обработки задания, подробнее состояния: по нему клиент
+// следит за загрузкой файла.
+type JobStage string
+
+const (
+	JobStageQueued     JobStage = "queued"
+	JobStageStarted    JobStage = "started"
+	JobStageConverting JobStage = "converting"
+	JobStageDescribing JobStage = "describing_images"
+	JobStageEmbedding  JobStage = "embedding"
+	JobStageSaving     JobStage = "saving"
+	JobStageSaved      JobStage = "saved"
+	JobStageFailed     JobStage = "failed"
+)
+
+// Job — задание на загрузку файла из каталога-источника.
+type Job struct {
+	ID         uuid.UUID        `json:"id"`
+	Path       string           `json:"path"`
+	Source     string           `json:"source"`
+	State      JobState         `json:"state"`
+	Stage      JobStage         `json:"stage"`
+	Attempts   int              `json:"attempts"`
+	LastError  string           `json:"last_error,omitempty"`
+	BadPath    string           `json:"bad_path,omitempty"` // Куда файл перемещён после перевода в dead
+	Timings    map[string]int64 `json:"timings_ms"`         // Длительность этапов последней попытки, мс
+	Chunks     ChunkStats       `json:"chunks"`
+	NextRunAt  time.Time        `json:"next_run_at"`
+	CreatedAt  time.Time        `json:"created_at"`
+	UpdatedAt  time.Time        `json:"updated_at"`
+	StartedAt  *time.Time       `json:"started_at,omitempty"`
+	FinishedAt *time.Time       `json:"finished_at,omitempty"`
+}
+
+// UploadResult — ответ на загрузку файла через API.
+type UploadResult struct {
+	Path       string     `json:"path"`
+	JobID      uuid.UUID  `json:"job_id"`
+	DocumentID *uuid.UUID `json:"document_id,omitempty"` // Для писем документов несколько, id не известен заранее
+	Job        *Job       `json:"job"`
 }
 
+// JobEvent — событие SSE-потока задания.
+type JobEvent struct {
+	JobID    uuid.UUID `json:"job_id"`
+	State    JobState  `json:"state"`
+	Stage    JobStage  `json:"stage"`
+	Attempts int       `json:"attempts"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// DocumentIDFromHash возвращает id документа по sha256 его содержимого.
+func DocumentIDFromHash(hash string) uuid.UUID {
+	sum := md5.Sum([]byte(hash))
+	return uuid.UUID(sum)
+}
+
+// ChunkStats — сколько чанков взято из предыдущей версии документа
+// и сколько посчитано заново.
+type ChunkStats struct {
+	Reused   int `json:"reused"`
+	Computed int `json:"computed"`
+}
+
+// Роли сообщений чата
+const (
+	ChatRoleUser      = "user"
+	ChatRoleAssistant = "assistant"
+)
+
+// ChatSession — диалог с историей вопросов и ответов.
+type ChatSession struct {
+	ID        uuid.UUID `json:"id"`
+	Title     string    `json:"title"`
+	Profile   string    `json:"profile"` // Профиль LLM диалога; пустой — профиль по умолчанию
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ChatMessage — реплика диалога. У вопроса хранится самостоятельный запрос,
+// по которому искался контекст, у ответа — источники и уверенность.
+type ChatMessage struct {
+	ID         uuid.UUID  `json:"id"`
+	SessionID  uuid.UUID  `json:"session_id"`
+	Role       string     `json:"role"`
+	Content    string     `json:"content"`
+	Query      string     `json:"query,omitempty"`
+	Sources    []Source   `json:"sources,omitempty"`
+	Confidence float64    `json:"confidence,omitempty"`
+	Grounding  *Grounding `json:"grounding,omitempty"`
+	// Номера несуществующих источников, удалённые из ссылок ответа; не хранятся
+	InvalidCitations []int `json:"invalid_citations,omitempty"`
+	// Только у ответов: false — в документации ответа не нашлось,
+	// вместо источников предложены ближайшие документы
+	Answered    *bool        `json:"answered,omitempty"`
+	Suggestions []Suggestion `json:"suggestions,omitempty"`
+	// Заголовок списка ближайших документов на языке ответа; не хранится
+	SuggestionsHeader string       `json:"suggestions_header,omitempty"`
+	Prompt            *PromptRef   `json:"prompt,omitempty"`
+	Budget            *TokenBudget `json:"budget,omitempty"`
+	*LanguageInfo
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ChatHistory — диалог вместе с сообщениями.
+type ChatHistory struct {
+	ChatSession
+	Messages []ChatMessage `json:"messages"`
+}
+
+// ClaimStatus — насколько утверждение ответа подтверждается контекстом.
+type ClaimStatus string
+
+const (
+	ClaimSupported   ClaimStatus = "supported"
+	ClaimPartial     ClaimStatus = "partial"
+	ClaimUnsupported ClaimStatus = "unsupported"
+	ClaimUnverified  ClaimStatus = "unverified" // Судья не ответил, а чисел для сверки нет
+)
+
+// ClaimCheck — результат проверки одного утверждения ответа.
+type ClaimCheck struct {
+	Claim         string      `json:"claim"`
+	Status        ClaimStatus `json:"status"`
+	MissingValues []string    `json:"missing_values,omitempty"` // Числа и коды, которых нет в контексте
+}
+
+// Grounding — проверка ответа на опору на найденный контекст.
+type Grounding struct {
+	// Доля подтверждённых утверждений, частично подтверждённые — с весом 0.5,
+	// непроверенные — с весом 0. nil — ни одно утверждение проверить не удалось
+	Score  *float64     `json:"score"`
+	Claims []ClaimCheck `json:"claims"`
+	Action string       `json:"action,omitempty"` // regenerated или refused, если ответ заменён; unverified, если проверка не удалась
+}
+
+// UnansweredQuestion — вопрос, для которого поиск не нашёл релевантных чанков.
+type UnansweredQuestion struct {
+	ID           uuid.UUID  `json:"id"`
+	Question     string     `json:"question"`
+	Query        string     `json:"query,omitempty"` // Запрос поиска, если он отличается от вопроса (в диалоге)
+	Profile      string     `json:"profile,omitempty"`
+	SessionID    *uuid.UUID `json:"session_id,omitempty"`
+	BestDistance float64    `json:"best_distance"` // Близость лучшего чанка, не прошедшего фильтр
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// PromptTemplate — версия шаблона промпта в синтаксисе text/template.
+// Каждое сохранение создаёт новую версию; используется последняя.
+// Версия 0 — встроенный шаблон, действующий, пока в БД версий нет.
+type PromptTemplate struct {
+	Name        string    `json:"name"`
+	Version     int       `json:"version"`
+	Body        string    `json:"body"`
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	// Удалённая версия не действует, но остаётся доступной по номеру,
+	// чтобы по PromptRef в сохранённых ответах был виден её текст
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// PromptRef указывает, какой версией шаблона построен промпт.
+type PromptRef struct {
+	Name    string `json:"name"`
+	Version int    `json:"version"`
+}
+
+// PromptVars — переменные, доступные в шаблонах промптов.
+type PromptVars struct {
+	Context  string   `json:"context"`  // Пронумерованные фрагменты найденных документов
+	Question string   `json:"question"` // Вопрос пользователя
+	History  string   `json:"history"`  // Предыдущие реплики диалога, по одной на строку
+	Language string   `json:"language"` // Язык ответа; пустой — язык вопроса
+	Claims   []string `json:"claims"`   // Утверждения ответа для проверки
+	Text     string   `json:"text"`     // Текст для корректуры
+}
+
+// LLMConfig — профиль LLM из таблицы config.
 type LLMConfig struct {
-	Url       string
-	Model     string
-	PromptStr string
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	Provider  string `json:"provider"` // ollama, openai или cohere
+	Url       string `json:"llm_url"`
+	Model     string `json:"llm_model"`
+	PromptStr string `json:"prompt_str"`
+	APIKeyRef string `json:"api_key_ref,omitempty"` // Имя переменной окружения или секрета с ключом API
+	// Окно контекста модели в токенах; 0 — по модели. Для Ollama
+	// передаётся в запросе как num_ctx
+	ContextWindow   int `json:"context_window"`
+	MaxAnswerTokens int `json:"max_answer_tokens"` // Запас под ответ в токенах; 0 — по умолчанию
 }
 
 type DoclingResponse struct {
 	Document struct {
 		MdContent string `json:"md_content"`
 	} `json:"document"`
+	Status string `json:"status"`
+	Errors []struct {
+		ErrorMessage string `json:"error_message"`
+	} `json:"errors"`
+}
+
+// DoclingConfig задаёт подключение к docling-serve.
+type DoclingConfig struct {
+	URL          string
+	Timeout      time.Duration // Таймаут одного HTTP-запроса
+	Retries      int           // Повторы при недоступности сервиса
+	RetryDelay   time.Duration
+	AsyncMinSize int64         // Файлы от этого размера конвертируются асинхронно, 0 — всегда синхронно
+	PollInterval time.Duration // Период опроса статуса асинхронной задачи
+	TaskTimeout  time.Duration // Максимальное время ожидания асинхронной задачи
+	Options      DoclingOptions
+}
+
+// DoclingOptions — параметры конвертации, передаваемые в Docling как есть.
+type DoclingOptions struct {
+	DoOCR           *bool
+	OCRLang         []string
+	TableMode       string // fast | accurate
+	ImageExportMode string // placeholder | embedded | referenced
 }